@@ -2,11 +2,17 @@ package client
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,38 +28,40 @@ func (br Response) Success() bool {
 
 type HttpClient struct {
 	protocol          string
-	coinName          string
 	rechargeNotifyUrl string
 	withdrawNotifyUrl string
+	secret            string // 签名密钥 为空则不签名
 	client            *http.Client
 }
 
 // NewHttpClient 创建
-func NewHttpClient(protocol, coinName, rechargeNotifyUrl, withdrawNotifyUrl string) *HttpClient {
+func NewHttpClient(protocol, rechargeNotifyUrl, withdrawNotifyUrl, secret string) *HttpClient {
 	return &HttpClient{
 		protocol,
-		coinName,
 		rechargeNotifyUrl,
 		withdrawNotifyUrl,
+		secret,
 		&http.Client{
 			Timeout: time.Millisecond * time.Duration(10*1000),
 		},
 	}
 }
 
-// RechargeSuccess 充值成功通知
-func (h *HttpClient) RechargeSuccess(hash string, status uint, address string, value int64) error {
+// RechargeSuccess 充值成功通知 id 为事件ID 业务服务用交易哈希加日志序号做幂等
+func (h *HttpClient) RechargeSuccess(id, hash string, logIndex uint, status int32, coinName, address, value string) error {
 
 	data := make(map[string]interface{})
+	data["id"] = id
 	data["protocol"] = h.protocol
-	data["coinName"] = h.coinName
+	data["coinName"] = coinName
 	data["hash"] = hash
+	data["logIndex"] = logIndex
 	data["status"] = status
 	data["address"] = address
 	data["value"] = value
 
 	var res Response
-	err := h.post(h.withdrawNotifyUrl, data, &res)
+	err := h.post(h.rechargeNotifyUrl, data, &res)
 	if err != nil {
 		return err
 	}
@@ -66,12 +74,14 @@ func (h *HttpClient) RechargeSuccess(hash string, status uint, address string, v
 }
 
 // WithdrawSuccess 提现成功通知
-func (h *HttpClient) WithdrawSuccess(hash string, status uint, orderId string, address string, value int64) error {
+func (h *HttpClient) WithdrawSuccess(id, hash string, logIndex uint, status int32, orderId, coinName, address, value string) error {
 
 	data := make(map[string]interface{})
+	data["id"] = id
 	data["protocol"] = h.protocol
-	data["coinName"] = h.coinName
+	data["coinName"] = coinName
 	data["hash"] = hash
+	data["logIndex"] = logIndex
 	data["status"] = status
 	data["orderId"] = orderId
 	data["address"] = address
@@ -91,6 +101,15 @@ func (h *HttpClient) WithdrawSuccess(hash string, status uint, orderId string, a
 	return nil
 }
 
+// Sign 计算签名 HMAC-SHA256(secret, timestamp + "." + body) 的十六进制
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // get 请求
 func (h *HttpClient) get(urlStr string, params url.Values, res interface{}) error {
 
@@ -129,34 +148,62 @@ func (h *HttpClient) get(urlStr string, params url.Values, res interface{}) erro
 
 // post 请求
 func (h *HttpClient) post(urlStr string, data map[string]interface{}, res interface{}) error {
+	_, err := h.send(urlStr, []string{h.secret}, data, res)
+	return err
+}
+
+// Deliver 投递订阅的通知 每个密钥各生成一个签名 响应 2xx 即为成功 返回响应状态码
+func (h *HttpClient) Deliver(urlStr string, secrets []string, data map[string]interface{}) (int, error) {
+	return h.send(urlStr, secrets, data, nil)
+}
+
+// send 发送签名的 post 请求 res 不为空时解析响应体
+func (h *HttpClient) send(urlStr string, secrets []string, data map[string]interface{}, res interface{}) (int, error) {
 	bytesData, err := json.Marshal(data)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	req, err := http.NewRequest(http.MethodPost, urlStr, bytes.NewReader(bytesData))
 	if err != nil {
 		// handle error
-		return err
+		return 0, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var signs []string
+	for _, secret := range secrets {
+		if secret != "" {
+			signs = append(signs, "sha256="+Sign(secret, timestamp, bytesData))
+		}
+	}
+	if len(signs) > 0 {
+		req.Header.Set("X-Wallet-Timestamp", timestamp)
+		req.Header.Set("X-Wallet-Signature", strings.Join(signs, ","))
+	}
 	resp, err := h.client.Do(req)
 	if err != nil {
 		// handle error
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		// handle error
-		return err
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("notify %s response status %d", urlStr, resp.StatusCode)
+	}
+	if res == nil {
+		return resp.StatusCode, nil
 	}
 
 	err = json.Unmarshal(body, res)
 	if err != nil {
-		return err
+		return resp.StatusCode, err
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
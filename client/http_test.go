@@ -0,0 +1,33 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRechargeSuccess(t *testing.T) {
+	var path, signature, timestamp string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		signature = r.Header.Get("X-Wallet-Signature")
+		timestamp = r.Header.Get("X-Wallet-Timestamp")
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"code":0,"message":"OK"}`))
+	}))
+	defer srv.Close()
+
+	h := NewHttpClient("eth", srv.URL+"/recharge", srv.URL+"/withdraw", "secret")
+	err := h.RechargeSuccess("deposit:0x01:0", "0x01", 0, 1, "", "0x02", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/recharge" {
+		t.Fatalf("recharge posted to %s", path)
+	}
+	if signature != "sha256="+Sign("secret", timestamp, body) {
+		t.Fatalf("bad signature %s", signature)
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/jinzhu/configor"
 	"github.com/lmxdawn/wallet/db"
 	"github.com/redis/go-redis/v9"
@@ -10,14 +11,43 @@ import (
 )
 
 type AppConfig struct {
-	Port uint `yaml:"port"`
+	Port       uint   `yaml:"port"`
+	AdminToken string `yaml:"admin_token"` // 管理接口的令牌 为空则不开放管理接口
+	SiweDomain string `yaml:"siwe_domain"` // 以太坊登录消息中的域名 为空则不开放以太坊登录
 }
 
 type EngineConfig struct {
-	Network string `yaml:"network"` // 网络名称（暂时BTC协议有用{MainNet：主网，TestNet：测试网，TestNet3：测试网3，SimNet：测试网}）
-	Rpc     string `yaml:"rpc"`     // rpc配置
-	User    string `yaml:"user"`    // rpc用户名（没有则为空）
-	Pass    string `yaml:"pass"`    // rpc密码（没有则为空）
+	Network             string            `yaml:"network"`                              // 网络名称（暂时BTC协议有用{MainNet：主网，TestNet：测试网，TestNet3：测试网3，SimNet：测试网}）
+	Rpc                 string            `yaml:"rpc"`                                  // rpc配置
+	User                string            `yaml:"user"`                                 // rpc用户名（没有则为空）
+	Pass                string            `yaml:"pass"`                                 // rpc密码（没有则为空）
+	BlockInit           uint64            `yaml:"block_init"`                           // 初始块（为0则从上次处理到的区块继续，没有记录则读取最新块）
+	BlockAfterTime      uint64            `yaml:"block_after_time" default:"5"`         // 获取最新块的等待时间（秒）
+	BlockCount          uint64            `yaml:"block_count" default:"8"`              // 区块worker数量
+	BlockWindow         uint64            `yaml:"block_window" default:"64"`            // 同时处理中的最大区块数量（超过后暂停派发区块）
+	ReceiptCount        uint64            `yaml:"receipt_count" default:"8"`            // 交易凭证worker数量
+	ReceiptAfterTime    uint64            `yaml:"receipt_after_time" default:"3"`       // 获取交易凭证失败后的等待时间（秒）
+	Confirms            uint64            `yaml:"confirms" default:"5"`                 // 确认数量
+	TrackDeployments    bool              `yaml:"track_deployments"`                    // 本服务钱包部署的合约是否自动加入监听
+	RechargeNotifyUrl   string            `yaml:"recharge_notify_url"`                  // 充值通知回调地址
+	WithdrawNotifyUrl   string            `yaml:"withdraw_notify_url"`                  // 提现通知回调地址
+	NotifySecret        string            `yaml:"notify_secret"`                        // 通知签名密钥
+	NotifyMaxAttempts   int               `yaml:"notify_max_attempts" default:"10"`     // 通知最大投递次数 超过后进入死信队列
+	StuckAfterTime      uint64            `yaml:"stuck_after_time" default:"120"`       // 交易广播多久后没有打包认为卡住（秒）
+	MonitorAfterTime    uint64            `yaml:"monitor_after_time" default:"15"`      // 检查卡住交易的间隔（秒）
+	MaxBumps            int               `yaml:"max_bumps" default:"3"`                // 卡住的交易最多自动加速的次数 为0则不自动加速
+	MaxFeeGwei          uint64            `yaml:"max_fee_gwei"`                         // 自动加速允许的最高 maxFeePerGas（gwei）为0则不限制
+	WithdrawPrivateKey  string            `yaml:"withdraw_private_key"`                 // 提现热钱包的私钥 为空则不开放提现接口
+	DisperseAddress     string            `yaml:"disperse_address"`                     // 批量打款的 Disperse 合约地址 为空则只能逐笔发送
+	CollectionAddress   string            `yaml:"collection_address"`                   // 归集地址 为空则不开放归集
+	CollectionAfterTime uint64            `yaml:"collection_after_time" default:"3600"` // 定时归集的间隔（秒）
+	CollectionCount     uint64            `yaml:"collection_count" default:"4"`         // 归集发送worker数量
+	CollectionMax       string            `yaml:"collection_max"`                       // 原生币的归集阈值（最小单位 满足多少才归集）为空或0则不归集原生币
+	CollectionCoins     map[string]string `yaml:"collection_coins"`                     // 代币的归集阈值 合约地址 -> 阈值（最小单位）没有配置的代币不归集
+	GasPrivateKey       string            `yaml:"gas_private_key"`                      // 加油钱包的私钥 只有代币的地址转出前由它补足手续费 为空则不补
+	GasDailyBudget      string            `yaml:"gas_daily_budget"`                     // 加油钱包每天（UTC）最多补的原生币（最小单位）为空或0则不限制
+	LogsChunk           uint64            `yaml:"logs_chunk" default:"2000"`            // eth_getLogs 每次向节点查询的区块数
+	LogsMaxBlocks       uint64            `yaml:"logs_max_blocks" default:"1000000"`    // eth_getLogs 一次查询最多的区块数
 }
 
 type Config struct {
@@ -38,11 +68,30 @@ func NewConfig(confPath string) (Config, error) {
 			return config, err
 		}
 	}
+	if err := config.validate(); err != nil {
+		return config, err
+	}
 	// 将配置文件写入数据库
 	loadToDB(&config)
 	return config, nil
 }
 
+// validate 检查区块流水线的配置 worker 数量和窗口为 0 时流水线会卡住
+func (c *Config) validate() error {
+	for i, e := range c.Engines {
+		for name, v := range map[string]uint64{
+			"block_count":   e.BlockCount,
+			"block_window":  e.BlockWindow,
+			"receipt_count": e.ReceiptCount,
+		} {
+			if v == 0 {
+				return fmt.Errorf("engines[%d].%s must be at least 1", i, name)
+			}
+		}
+	}
+	return nil
+}
+
 func (c Config) MarshalBinary() ([]byte, error) {
 	return json.Marshal(c)
 }
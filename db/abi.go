@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ContractAbi 上传的合约 ABI
+type ContractAbi struct {
+	Network   string // 网络名称
+	Address   string // 合约地址
+	Name      string // 合约名称 方便查看
+	Abi       string // ABI 的 JSON
+	UpdatedAt int64  // 更新时间 毫秒级时间戳
+}
+
+func (a ContractAbi) MarshalBinary() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+// SetContractAbi 保存合约的 ABI 已经存在时覆盖
+func SetContractAbi(a *ContractAbi) error {
+	if _, err := Rdb.HSet(context.Background(), AbiDB+":"+a.Network, a.Address, a).Result(); err != nil {
+		log.Error().Msgf("SetContractAbi err is %s ", err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetContractAbi 获取合约的 ABI 没有上传返回 nil
+func GetContractAbi(network, address string) *ContractAbi {
+	res, err := Rdb.HGet(context.Background(), AbiDB+":"+network, address).Result()
+	if err != nil {
+		return nil
+	}
+	a := &ContractAbi{}
+	if err := json.Unmarshal([]byte(res), a); err != nil {
+		log.Error().Msgf("GetContractAbi Unmarshal err is %s ", err.Error())
+		return nil
+	}
+	return a
+}
+
+// GetContractAbis 获取网络上所有上传的合约 ABI 按地址排序
+func GetContractAbis(network string) []*ContractAbi {
+	res, err := Rdb.HGetAll(context.Background(), AbiDB+":"+network).Result()
+	if err != nil {
+		log.Error().Msgf("GetContractAbis err is %s ", err.Error())
+		return nil
+	}
+	list := []*ContractAbi{}
+	for _, v := range res {
+		a := &ContractAbi{}
+		if err := json.Unmarshal([]byte(v), a); err != nil {
+			continue
+		}
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list
+}
+
+// DelContractAbi 删除合约的 ABI 没有上传返回 false
+func DelContractAbi(network, address string) (bool, error) {
+	n, err := Rdb.HDel(context.Background(), AbiDB+":"+network, address).Result()
+	if err != nil {
+		log.Error().Msgf("DelContractAbi err is %s ", err.Error())
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// 归集的触发方式
+const (
+	SweepSchedule = "schedule" // 定时归集
+	SweepManual   = "manual"   // 管理接口触发
+)
+
+// CollectionLogSize 只保留最近的归集报告
+const CollectionLogSize = 100
+
+// Sweep 一次归集的报告
+type Sweep struct {
+	Id         string
+	Trigger    string // schedule 定时 manual 手动
+	Preview    bool   // 只计算没有发送
+	To         string // 归集地址
+	Tier       string // 费用档位
+	StartedAt  int64
+	FinishedAt int64
+	Items      []*SweepItem
+	Totals     []*SweepTotal
+}
+
+// SweepItem 一个地址一个币种的归集结果 余额没有达到阈值的不记录
+type SweepItem struct {
+	Address  string
+	CoinName string // 币种合约地址 空字符串表示原生币
+	Balance  string
+	Amount   string // 归集的数量 原生币已扣除最高手续费
+	GasFee   string // 按 maxFeePerGas 计算的最高手续费
+	TopUp    string // 加油站补的原生币
+	Hash     string
+	Error    string // 发送失败或跳过的原因
+}
+
+// SweepTotal 每个币种的归集总额
+type SweepTotal struct {
+	CoinName string
+	Amount   string
+	Count    int
+}
+
+func (s Sweep) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// AddSweep 保存归集报告 只保留最近 CollectionLogSize 条
+func AddSweep(s *Sweep) {
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.LPush(context.Background(), CollectionDB, s)
+		pipe.LTrim(context.Background(), CollectionDB, 0, CollectionLogSize-1)
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("AddSweep err is %s ", err.Error())
+	}
+}
+
+// GetSweeps 获取最近的归集报告 最新的在前
+func GetSweeps(limit int64) []*Sweep {
+	res, err := Rdb.LRange(context.Background(), CollectionDB, 0, limit-1).Result()
+	if err != nil {
+		log.Error().Msgf("GetSweeps err is %s ", err.Error())
+		return nil
+	}
+	list := []*Sweep{}
+	for _, v := range res {
+		s := &Sweep{}
+		if err := json.Unmarshal([]byte(v), s); err != nil {
+			continue
+		}
+		list = append(list, s)
+	}
+	return list
+}
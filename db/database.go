@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"io"
 )
@@ -18,6 +19,8 @@ type CoinType struct {
 	ContractAddress string `json:"contractAddress"`
 	CoinName        string `json:"coinName"`
 	IsNFT           bool   `json:"isNFT"`
+	Symbol          string `json:"symbol"`   // 代币符号 添加时从合约读取
+	Decimals        uint8  `json:"decimals"` // 代币精度 添加时从合约读取
 }
 
 type Block struct {
@@ -53,23 +56,18 @@ type Database interface {
 }
 
 // UpDataCoinInfoToDB 想数据库中存入数据 判断这个是否存在
-func UpDataCoinInfoToDB(coinName, contractAddress string, isNFT bool) bool {
-	exit, err := Rdb.HExists(context.Background(), CoinDB, contractAddress).Result()
+func UpDataCoinInfoToDB(ct *CoinType) bool {
+	exit, err := Rdb.HExists(context.Background(), CoinDB, ct.ContractAddress).Result()
 	if err != nil {
 		log.Error().Msgf("UpDataCoinInfoToDB err is %s ", err.Error())
 		return false
 	}
 
 	if exit {
-		log.Info().Msgf("UpDataCoinInfoToDB has same ContractAddress is %s ", contractAddress)
+		log.Info().Msgf("UpDataCoinInfoToDB has same ContractAddress is %s ", ct.ContractAddress)
 		return false
 	}
-	ct := &CoinType{
-		ContractAddress: contractAddress,
-		CoinName:        coinName,
-		IsNFT:           isNFT,
-	}
-	_, err = Rdb.HSet(context.Background(), CoinDB, contractAddress, ct).Result()
+	_, err = Rdb.HSet(context.Background(), CoinDB, ct.ContractAddress, ct).Result()
 	if err != nil {
 		log.Error().Msgf("UpDataCoinInfoToDB Set err is %s ", err.Error())
 		return false
@@ -98,6 +96,28 @@ func GetAll20TokenFromDB() (data []*CoinType) {
 	return
 }
 
+// UpDataBlockCursor 记录某个网络已经按顺序处理完的最后一个区块
+func UpDataBlockCursor(network string, num uint64) error {
+	_, err := Rdb.HSet(context.Background(), BlockCursorDB, network, num).Result()
+	if err != nil {
+		log.Error().Msgf("UpDataBlockCursor err is %s ", err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetBlockCursor 读取某个网络已经处理完的最后一个区块 没有记录时返回 false
+func GetBlockCursor(network string) (uint64, bool) {
+	num, err := Rdb.HGet(context.Background(), BlockCursorDB, network).Uint64()
+	if err != nil {
+		if err != redis.Nil {
+			log.Error().Msgf("GetBlockCursor err is %s ", err.Error())
+		}
+		return 0, false
+	}
+	return num, true
+}
+
 // StoreBlockToDB 存储区块到数据库
 func StoreBlockToDB() {
 
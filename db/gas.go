@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GasSpend 加油站一天给一个币种补的手续费
+type GasSpend struct {
+	CoinName string // 需要手续费转出的代币合约地址
+	Value    string // 补的原生币总额（最小单位）
+	Count    int    // 补的次数
+}
+
+func (g GasSpend) MarshalBinary() ([]byte, error) {
+	return json.Marshal(g)
+}
+
+// GasDay 加油站预算的日期 按 UTC 计算
+func GasDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// AddGasSpend 记录一次补的手续费 调用方保证同一时间只有一次写入
+func AddGasSpend(day, coinName string, value *big.Int) error {
+	key := GasSpentDB + ":" + day
+	spend := &GasSpend{CoinName: coinName, Value: "0"}
+	res, err := Rdb.HGet(context.Background(), key, coinName).Result()
+	if err == nil {
+		_ = json.Unmarshal([]byte(res), spend)
+	}
+	total, ok := new(big.Int).SetString(spend.Value, 10)
+	if !ok {
+		total = big.NewInt(0)
+	}
+	spend.Value = total.Add(total, value).String()
+	spend.Count++
+	if _, err := Rdb.HSet(context.Background(), key, coinName, spend).Result(); err != nil {
+		log.Error().Msgf("AddGasSpend err is %s ", err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetGasSpends 获取一天每个币种补的手续费
+func GetGasSpends(day string) []*GasSpend {
+	res, err := Rdb.HGetAll(context.Background(), GasSpentDB+":"+day).Result()
+	if err != nil {
+		log.Error().Msgf("GetGasSpends err is %s ", err.Error())
+		return nil
+	}
+	list := []*GasSpend{}
+	for _, v := range res {
+		spend := &GasSpend{}
+		if err := json.Unmarshal([]byte(v), spend); err != nil {
+			continue
+		}
+		list = append(list, spend)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CoinName < list[j].CoinName })
+	return list
+}
+
+// GasSpentTotal 一天补的手续费总额
+func GasSpentTotal(spends []*GasSpend) *big.Int {
+	total := big.NewInt(0)
+	for _, s := range spends {
+		if v, ok := new(big.Int).SetString(s.Value, 10); ok {
+			total.Add(total, v)
+		}
+	}
+	return total
+}
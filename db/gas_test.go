@@ -0,0 +1,21 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGasDay(t *testing.T) {
+	// 按 UTC 计算 东八区的凌晨还是前一天
+	at := time.Date(2024, 3, 2, 5, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	if got := GasDay(at); got != "2024-03-01" {
+		t.Fatalf("gas day got %s", got)
+	}
+}
+
+func TestGasSpentTotal(t *testing.T) {
+	spends := []*GasSpend{{CoinName: "0xa", Value: "100"}, {CoinName: "0xb", Value: "23"}, {CoinName: "0xc", Value: "bad"}}
+	if got := GasSpentTotal(spends); got.Int64() != 123 {
+		t.Fatalf("gas spent total got %s", got)
+	}
+}
@@ -16,6 +16,50 @@ const (
 	LoginDB    = "Login"
 	CoinDB     = "Coin"
 	PolygonDB  = "PolygonBlock"
+	// BlockCursorDB 区块监听进度 field 为网络名称
+	BlockCursorDB = "BlockCursor"
+	NotifyDB      = "Notify"      // 通知发件箱 field 为事件ID
+	NotifyQueueDB = "NotifyQueue" // 待投递的事件 score 为下次投递时间
+	NotifyDeadDB  = "NotifyDead"  // 死信队列 score 为进入时间
+	// SubscriptionDB 账户的通知订阅 field 为订阅ID
+	SubscriptionDB = "Subscription"
+	// WebhookLogDB 订阅的投递记录 key 为 WebhookLog:订阅ID
+	WebhookLogDB = "WebhookLog"
+	// TransferIndexDB 地址的交易索引 key 为 TransferIndex:地址 和 TransferIndex:地址:币种
+	TransferIndexDB = "TransferIndex"
+	// 地址的 nonce 分配 key 为 前缀:地址
+	NonceDB         = "Nonce"         // 下一个新分配的 nonce
+	NonceReleasedDB = "NonceReleased" // 广播失败归还的 nonce
+	NonceInflightDB = "NonceInflight" // 已分配还没有广播结果的 nonce score 为分配时间
+	NonceSentDB     = "NonceSent"     // 已广播还没有确认的 nonce
+	// ReplaceDB 加速或取消的替换关系 field 为原交易哈希 值为替换交易哈希
+	ReplaceDB = "Replace"
+	// WithdrawDB 提现订单 field 为订单号
+	WithdrawDB = "Withdraw"
+	// WithdrawHashDB 提现交易和订单的对应 field 为交易哈希 值为订单号
+	WithdrawHashDB = "WithdrawHash"
+	// PayoutDB 批量打款 field 为批次号
+	PayoutDB = "Payout"
+	// PayoutItemDB 批量打款的明细 key 为 PayoutItem:批次号 field 为序号
+	PayoutItemDB = "PayoutItem"
+	// PayoutRawDB 批量打款签名后的交易 key 为 PayoutRaw:批次号 field 为交易哈希
+	PayoutRawDB = "PayoutRaw"
+	// PayoutHashDB 批量打款交易和批次的对应 field 为交易哈希 值为批次号
+	PayoutHashDB = "PayoutHash"
+	// CollectionDB 归集报告 列表 最新的在前
+	CollectionDB = "Collection"
+	// GasSpentDB 加油站每天给每个币种补的手续费 key 为 GasSpent:日期 field 为币种合约地址
+	GasSpentDB = "GasSpent"
+	// LogsDB 已经最终确认的日志分段 key 为 Logs:过滤条件哈希:起始区块-结束区块
+	LogsDB = "Logs"
+	// SiweNonceDB 以太坊登录的 nonce key 为 SiweNonce:nonce 使用后删除
+	SiweNonceDB = "SiweNonce"
+	// SiweAddressDB 以太坊登录的地址和账户的绑定 field 为地址 值为账户
+	SiweAddressDB = "SiweAddress"
+	// AbiDB 上传的合约 ABI key 为 Abi:网络名称 field 为合约地址
+	AbiDB = "Abi"
+	// TokenDB 代币的元数据 key 为 Token:网络名称 field 为合约地址
+	TokenDB = "Token"
 )
 
 // Init 数据库链接初始化
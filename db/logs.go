@@ -0,0 +1,27 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LogsCacheTTL 已经最终确认的日志分段缓存时间
+const LogsCacheTTL = 7 * 24 * time.Hour
+
+// GetLogsCache 获取缓存的日志分段 没有缓存时返回 false
+func GetLogsCache(key string) ([]byte, bool) {
+	res, err := Rdb.Get(context.Background(), LogsDB+":"+key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return res, true
+}
+
+// SetLogsCache 缓存日志分段
+func SetLogsCache(key string, data []byte) {
+	if err := Rdb.Set(context.Background(), LogsDB+":"+key, data, LogsCacheTTL).Err(); err != nil {
+		log.Error().Msgf("SetLogsCache err is %s ", err.Error())
+	}
+}
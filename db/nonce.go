@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// NonceState 地址的 nonce 分配状态
+type NonceState struct {
+	Next     uint64           // 下一个新分配的 nonce
+	Released []uint64         // 广播失败归还的 nonce 优先重新分配
+	Inflight map[uint64]int64 // 已分配但还没有广播结果的 nonce 值为分配时的毫秒级时间戳
+	Sent     []uint64         // 已经广播 但链上还没有确认的 nonce
+}
+
+// nonceReserve 分配 nonce 优先使用归还的 低于链上 nonce 的已经被占用 直接丢弃
+// KEYS[1] 下一个 nonce KEYS[2] 归还的 nonce KEYS[3] 已分配的 nonce KEYS[4] 已广播的 nonce
+// ARGV[1] 链上 pending nonce ARGV[2] 当前毫秒级时间戳
+var nonceReserve = redis.NewScript(`
+local chain = tonumber(ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. chain)
+-- 已分配的 nonce 按分配时间排序 只能按成员判断
+for _, n in ipairs(redis.call('ZRANGE', KEYS[3], 0, -1)) do
+	if tonumber(n) < chain then
+		redis.call('ZREM', KEYS[3], n)
+	end
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[4], '-inf', '(' .. chain)
+local nonce
+local released = redis.call('ZRANGE', KEYS[2], 0, 0)
+if #released > 0 then
+	nonce = tonumber(released[1])
+	redis.call('ZREM', KEYS[2], released[1])
+else
+	nonce = tonumber(redis.call('GET', KEYS[1]) or '0')
+	if nonce < chain then
+		nonce = chain
+	end
+	redis.call('SET', KEYS[1], nonce + 1)
+end
+redis.call('ZADD', KEYS[3], ARGV[2], nonce)
+return nonce
+`)
+
+// nonceClaim 占用一个指定的 nonce 正在分配中且没有超时的不能占用 补洞使用
+// KEYS[2] 归还的 nonce KEYS[3] 已分配的 nonce ARGV[1] nonce ARGV[2] 当前毫秒级时间戳 ARGV[3] 超时时间点
+var nonceClaim = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if score and tonumber(score) > tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+return 1
+`)
+
+// nonceCommit 广播成功 只有本服务分配过的 nonce 才记为已广播 其他地方签名的交易不影响 nonce 状态
+// KEYS[3] 已分配的 nonce KEYS[4] 已广播的 nonce ARGV[1] nonce
+var nonceCommit = redis.NewScript(`
+if redis.call('ZREM', KEYS[3], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[4], ARGV[1], ARGV[1])
+return 1
+`)
+
+func nonceKeys(address string) []string {
+	address = strings.ToLower(address)
+	return []string{NonceDB + ":" + address, NonceReleasedDB + ":" + address, NonceInflightDB + ":" + address, NonceSentDB + ":" + address}
+}
+
+// ReserveNonce 原子地为地址分配一个 nonce chainNonce 为链上的 pending nonce
+func ReserveNonce(address string, chainNonce uint64) (uint64, error) {
+	nonce, err := nonceReserve.Run(context.Background(), Rdb, nonceKeys(address), chainNonce, time.Now().UnixMilli()).Int64()
+	if err != nil {
+		log.Error().Msgf("ReserveNonce err is %s ", err.Error())
+		return 0, err
+	}
+	return uint64(nonce), nil
+}
+
+// ClaimNonce 占用指定的 nonce 正在被其他请求使用时返回 false lease 为分配的超时时间
+func ClaimNonce(address string, nonce uint64, lease time.Duration) (bool, error) {
+	now := time.Now()
+	ok, err := nonceClaim.Run(context.Background(), Rdb, nonceKeys(address), nonce, now.UnixMilli(), now.Add(-lease).UnixMilli()).Int()
+	if err != nil {
+		log.Error().Msgf("ClaimNonce err is %s ", err.Error())
+		return false, err
+	}
+	return ok == 1, nil
+}
+
+// CommitNonce 交易广播成功 nonce 已被使用 没有分配过的 nonce 忽略
+func CommitNonce(address string, nonce uint64) {
+	if err := nonceCommit.Run(context.Background(), Rdb, nonceKeys(address), nonce).Err(); err != nil {
+		log.Error().Msgf("CommitNonce err is %s ", err.Error())
+	}
+}
+
+// ReleaseNonce 交易广播失败 归还 nonce 下次优先分配
+func ReleaseNonce(address string, nonce uint64) {
+	keys := nonceKeys(address)
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.ZRem(context.Background(), keys[2], nonce)
+		pipe.ZAdd(context.Background(), keys[1], redis.Z{Score: float64(nonce), Member: nonce})
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("ReleaseNonce err is %s ", err.Error())
+	}
+}
+
+// GetNonceState 获取地址的 nonce 分配状态
+func GetNonceState(address string) (*NonceState, error) {
+	keys := nonceKeys(address)
+	state := &NonceState{Released: []uint64{}, Inflight: make(map[uint64]int64), Sent: []uint64{}}
+	next, err := Rdb.Get(context.Background(), keys[0]).Uint64()
+	if err != nil && err != redis.Nil {
+		log.Error().Msgf("GetNonceState err is %s ", err.Error())
+		return nil, err
+	}
+	state.Next = next
+	released, err := Rdb.ZRange(context.Background(), keys[1], 0, -1).Result()
+	if err != nil {
+		log.Error().Msgf("GetNonceState released err is %s ", err.Error())
+		return nil, err
+	}
+	for _, v := range released {
+		n, _ := strconv.ParseUint(v, 10, 64)
+		state.Released = append(state.Released, n)
+	}
+	inflight, err := Rdb.ZRangeWithScores(context.Background(), keys[2], 0, -1).Result()
+	if err != nil {
+		log.Error().Msgf("GetNonceState inflight err is %s ", err.Error())
+		return nil, err
+	}
+	for _, z := range inflight {
+		n, _ := strconv.ParseUint(z.Member.(string), 10, 64)
+		state.Inflight[n] = int64(z.Score)
+	}
+	sent, err := Rdb.ZRange(context.Background(), keys[3], 0, -1).Result()
+	if err != nil {
+		log.Error().Msgf("GetNonceState sent err is %s ", err.Error())
+		return nil, err
+	}
+	for _, v := range sent {
+		n, _ := strconv.ParseUint(v, 10, 64)
+		state.Sent = append(state.Sent, n)
+	}
+	return state, nil
+}
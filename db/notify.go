@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	NotifyDeposit  = "deposit"    // 充值到账
+	NotifyWithdraw = "withdrawal" // 提现完成
+)
+
+const (
+	NotifyPending   = "pending"   // 等待投递
+	NotifyDelivered = "delivered" // 投递成功
+	NotifyDead      = "dead"      // 超过重试次数 进入死信队列
+)
+
+// NotifyEvent 通知发件箱中的事件
+type NotifyEvent struct {
+	Id             string // 事件ID 类型:交易哈希:日志序号
+	Type           string // 事件类型 见 NotifyTypes
+	Hash           string // 交易哈希
+	LogIndex       uint   // 代币转账的日志序号 原生币为 0
+	OrderId        string // 提现订单号
+	CoinName       string // 币种 为空表示原生币
+	Address        string // 充值为收款地址 提现为转出地址
+	From           string
+	To             string
+	Value          string // 最小单位的数量
+	FormattedValue string // 按代币精度格式化的数量 没有代币信息时为空
+	Symbol         string // 代币符号
+	Decimals       uint8  // 代币精度
+	Status         int32  // 交易的状态  0 失败 1 成功
+	BlockNumber    uint64 // 所在区块
+	SubscriptionId string // 投递的目标订阅 为空表示待分发的原始事件
+	State          string // 投递状态
+	Attempts       int    // 已经投递的次数
+	LastError      string // 最后一次投递失败的原因
+	CreatedAt      int64  // 毫秒级时间戳
+}
+
+func (n NotifyEvent) MarshalBinary() ([]byte, error) {
+	return json.Marshal(n)
+}
+
+// NewNotifyEvent 新建一个待投递的事件 交易哈希加日志序号可以作为幂等键
+func NewNotifyEvent(typ, hash string, logIndex uint) *NotifyEvent {
+	return &NotifyEvent{
+		Id:        fmt.Sprintf("%s:%s:%d", typ, hash, logIndex),
+		Type:      typ,
+		Hash:      hash,
+		LogIndex:  logIndex,
+		State:     NotifyPending,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+}
+
+// notifyEnqueue 事件不存在时写入并加入投递队列 保证同一事件只入队一次
+var notifyEnqueue = redis.NewScript(`
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 1 then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// notifyClaim 领取到期的事件 把下次可见时间推后 防止多个实例重复投递
+var notifyClaim = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) <= tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// enqueueNotify 在事务中写入事件
+func enqueueNotify(ctx context.Context, pipe redis.Pipeliner, events []*NotifyEvent) error {
+	for _, ev := range events {
+		data, err := ev.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		notifyEnqueue.Eval(ctx, pipe, []string{NotifyDB, NotifyQueueDB}, ev.Id, data, ev.CreatedAt)
+	}
+	return nil
+}
+
+// NotifyLegacy 配置文件中的充值/提现回调地址
+const NotifyLegacy = "config"
+
+// Delivery 生成投递给某个订阅的事件 每个订阅单独重试
+func (n *NotifyEvent) Delivery(subId string) *NotifyEvent {
+	d := *n
+	d.Id = n.Id + "@" + subId
+	d.SubscriptionId = subId
+	d.State = NotifyPending
+	d.Attempts = 0
+	d.LastError = ""
+	return &d
+}
+
+// AddNotifyEvents 写入事件 已经存在的事件不会重复入队
+func AddNotifyEvents(events ...*NotifyEvent) error {
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		return enqueueNotify(context.Background(), pipe, events)
+	})
+	if err != nil {
+		log.Error().Msgf("AddNotifyEvents err is %s ", err.Error())
+	}
+	return err
+}
+
+// GetNotifyEvent 根据ID获取事件
+func GetNotifyEvent(id string) *NotifyEvent {
+	res, err := Rdb.HGet(context.Background(), NotifyDB, id).Result()
+	if err != nil {
+		log.Info().Msgf("GetNotifyEvent err is %s ", err.Error())
+		return nil
+	}
+	ev := &NotifyEvent{}
+	err = json.Unmarshal([]byte(res), ev)
+	if err != nil {
+		log.Info().Msgf("GetNotifyEvent Unmarshal err is %s ", err.Error())
+		return nil
+	}
+	return ev
+}
+
+// ClaimNotifyEvents 领取最多 limit 个到期的事件 lease 内其他实例不会再领取到
+func ClaimNotifyEvents(limit int64, lease time.Duration) []*NotifyEvent {
+	now := time.Now().UnixMilli()
+	ids, err := Rdb.ZRangeByScore(context.Background(), NotifyQueueDB, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: limit,
+	}).Result()
+	if err != nil {
+		log.Error().Msgf("ClaimNotifyEvents err is %s ", err.Error())
+		return nil
+	}
+	var events []*NotifyEvent
+	for _, id := range ids {
+		ok, err := notifyClaim.Run(context.Background(), Rdb, []string{NotifyQueueDB}, id, now, now+lease.Milliseconds()).Int()
+		if err != nil {
+			log.Error().Msgf("ClaimNotifyEvents claim err is %s ", err.Error())
+			continue
+		}
+		if ok == 0 {
+			continue
+		}
+		ev := GetNotifyEvent(id)
+		if ev == nil {
+			Rdb.ZRem(context.Background(), NotifyQueueDB, id)
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// AckNotifyEvent 投递成功 移出队列
+func AckNotifyEvent(ev *NotifyEvent) error {
+	ev.State = NotifyDelivered
+	ev.LastError = ""
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.Background(), NotifyDB, ev.Id, ev)
+		pipe.ZRem(context.Background(), NotifyQueueDB, ev.Id)
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("AckNotifyEvent err is %s ", err.Error())
+	}
+	return err
+}
+
+// RetryNotifyEvent 投递失败 在 next 时间之后重试
+func RetryNotifyEvent(ev *NotifyEvent, next time.Time) error {
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.Background(), NotifyDB, ev.Id, ev)
+		pipe.ZAdd(context.Background(), NotifyQueueDB, redis.Z{Score: float64(next.UnixMilli()), Member: ev.Id})
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("RetryNotifyEvent err is %s ", err.Error())
+	}
+	return err
+}
+
+// DeadNotifyEvent 超过重试次数 移入死信队列
+func DeadNotifyEvent(ev *NotifyEvent) error {
+	ev.State = NotifyDead
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.Background(), NotifyDB, ev.Id, ev)
+		pipe.ZRem(context.Background(), NotifyQueueDB, ev.Id)
+		pipe.ZAdd(context.Background(), NotifyDeadDB, redis.Z{Score: float64(time.Now().UnixMilli()), Member: ev.Id})
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("DeadNotifyEvent err is %s ", err.Error())
+	}
+	return err
+}
+
+// GetDeadNotifyEvents 获取死信队列中的事件 按进入时间排序
+func GetDeadNotifyEvents() []*NotifyEvent {
+	ids, err := Rdb.ZRange(context.Background(), NotifyDeadDB, 0, -1).Result()
+	if err != nil {
+		log.Error().Msgf("GetDeadNotifyEvents err is %s ", err.Error())
+		return nil
+	}
+	events := []*NotifyEvent{}
+	for _, id := range ids {
+		if ev := GetNotifyEvent(id); ev != nil {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// ReplayNotifyEvent 重新投递一个事件 重置重试次数
+func ReplayNotifyEvent(id string) error {
+	ev := GetNotifyEvent(id)
+	if ev == nil {
+		return fmt.Errorf("notify event %s not found", id)
+	}
+	ev.State = NotifyPending
+	ev.Attempts = 0
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.Background(), NotifyDB, ev.Id, ev)
+		pipe.ZRem(context.Background(), NotifyDeadDB, ev.Id)
+		pipe.ZAdd(context.Background(), NotifyQueueDB, redis.Z{Score: float64(time.Now().UnixMilli()), Member: ev.Id})
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("ReplayNotifyEvent err is %s ", err.Error())
+	}
+	return err
+}
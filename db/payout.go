@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// 批次的状态 明细的状态和提现订单一致
+const (
+	PayoutCreated = "created" // 已创建 还没有开始发送
+	PayoutSending = "sending" // 发送中
+	PayoutSent    = "sent"    // 所有明细都已处理 等待上链确认
+)
+
+// Payout 批量打款批次 以批次号保证幂等
+type Payout struct {
+	BatchId   string
+	Mode      string // sequential 逐笔发送 disperse 合约批量发送
+	Tier      string // 费用档位
+	From      string // 热钱包地址
+	State     string
+	Total     int // 明细数量
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+// PayoutItem 批量打款的一条明细
+type PayoutItem struct {
+	Index       int
+	To          string
+	CoinName    string // 币种合约地址 空字符串表示原生币
+	Value       string
+	State       string   // created signed broadcast confirmed failed
+	Hash        string   // disperse 模式下同一批次的明细共用一笔交易
+	Replaced    []string // 被加速或取消替换掉的交易哈希 原交易仍可能先打包
+	Nonce       uint64
+	Error       string // 失败原因
+	BlockNumber uint64
+}
+
+func (p Payout) MarshalBinary() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (i PayoutItem) MarshalBinary() ([]byte, error) {
+	return json.Marshal(i)
+}
+
+// Transit 切换明细状态 不允许的切换返回 ErrWithdrawState
+func (i *PayoutItem) Transit(state string) error {
+	return transitState(&i.State, state)
+}
+
+// payoutCreate 批次号不存在时同时写入批次和明细 不会出现只有批次没有明细的情况
+// KEYS[1] 批次 KEYS[2] 批次的明细 ARGV[1] 批次号 ARGV[2] 批次 ARGV[3:] 明细序号和明细
+var payoutCreate = redis.NewScript(`
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+for i = 3, #ARGV, 2 do
+	redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 1])
+end
+return 1
+`)
+
+// CreatePayout 创建批次和明细 批次号已存在时返回已有的批次和 false
+func CreatePayout(p *Payout, items []*PayoutItem) (*Payout, bool, error) {
+	p.State = PayoutCreated
+	p.Total = len(items)
+	p.CreatedAt = time.Now().UnixMilli()
+	p.UpdatedAt = p.CreatedAt
+	args := []interface{}{p.BatchId, p}
+	for i, item := range items {
+		item.Index = i
+		item.State = WithdrawCreated
+		args = append(args, strconv.Itoa(i), item)
+	}
+	ok, err := payoutCreate.Run(context.Background(), Rdb, []string{PayoutDB, PayoutItemDB + ":" + p.BatchId}, args...).Int()
+	if err != nil {
+		log.Error().Msgf("CreatePayout err is %s ", err.Error())
+		return nil, false, err
+	}
+	if ok == 0 {
+		old := GetPayout(p.BatchId)
+		if old == nil {
+			return nil, false, errors.New("payout batch exists but can not be read")
+		}
+		return old, false, nil
+	}
+	return p, true, nil
+}
+
+// UpDataPayout 保存批次
+func UpDataPayout(p *Payout) error {
+	p.UpdatedAt = time.Now().UnixMilli()
+	_, err := Rdb.HSet(context.Background(), PayoutDB, p.BatchId, p).Result()
+	if err != nil {
+		log.Error().Msgf("UpDataPayout err is %s ", err.Error())
+	}
+	return err
+}
+
+// UpDataPayoutItems 保存明细 有交易哈希时同时记录哈希和批次的对应
+func UpDataPayoutItems(batchId string, items ...*PayoutItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for _, item := range items {
+			pipe.HSet(context.Background(), PayoutItemDB+":"+batchId, strconv.Itoa(item.Index), item)
+			if item.Hash != "" {
+				pipe.HSet(context.Background(), PayoutHashDB, item.Hash, batchId)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("UpDataPayoutItems err is %s ", err.Error())
+	}
+	return err
+}
+
+// GetPayout 根据批次号获取批次
+func GetPayout(batchId string) *Payout {
+	res, err := Rdb.HGet(context.Background(), PayoutDB, batchId).Result()
+	if err != nil {
+		log.Info().Msgf("GetPayout err is %s ", err.Error())
+		return nil
+	}
+	p := &Payout{}
+	if err = json.Unmarshal([]byte(res), p); err != nil {
+		log.Info().Msgf("GetPayout Unmarshal err is %s ", err.Error())
+		return nil
+	}
+	return p
+}
+
+// GetPayoutItems 获取批次的所有明细 按序号排列
+func GetPayoutItems(batchId string) []*PayoutItem {
+	res, err := Rdb.HGetAll(context.Background(), PayoutItemDB+":"+batchId).Result()
+	if err != nil {
+		log.Error().Msgf("GetPayoutItems err is %s ", err.Error())
+		return nil
+	}
+	items := make([]*PayoutItem, 0, len(res))
+	for _, v := range res {
+		item := &PayoutItem{}
+		if err := json.Unmarshal([]byte(v), item); err != nil {
+			log.Error().Msgf("GetPayoutItems Unmarshal err is %s ", err.Error())
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+	return items
+}
+
+// GetPayoutByHash 根据交易哈希获取批次号 不是批量打款的交易返回空字符串
+func GetPayoutByHash(hash string) string {
+	batchId, err := Rdb.HGet(context.Background(), PayoutHashDB, hash).Result()
+	if err != nil {
+		return ""
+	}
+	return batchId
+}
+
+// RelinkPayoutHash 明细的交易被加速或取消后跟踪新的交易 原交易记录到 Replaced
+func RelinkPayoutHash(oldHash, newHash string) {
+	batchId := GetPayoutByHash(oldHash)
+	if batchId == "" {
+		return
+	}
+	list := []*PayoutItem{}
+	for _, item := range GetPayoutItems(batchId) {
+		if item.Hash != oldHash {
+			continue
+		}
+		item.Replaced = append(item.Replaced, oldHash)
+		item.Hash = newHash
+		list = append(list, item)
+	}
+	_ = UpDataPayoutItems(batchId, list...)
+}
+
+// HasHash 明细当前或者被替换掉的交易是否是 hash
+func (i *PayoutItem) HasHash(hash string) bool {
+	if i.Hash == hash {
+		return true
+	}
+	for _, h := range i.Replaced {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// SavePayoutRaw 广播前落地签名后的交易
+func SavePayoutRaw(batchId, hash string, raw []byte) error {
+	_, err := Rdb.HSet(context.Background(), PayoutRawDB+":"+batchId, hash, raw).Result()
+	if err != nil {
+		log.Error().Msgf("SavePayoutRaw err is %s ", err.Error())
+	}
+	return err
+}
+
+// GetPayoutRaw 获取签名后的交易
+func GetPayoutRaw(batchId, hash string) []byte {
+	res, err := Rdb.HGet(context.Background(), PayoutRawDB+":"+batchId, hash).Bytes()
+	if err != nil {
+		log.Info().Msgf("GetPayoutRaw err is %s ", err.Error())
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SiweNonceTTL 以太坊登录的 nonce 有效期
+const SiweNonceTTL = 10 * time.Minute
+
+// ErrAddressBound 地址已经绑定了其他账户
+var ErrAddressBound = errors.New("address is bound to another account")
+
+// AddSiweNonce 记录发出的 nonce
+func AddSiweNonce(nonce string) error {
+	if err := Rdb.Set(context.Background(), SiweNonceDB+":"+nonce, 1, SiweNonceTTL).Err(); err != nil {
+		log.Error().Msgf("AddSiweNonce err is %s ", err.Error())
+		return err
+	}
+	return nil
+}
+
+// UseSiweNonce 使用 nonce 每个 nonce 只能使用一次 没有发出或者过期返回 false
+func UseSiweNonce(nonce string) bool {
+	n, err := Rdb.Del(context.Background(), SiweNonceDB+":"+nonce).Result()
+	if err != nil {
+		log.Error().Msgf("UseSiweNonce err is %s ", err.Error())
+		return false
+	}
+	return n == 1
+}
+
+// GetSiweAccount 地址绑定的账户 没有绑定返回空
+func GetSiweAccount(address string) string {
+	res, err := Rdb.HGet(context.Background(), SiweAddressDB, address).Result()
+	if err != nil {
+		return ""
+	}
+	return res
+}
+
+// BindSiweAddress 把地址绑定到账户 账户不存在时创建 一个地址只能绑定一个账户
+func BindSiweAddress(account, address string) (*Account, error) {
+	ok, err := Rdb.HSetNX(context.Background(), SiweAddressDB, address, account).Result()
+	if err != nil {
+		log.Error().Msgf("BindSiweAddress err is %s ", err.Error())
+		return nil, err
+	}
+	if !ok && GetSiweAccount(address) != account {
+		return nil, ErrAddressBound
+	}
+	ac := GetAccountInfo(account)
+	if ac == nil {
+		// 只用以太坊登录的账户 密码是随机生成的 不能用密码登录
+		passwd, err := unusablePasswd()
+		if err != nil {
+			return nil, err
+		}
+		ac = &Account{Account: account, PassWD: passwd}
+	}
+	ac.Address = address
+	if _, err := Rdb.HSet(context.Background(), AccountDB, account, ac).Result(); err != nil {
+		log.Error().Msgf("BindSiweAddress save account err is %s ", err.Error())
+		return nil, err
+	}
+	return ac, nil
+}
+
+// unusablePasswd 随机生成的密码 不会返回给任何人
+func unusablePasswd() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "!" + hex.EncodeToString(b), nil
+}
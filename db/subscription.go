@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	NotifyNFTTransfer = "nft_transfer" // NFT 转入或转出
+	NotifyTxFailed    = "tx_failed"    // 本服务钱包发出的交易执行失败
+	NotifyReorg       = "reorg"        // 已提交的区块被回滚
+	NotifyTest        = "test"         // 测试事件
+)
+
+// NotifyTypes 可以订阅的事件类型
+var NotifyTypes = []string{NotifyDeposit, NotifyWithdraw, NotifyNFTTransfer, NotifyTxFailed, NotifyReorg}
+
+const (
+	// DeliveryLogSize 每个订阅保留的最近投递记录数量
+	DeliveryLogSize = 50
+	// SecretRotateGrace 轮换密钥后旧密钥继续签名的时间
+	SecretRotateGrace = 24 * time.Hour
+)
+
+// Subscription 账户的通知订阅
+type Subscription struct {
+	Id              string
+	Account         string   // 所属账户
+	Url             string   // 回调地址
+	Events          []string // 订阅的事件类型
+	Wallets         []string // 只通知这些钱包 为空表示账户下所有钱包
+	Coins           []string // 只通知这些币种的合约地址 空字符串表示原生币 为空表示所有币种
+	Secret          string   // 签名密钥
+	OldSecret       string   // 轮换前的密钥 过期前同时签名
+	OldSecretExpire int64    // 旧密钥过期的毫秒级时间戳
+	Disabled        bool     // 是否暂停投递
+	CreatedAt       int64
+}
+
+// WebhookDelivery 一次投递记录
+type WebhookDelivery struct {
+	EventId    string
+	Type       string
+	Attempt    int
+	StatusCode int    // 响应状态码 请求未发出为 0
+	Error      string // 失败原因
+	Duration   int64  // 耗时 毫秒
+	TimeStamp  int64  // 毫秒级时间戳
+}
+
+func (s Subscription) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (d WebhookDelivery) MarshalBinary() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// randomHex 生成 n 字节的随机十六进制字符串
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewSubscription 新建一个订阅 并生成签名密钥
+func NewSubscription(account, url string, events, wallets, coins []string) *Subscription {
+	return &Subscription{
+		Id:        randomHex(16),
+		Account:   account,
+		Url:       url,
+		Events:    events,
+		Wallets:   wallets,
+		Coins:     coins,
+		Secret:    "whsec_" + randomHex(32),
+		CreatedAt: time.Now().UnixMilli(),
+	}
+}
+
+// RotateSecret 生成新的签名密钥 旧密钥在宽限期内继续签名
+func (s *Subscription) RotateSecret() {
+	s.OldSecret = s.Secret
+	s.OldSecretExpire = time.Now().Add(SecretRotateGrace).UnixMilli()
+	s.Secret = "whsec_" + randomHex(32)
+}
+
+// Secrets 当前用于签名的密钥
+func (s *Subscription) Secrets() []string {
+	if s.OldSecret != "" && time.Now().UnixMilli() < s.OldSecretExpire {
+		return []string{s.Secret, s.OldSecret}
+	}
+	return []string{s.Secret}
+}
+
+// Match 判断事件是否需要投递给这个订阅 wallets 为事件涉及的本服务钱包 owned 为账户拥有的钱包
+func (s *Subscription) Match(ev *NotifyEvent, wallets []string, owned []string) bool {
+	if s.Disabled || !containsFold(s.Events, ev.Type) {
+		return false
+	}
+	// 回滚和钱包无关
+	if ev.Type == NotifyReorg {
+		return true
+	}
+	if len(s.Coins) > 0 && !containsFold(s.Coins, ev.CoinName) {
+		return false
+	}
+	for _, w := range wallets {
+		if !containsFold(owned, w) {
+			continue
+		}
+		if len(s.Wallets) == 0 || containsFold(s.Wallets, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, target string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpDataSubscription 写入订阅
+func UpDataSubscription(s *Subscription) error {
+	_, err := Rdb.HSet(context.Background(), SubscriptionDB, s.Id, s).Result()
+	if err != nil {
+		log.Error().Msgf("UpDataSubscription err is %s ", err.Error())
+	}
+	return err
+}
+
+// GetSubscription 根据ID获取订阅
+func GetSubscription(id string) *Subscription {
+	res, err := Rdb.HGet(context.Background(), SubscriptionDB, id).Result()
+	if err != nil {
+		log.Info().Msgf("GetSubscription err is %s ", err.Error())
+		return nil
+	}
+	s := &Subscription{}
+	err = json.Unmarshal([]byte(res), s)
+	if err != nil {
+		log.Info().Msgf("GetSubscription Unmarshal err is %s ", err.Error())
+		return nil
+	}
+	return s
+}
+
+// GetAllSubscription 获取所有订阅 account 不为空时只返回该账户的
+func GetAllSubscription(account string) []*Subscription {
+	res, err := Rdb.HGetAll(context.Background(), SubscriptionDB).Result()
+	if err != nil {
+		log.Error().Msgf("GetAllSubscription err is %s ", err.Error())
+		return nil
+	}
+	subs := []*Subscription{}
+	for _, v := range res {
+		s := &Subscription{}
+		if err := json.Unmarshal([]byte(v), s); err != nil {
+			log.Error().Msgf("GetAllSubscription Unmarshal err is %s ", err.Error())
+			continue
+		}
+		if account != "" && s.Account != account {
+			continue
+		}
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// DelSubscription 删除订阅和投递记录
+func DelSubscription(id string) error {
+	_, err := Rdb.HDel(context.Background(), SubscriptionDB, id).Result()
+	if err != nil {
+		log.Error().Msgf("DelSubscription err is %s ", err.Error())
+		return err
+	}
+	Rdb.Del(context.Background(), WebhookLogDB+":"+id)
+	return nil
+}
+
+// AddWebhookDelivery 记录一次投递 只保留最近 DeliveryLogSize 条
+func AddWebhookDelivery(subId string, d *WebhookDelivery) {
+	key := WebhookLogDB + ":" + subId
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.LPush(context.Background(), key, d)
+		pipe.LTrim(context.Background(), key, 0, DeliveryLogSize-1)
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("AddWebhookDelivery err is %s ", err.Error())
+	}
+}
+
+// GetWebhookDeliveries 获取订阅最近的投递记录 最新的在前
+func GetWebhookDeliveries(subId string) []*WebhookDelivery {
+	res, err := Rdb.LRange(context.Background(), WebhookLogDB+":"+subId, 0, -1).Result()
+	if err != nil {
+		log.Error().Msgf("GetWebhookDeliveries err is %s ", err.Error())
+		return nil
+	}
+	list := []*WebhookDelivery{}
+	for _, v := range res {
+		d := &WebhookDelivery{}
+		if err := json.Unmarshal([]byte(v), d); err != nil {
+			continue
+		}
+		list = append(list, d)
+	}
+	return list
+}
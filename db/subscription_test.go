@@ -0,0 +1,38 @@
+package db
+
+import "testing"
+
+func TestSubscriptionMatch(t *testing.T) {
+	sub := NewSubscription("alice", "http://localhost/hook", []string{NotifyDeposit, NotifyReorg}, nil, nil)
+	owned := []string{"0xAbC"}
+
+	ev := NewNotifyEvent(NotifyDeposit, "0x01", 0)
+	ev.Address = "0xabc"
+	if !sub.Match(ev, []string{ev.Address}, owned) {
+		t.Fatal("deposit to own wallet should match")
+	}
+	if sub.Match(ev, []string{ev.Address}, nil) {
+		t.Fatal("deposit to other account wallet should not match")
+	}
+	if sub.Match(NewNotifyEvent(NotifyWithdraw, "0x01", 0), []string{"0xabc"}, owned) {
+		t.Fatal("unsubscribed type should not match")
+	}
+	if !sub.Match(NewNotifyEvent(NotifyReorg, "0x02", 0), nil, nil) {
+		t.Fatal("reorg should match without wallet")
+	}
+
+	sub.Coins = []string{"0xcoin"}
+	if sub.Match(ev, []string{ev.Address}, owned) {
+		t.Fatal("coin filter should exclude native coin")
+	}
+}
+
+func TestSubscriptionRotateSecret(t *testing.T) {
+	sub := NewSubscription("alice", "http://localhost/hook", []string{NotifyDeposit}, nil, nil)
+	old := sub.Secret
+	sub.RotateSecret()
+	secrets := sub.Secrets()
+	if len(secrets) != 2 || secrets[1] != old || secrets[0] == old {
+		t.Fatalf("unexpected secrets %v", secrets)
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TokenMeta 代币的元数据 添加代币时从合约读取 按网络保存
+type TokenMeta struct {
+	Network         string `json:"network"`
+	ContractAddress string `json:"contractAddress"`
+	Name            string `json:"name"`
+	Symbol          string `json:"symbol"`
+	Decimals        uint8  `json:"decimals"`
+	TotalSupply     string `json:"totalSupply"` // 读取时的总量 最小单位
+	UpdatedAt       int64  `json:"updatedAt"`   // 读取时间 毫秒级时间戳
+}
+
+func (m TokenMeta) MarshalBinary() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// SetTokenMeta 保存代币的元数据 已经存在时覆盖
+func SetTokenMeta(m *TokenMeta) error {
+	if err := Rdb.HSet(context.Background(), TokenDB+":"+m.Network, m.ContractAddress, m).Err(); err != nil {
+		log.Error().Msgf("SetTokenMeta err is %s ", err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetTokenMeta 获取代币的元数据 没有记录返回 nil
+func GetTokenMeta(network, contractAddress string) *TokenMeta {
+	res, err := Rdb.HGet(context.Background(), TokenDB+":"+network, contractAddress).Result()
+	if err != nil {
+		return nil
+	}
+	m := &TokenMeta{}
+	if err := json.Unmarshal([]byte(res), m); err != nil {
+		log.Error().Msgf("GetTokenMeta Unmarshal err is %s ", err.Error())
+		return nil
+	}
+	return m
+}
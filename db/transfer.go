@@ -0,0 +1,258 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DirectionIn  = "in"  // 转入
+	DirectionOut = "out" // 转出
+)
+
+const (
+	// transferLogSpan 同一区块内日志序号的范围 score = 区块号 * transferLogSpan + 日志序号
+	transferLogSpan = 100000
+	// transferPendingScore 未上链的交易排在最前 score = transferPendingScore + 毫秒级时间戳
+	transferPendingScore = 1 << 52
+	// transferIndexNative 原生币在索引 key 中的名称
+	transferIndexNative = "native"
+	// transferIndexVersion 历史交易是否已经建立索引
+	transferIndexVersion = TransferIndexDB + ":version"
+	// TransferPageMax 每页最多返回的数量
+	TransferPageMax = 100
+	// transferScanMax 一次查询最多检查的交易数量 是页大小的倍数 过滤条件命中太少时提前返回游标
+	transferScanMax = 10
+)
+
+// TransferQuery 交易记录查询条件
+type TransferQuery struct {
+	Address   string  // 钱包地址
+	CoinName  *string // 币种合约地址 空字符串表示原生币 nil 表示所有币种
+	Direction string  // in 转入 out 转出 为空表示全部
+	Status    *int32  // 交易状态 nil 表示全部
+	StartTime int64   // 毫秒级时间戳 包含 为 0 表示不限
+	EndTime   int64   // 毫秒级时间戳 不包含 为 0 表示不限
+	Cursor    string  // 上一页返回的游标 为空表示第一页
+	Limit     int64   // 每页数量
+}
+
+// transferScore 交易在索引中的排序值
+func transferScore(ts *Transfer) float64 {
+	if ts.BlockNumber == 0 {
+		t, _ := strconv.ParseInt(ts.TimeStamp, 10, 64)
+		return float64(transferPendingScore + t)
+	}
+	return float64(ts.BlockNumber*transferLogSpan + uint64(ts.LogIndex))
+}
+
+// transferIndexKey 地址的索引 coinName 为 nil 表示所有币种
+func transferIndexKey(address string, coinName *string) string {
+	key := TransferIndexDB + ":" + strings.ToLower(address)
+	if coinName == nil {
+		return key
+	}
+	if *coinName == "" {
+		return key + ":" + transferIndexNative
+	}
+	return key + ":" + strings.ToLower(*coinName)
+}
+
+// transferParties 交易涉及的地址 部署合约没有接收方
+func transferParties(ts *Transfer) []string {
+	parties := []string{ts.From}
+	if ts.To != "" && !strings.EqualFold(ts.To, ts.From) {
+		parties = append(parties, ts.To)
+	}
+	return parties
+}
+
+// addTransferIndex 在事务中把交易加入双方的索引
+func addTransferIndex(ctx context.Context, pipe redis.Pipeliner, ts *Transfer) {
+	z := redis.Z{Score: transferScore(ts), Member: ts.Hex}
+	for _, address := range transferParties(ts) {
+		pipe.ZAdd(ctx, transferIndexKey(address, nil), z)
+		pipe.ZAdd(ctx, transferIndexKey(address, &ts.CoinName), z)
+	}
+}
+
+// removeTransferIndex 在事务中把交易移出双方的索引
+func removeTransferIndex(ctx context.Context, pipe redis.Pipeliner, ts *Transfer) {
+	for _, address := range transferParties(ts) {
+		pipe.ZRem(ctx, transferIndexKey(address, nil), ts.Hex)
+		pipe.ZRem(ctx, transferIndexKey(address, &ts.CoinName), ts.Hex)
+	}
+}
+
+// match 判断交易是否满足过滤条件
+func (q *TransferQuery) match(ts *Transfer) bool {
+	switch q.Direction {
+	case DirectionIn:
+		if !strings.EqualFold(ts.To, q.Address) {
+			return false
+		}
+	case DirectionOut:
+		if !strings.EqualFold(ts.From, q.Address) {
+			return false
+		}
+	}
+	if q.Status != nil && ts.Status != *q.Status {
+		return false
+	}
+	if q.StartTime > 0 || q.EndTime > 0 {
+		t, _ := strconv.ParseInt(ts.TimeStamp, 10, 64)
+		if q.StartTime > 0 && t < q.StartTime {
+			return false
+		}
+		if q.EndTime > 0 && t >= q.EndTime {
+			return false
+		}
+	}
+	return true
+}
+
+// transferCursor 分页游标 分数:交易哈希
+// 同一分数可能有多条记录 比如同一笔交易的多个 Transfer 日志 分数相同的记录按哈希倒序排列 哈希不小于游标的已经返回过
+type transferCursor struct {
+	max    string  // 查询的最大分数
+	score  float64 // 游标的分数
+	member string  // 游标的交易哈希 为空表示没有同分数需要跳过的记录
+}
+
+// parseTransferCursor 解析游标 兼容只有分数的旧游标 旧游标按不包含处理
+func parseTransferCursor(cursor string) *transferCursor {
+	if cursor == "" {
+		return &transferCursor{max: "+inf"}
+	}
+	score, member, ok := strings.Cut(cursor, ":")
+	if !ok {
+		return &transferCursor{max: "(" + score}
+	}
+	c := &transferCursor{max: score, member: member}
+	c.score, _ = strconv.ParseFloat(score, 64)
+	return c
+}
+
+// seen 记录是否在游标之前 已经返回过
+func (c *transferCursor) seen(z redis.Z) bool {
+	return c.member != "" && z.Score == c.score && z.Member.(string) >= c.member
+}
+
+// formatTransferCursor 生成下一页的游标
+func formatTransferCursor(z redis.Z) string {
+	return strconv.FormatFloat(z.Score, 'f', -1, 64) + ":" + z.Member.(string)
+}
+
+// GetTransferPage 按区块倒序分页获取地址的交易记录 返回下一页的游标 没有更多时游标为空
+func GetTransferPage(q *TransferQuery) ([]*Transfer, string) {
+	if q.Limit <= 0 || q.Limit > TransferPageMax {
+		q.Limit = TransferPageMax
+	}
+	key := transferIndexKey(q.Address, q.CoinName)
+	cursor := parseTransferCursor(q.Cursor)
+	next := q.Cursor
+	list := []*Transfer{}
+	for offset := int64(0); offset < q.Limit*transferScanMax; {
+		res, err := Rdb.ZRevRangeByScoreWithScores(context.Background(), key, &redis.ZRangeBy{
+			Max:    cursor.max,
+			Min:    "-inf",
+			Offset: offset,
+			Count:  q.Limit,
+		}).Result()
+		if err != nil {
+			log.Error().Msgf("GetTransferPage err is %s ", err.Error())
+			return list, ""
+		}
+		if len(res) == 0 {
+			return list, ""
+		}
+		offset += int64(len(res))
+		page := make([]redis.Z, 0, len(res))
+		hashes := make([]string, 0, len(res))
+		for _, z := range res {
+			if cursor.seen(z) {
+				continue
+			}
+			page = append(page, z)
+			hashes = append(hashes, z.Member.(string))
+		}
+		var values []interface{}
+		if len(hashes) > 0 {
+			if values, err = Rdb.HMGet(context.Background(), TransferDB, hashes...).Result(); err != nil {
+				log.Error().Msgf("GetTransferPage HMGet err is %s ", err.Error())
+				return list, ""
+			}
+		}
+		for i, v := range values {
+			next = formatTransferCursor(page[i])
+			data, ok := v.(string)
+			if !ok {
+				continue
+			}
+			ts := &Transfer{}
+			if err := json.Unmarshal([]byte(data), ts); err != nil {
+				log.Info().Msgf("GetTransferPage Unmarshal err is %s ", err.Error())
+				continue
+			}
+			if !q.match(ts) {
+				continue
+			}
+			list = append(list, ts)
+			if int64(len(list)) == q.Limit {
+				return list, next
+			}
+		}
+		if int64(len(res)) < q.Limit {
+			return list, ""
+		}
+	}
+	// 检查的数量到达上限 返回游标由调用方继续查询
+	return list, next
+}
+
+// RebuildTransferIndex 为没有索引的历史交易建立索引 历史交易没有记录区块 按时间排在最前
+func RebuildTransferIndex() {
+	done, err := Rdb.Exists(context.Background(), transferIndexVersion).Result()
+	if err != nil {
+		log.Error().Msgf("RebuildTransferIndex err is %s ", err.Error())
+		return
+	}
+	if done == 1 {
+		// 已经建立过
+		return
+	}
+	var cursor uint64
+	for {
+		keys, next, err := Rdb.HScan(context.Background(), TransferDB, cursor, "", 500).Result()
+		if err != nil {
+			log.Error().Msgf("RebuildTransferIndex HScan err is %s ", err.Error())
+			return
+		}
+		_, err = Rdb.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+			for i := 0; i+1 < len(keys); i += 2 {
+				ts := &Transfer{}
+				if err := json.Unmarshal([]byte(keys[i+1]), ts); err != nil {
+					continue
+				}
+				ts.Hex = keys[i]
+				addTransferIndex(context.Background(), pipe, ts)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error().Msgf("RebuildTransferIndex ZAdd err is %s ", err.Error())
+			return
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	Rdb.Set(context.Background(), transferIndexVersion, 1, 0)
+	log.Info().Msgf("RebuildTransferIndex done")
+}
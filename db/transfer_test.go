@@ -0,0 +1,59 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTransferScore(t *testing.T) {
+	a := transferScore(&Transfer{BlockNumber: 100, LogIndex: 3})
+	b := transferScore(&Transfer{BlockNumber: 100, LogIndex: 4})
+	c := transferScore(&Transfer{BlockNumber: 101})
+	pending := transferScore(&Transfer{TimeStamp: "1700000000000"})
+	if !(a < b && b < c && c < pending) {
+		t.Fatalf("unexpected order %v %v %v %v", a, b, c, pending)
+	}
+}
+
+func TestTransferQueryMatch(t *testing.T) {
+	status := int32(1)
+	ts := &Transfer{From: "0xAAA", To: "0xbbb", Status: 1, TimeStamp: "1000"}
+	cases := []struct {
+		q    TransferQuery
+		want bool
+	}{
+		{TransferQuery{Address: "0xaaa", Direction: DirectionOut}, true},
+		{TransferQuery{Address: "0xaaa", Direction: DirectionIn}, false},
+		{TransferQuery{Address: "0xBBB", Direction: DirectionIn, Status: &status}, true},
+		{TransferQuery{Address: "0xaaa", StartTime: 1000, EndTime: 2000}, true},
+		{TransferQuery{Address: "0xaaa", EndTime: 1000}, false},
+	}
+	for i, c := range cases {
+		if got := c.q.match(ts); got != c.want {
+			t.Fatalf("case %d got %v", i, got)
+		}
+	}
+}
+
+func TestTransferCursor(t *testing.T) {
+	// 同一区块同一日志分数的多条记录 按哈希倒序 游标之前的跳过
+	z := redis.Z{Score: 10000003, Member: "0xbb"}
+	c := parseTransferCursor(formatTransferCursor(z))
+	if c.max != "10000003" {
+		t.Fatalf("max got %s", c.max)
+	}
+	if !c.seen(redis.Z{Score: 10000003, Member: "0xcc"}) || !c.seen(z) {
+		t.Fatal("returned members should be skipped")
+	}
+	if c.seen(redis.Z{Score: 10000003, Member: "0xaa"}) || c.seen(redis.Z{Score: 10000002, Member: "0xff"}) {
+		t.Fatal("later members should not be skipped")
+	}
+	// 旧的游标只有分数
+	if old := parseTransferCursor("10000003"); old.max != "(10000003" || old.seen(z) {
+		t.Fatalf("legacy cursor got %+v", old)
+	}
+	if first := parseTransferCursor(""); first.max != "+inf" {
+		t.Fatalf("first page got %s", first.max)
+	}
+}
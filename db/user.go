@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/lmxdawn/wallet/types"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 )
 
@@ -23,6 +25,7 @@ type NetWork struct {
 	NetWorkName string
 	RpcUrl      string
 	ChainID     uint32
+	Symbol      string // 原生币符号
 }
 
 // NFTAssets NFT 资产
@@ -34,8 +37,10 @@ type NFTAssets struct {
 type CoinAssets struct {
 	ContractAddress string      // 资产合约地址，为空表示主币
 	Symbol          string      // 资产符号
-	Num             *big.Int    // 拥有的数量
-	Trans           []*Transfer // 该种资产的交易信息
+	Decimals        uint8       // 资产精度 展示数量时使用
+	Num             *big.Int    // 拥有的数量 最小单位
+	Balance         string      // 按精度格式化的数量
+	Trans           []*Transfer // 已废弃 交易记录通过 GetTransferPage 查询
 }
 
 type Assets struct {
@@ -54,21 +59,39 @@ type User struct {
 	Assets         map[string]*Assets // 用户资产 key 为网络名称
 }
 
+// ActionDeploy 部署合约的交易
+const ActionDeploy = "contract deployment"
+
+const (
+	ActionSpeedUp = "speed up" // 加速 相同 nonce 提高费用重发原交易
+	ActionCancel  = "cancel"   // 取消 相同 nonce 发送 0 金额给自己
+)
+
 type Transfer struct {
-	Hex       string
-	From      string
-	To        string
-	Value     string
-	CoinName  string // 交易的币种 为空表示原生币
-	TimeStamp string // 这笔交易的时间戳
-	Data      []byte // 交易数据
-	Status    int32  // 交易的状态  0 失败 1 成功 2 等待
+	Hex             string
+	From            string
+	To              string
+	Value           string
+	CoinName        string              // 交易的币种 为空表示原生币
+	TimeStamp       string              // 这笔交易的时间戳
+	Data            []byte              // 交易数据
+	Status          int32               // 交易的状态  0 失败 1 成功 2 等待
+	Action          string              // 交易类型 为空表示普通转账
+	ContractAddress string              // 部署合约交易创建出的合约地址
+	BlockNumber     uint64              // 所在区块 未上链为 0
+	LogIndex        uint                // 代币转账的日志序号 原生币为 0
+	Replaces        string              // 加速或取消的交易 这里是被替换的原交易哈希
+	ReplacedBy      string              // 被替换后 替换交易的哈希
+	ReplacedAction  string              // 被替换的方式 ActionSpeedUp 或 ActionCancel
+	Call            *types.DecodedCall  // 按上传的 ABI 解析出的调用 没有上传为空
+	Events          []*types.DecodedLog // 按上传的 ABI 解析出的事件日志
 }
 
 type Account struct {
 	Account    string
 	PassWD     string   // 密码
 	WalletList []string // 对应的钱包地址列表
+	Address    string   // 以太坊登录绑定的地址
 }
 
 type LoginInfo struct {
@@ -223,30 +246,6 @@ func GetAllAddress() []*User {
 	return usrs
 }
 
-func UpDataUserTransInfo(address, contractAddress string, trans []*Transfer) {
-	usr := GetUserFromDB(address)
-	if usr == nil {
-		log.Info().Msgf("UpDataUserTransInfo GetUserFromDB usr not in db,address is %s ", address)
-		return
-	}
-	for _, v := range usr.Assets[usr.CurrentNetWork.NetWorkName].Coin {
-		if v.ContractAddress == contractAddress {
-			v.Trans = append(v.Trans, trans...)
-			break
-		}
-	}
-	_, err := Rdb.HDel(context.Background(), UserDB, address).Result()
-	if err != nil {
-		log.Info().Msgf("UpDataUserTransInfo HDel err is %s ", err.Error())
-		return
-	}
-	_, err = Rdb.HSet(context.Background(), UserDB, address, usr).Result()
-	if err != nil {
-		log.Info().Msgf("UpDataUserTransInfo toDb err is %s ", err.Error())
-		return
-	}
-}
-
 // UpDataUserInfo 更新用户数据
 func UpDataUserInfo(usr *User) error {
 	ok, err := Rdb.HExists(context.Background(), UserDB, usr.Address).Result()
@@ -286,36 +285,111 @@ func (u *User) MulSignMode(to, coinName, num string) bool {
 	return false
 }
 
-// UpDateTransInfo 更新交易数据
-func UpDateTransInfo(hex, from, to, value, coinName string, status int32, data []byte) {
+// UpDateTransInfo 更新交易数据 未上链时 blockNumber 为 0 events 为需要通知业务服务的事件 和交易记录在同一个事务中写入
+func UpDateTransInfo(hex, from, to, value, coinName string, status int32, data []byte, blockNumber uint64, logIndex uint, events ...*NotifyEvent) {
 
-	// 秒级时间戳
+	// 毫秒级时间戳
 	ts := &Transfer{Hex: hex, From: from, To: to, Value: value, TimeStamp: strconv.Itoa(int(time.Now().UnixMilli())), Data: data, Status: status}
 
 	ts.CoinName = coinName
-	if Rdb.HExists(context.Background(), TransferDB, hex).Val() {
-		// 已经存在了
-		_, err := Rdb.HDel(context.Background(), TransferDB, hex).Result()
-		if err != nil {
-			log.Info().Msgf("UpDateTransInfo HDel err is %s ", err.Error())
-			return
+	ts.BlockNumber = blockNumber
+	ts.LogIndex = logIndex
+	saveTransfer(ts, events...)
+}
+
+// UpDateDeployInfo 更新部署合约的交易数据 contractAddress 为部署出的合约地址
+func UpDateDeployInfo(hex, from, contractAddress, value string, status int32, data []byte, blockNumber uint64) {
+	ts := &Transfer{
+		Hex:             hex,
+		From:            from,
+		Value:           value,
+		TimeStamp:       strconv.Itoa(int(time.Now().UnixMilli())),
+		Data:            data,
+		Status:          status,
+		Action:          ActionDeploy,
+		ContractAddress: contractAddress,
+		BlockNumber:     blockNumber,
+	}
+	saveTransfer(ts)
+}
+
+// UpDateTransDecoded 记录按上传的 ABI 解析出的调用和事件 交易需要已经落地
+func UpDateTransDecoded(hex string, call *types.DecodedCall, events []*types.DecodedLog) {
+	ts := GetTransferByHash(hex)
+	if ts == nil {
+		return
+	}
+	ts.Call, ts.Events = call, events
+	if err := Rdb.HSet(context.Background(), TransferDB, hex, ts).Err(); err != nil {
+		log.Info().Msgf("UpDateTransDecoded err is %s ", err.Error())
+	}
+}
+
+// saveTransfer 写入交易表、交易双方的索引和通知事件
+func saveTransfer(ts *Transfer, events ...*NotifyEvent) {
+	old := GetTransferByHash(ts.Hex)
+	if old != nil {
+		if old.TimeStamp != "" {
+			// 时间以第一次记录的为准 发出的交易为发送时间
+			ts.TimeStamp = old.TimeStamp
+		}
+		// 上链后保留替换关系
+		ts.Replaces = old.Replaces
+		ts.ReplacedBy = old.ReplacedBy
+		ts.ReplacedAction = old.ReplacedAction
+		if ts.Action == "" {
+			ts.Action = old.Action
+		}
+		if ts.Call == nil {
+			ts.Call = old.Call
+		}
+		if len(ts.Events) == 0 {
+			ts.Events = old.Events
 		}
 	}
-	// 更新所有的
-	_, err := Rdb.HSet(context.Background(), TransferDB, hex, ts).Result()
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		if old != nil {
+			// 发出时记录的接收方可能是合约 上链后以解析出的为准
+			removeTransferIndex(context.Background(), pipe, old)
+		}
+		pipe.HSet(context.Background(), TransferDB, ts.Hex, ts)
+		addTransferIndex(context.Background(), pipe, ts)
+		return enqueueNotify(context.Background(), pipe, events)
+	})
 	if err != nil {
 		log.Info().Msgf("UpDateTransInfo err is %s ", err.Error())
 		return
 	}
-	//	 过滤 更新单个币的活动
-	UpDataUserTransInfo(from, coinName, []*Transfer{ts})
+}
 
-	// 排除20或721合约交易 不然在获取用户的地方会报错
-	// 这里 若是合约转账 则 To 为 address(0) 地址
-	if to != "" {
-		UpDataUserTransInfo(to, coinName, []*Transfer{ts})
+// UpDateReplaceInfo 记录加速或取消 ts 为替换交易 Replaces 为原交易哈希
+func UpDateReplaceInfo(ts *Transfer) {
+	ts.TimeStamp = strconv.Itoa(int(time.Now().UnixMilli()))
+	saveTransfer(ts)
+	old := GetTransferByHash(ts.Replaces)
+	if old == nil {
+		log.Info().Msgf("UpDateReplaceInfo original %s not in db ", ts.Replaces)
+		return
 	}
+	old.ReplacedBy = ts.Hex
+	old.ReplacedAction = ts.Action
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.Background(), TransferDB, old.Hex, old)
+		pipe.HSet(context.Background(), ReplaceDB, old.Hex, ts.Hex)
+		return nil
+	})
+	if err != nil {
+		log.Info().Msgf("UpDateReplaceInfo err is %s ", err.Error())
+	}
+}
 
+// GetReplacedBy 获取替换了该交易的交易哈希 没有被替换返回空
+func GetReplacedBy(hash string) string {
+	res, err := Rdb.HGet(context.Background(), ReplaceDB, hash).Result()
+	if err != nil {
+		return ""
+	}
+	return res
 }
 
 func GetTransferByHash(hash string) *Transfer {
@@ -333,48 +407,6 @@ func GetTransferByHash(hash string) *Transfer {
 	return temp
 }
 
-// GetTransferFromDB 获取以 from 为目标地址的交易信息
-func GetTransferFromDB(address string) (data []*Transfer) {
-	type info struct {
-		From      string
-		To        string
-		Value     string
-		CoinName  string // 交易的币种 为空表示原生币
-		TimeStamp string // 这笔交易
-	}
-	// TODO 不该这样获取所有
-	res, err := Rdb.HGetAll(context.Background(), TransferDB).Result()
-	if err != nil {
-		log.Info().Msgf("GetTransferFromDB err is %s ", err.Error())
-		return
-	}
-	for key, value := range res {
-		tempData := value
-		temp := &info{}
-		err := json.Unmarshal([]byte(tempData), temp)
-
-		if err != nil {
-			log.Info().Msgf("GetTransferFromDB UnMarshal err is %s ", err.Error())
-			continue
-		}
-
-		// 筛选出 From 和 to 符合条件的
-		if temp.From != address && temp.To != address {
-			continue
-		}
-
-		data = append(data, &Transfer{
-			Hex:       key,
-			From:      temp.From,
-			To:        temp.To,
-			Value:     temp.Value,
-			CoinName:  temp.CoinName, // 交易的币种 为空表示原生币
-			TimeStamp: temp.TimeStamp,
-		})
-	}
-	return
-}
-
 func UpDataAccountInfo(account, passwd string) (*Account, error) {
 
 	ac := &Account{
@@ -461,22 +493,50 @@ func (usr *User) ImportNFTToDB(contractAddress, tokenID string) error {
 	return err
 }
 
-func (usr *User) AddNetWork(name, rpc string, chainID uint32) error {
+// ErrSameNetWork 名称或 chainID 相同的网络已经添加过
+var ErrSameNetWork = errors.New("Has Same Chain")
+
+// AddNetWork 添加网络 名称或 chainID 已存在时返回 ErrSameNetWork
+func (usr *User) AddNetWork(name, rpc, symbol string, chainID uint32) error {
 
 	for _, v := range usr.NetWorks {
 		if v.NetWorkName == name || v.ChainID == chainID {
-			return errors.New("Has Same Chain")
+			return ErrSameNetWork
 		}
 	}
 	usr.NetWorks = append(usr.NetWorks, &NetWork{
-		name,
-		rpc,
-		chainID,
+		NetWorkName: name,
+		RpcUrl:      rpc,
+		ChainID:     chainID,
+		Symbol:      symbol,
 	})
 	return nil
 }
 
-// ChangeNetWork 改变当前网络
-func (usr *User) ChangeNetWork(name string) {
+// GetNetWorkByChainID 根据 chainID 获取已添加的网络 没有添加时返回 nil
+func (usr *User) GetNetWorkByChainID(chainID uint32) *NetWork {
+	for _, v := range usr.NetWorks {
+		if v.ChainID == chainID {
+			return v
+		}
+	}
+	return nil
+}
 
+// ChangeNetWork 改变当前网络 网络需要先添加 第一次切换到的网络初始化原生币资产
+func (usr *User) ChangeNetWork(name string) error {
+	for _, v := range usr.NetWorks {
+		if v.NetWorkName != name {
+			continue
+		}
+		usr.CurrentNetWork = v
+		if _, ok := usr.Assets[name]; !ok {
+			usr.Assets[name] = &Assets{
+				Coin: []*CoinAssets{{ContractAddress: "", Symbol: v.Symbol, Num: big.NewInt(0), Trans: []*Transfer{}}},
+				NFT:  []*NFTAssets{},
+			}
+		}
+		return nil
+	}
+	return errors.New("NetWork Not Found")
 }
@@ -0,0 +1,23 @@
+package db
+
+import "testing"
+
+func TestChangeNetWork(t *testing.T) {
+	usr := NewWalletUser("0x1", "", "")
+	if err := usr.AddNetWork("Goerli", "https://goerli", "ETH", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := usr.AddNetWork("Other", "https://other", "ETH", 5); err != ErrSameNetWork {
+		t.Fatalf("same chainID got %v", err)
+	}
+	if err := usr.ChangeNetWork(usr.GetNetWorkByChainID(5).NetWorkName); err != nil {
+		t.Fatal(err)
+	}
+	// 第一次切换到的网络初始化原生币资产
+	if usr.CurrentNetWork.ChainID != 5 || len(usr.Assets["Goerli"].Coin) != 1 || usr.Assets["Goerli"].Coin[0].Symbol != "ETH" {
+		t.Fatalf("change network got %+v", usr.CurrentNetWork)
+	}
+	if err := usr.ChangeNetWork("Unknown"); err == nil {
+		t.Fatal("unknown network should fail")
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// 提现订单的状态 created → signed → broadcast → confirmed / failed
+const (
+	WithdrawCreated   = "created"   // 已创建 还没有签名
+	WithdrawSigned    = "signed"    // 已签名 还没有广播成功
+	WithdrawBroadcast = "broadcast" // 已广播 等待上链确认
+	WithdrawConfirmed = "confirmed" // 上链成功
+	WithdrawFailed    = "failed"    // 签名/广播失败或者上链执行失败
+)
+
+var ErrWithdrawState = errors.New("withdraw state transition not allowed")
+
+// withdrawNext 每个状态允许进入的下一个状态
+var withdrawNext = map[string][]string{
+	WithdrawCreated:   {WithdrawSigned, WithdrawFailed},
+	WithdrawSigned:    {WithdrawBroadcast, WithdrawFailed},
+	WithdrawBroadcast: {WithdrawConfirmed, WithdrawFailed},
+}
+
+// Withdraw 提现订单 以业务订单号保证幂等
+type Withdraw struct {
+	OrderId     string
+	Protocol    string
+	CoinName    string // 币种合约地址 空字符串表示原生币
+	Address     string // 提现地址
+	Value       string
+	Tier        string // 费用档位
+	From        string // 热钱包地址
+	Hash        string
+	Nonce       uint64
+	Raw         []byte // 签名后的交易 广播前落地 重复请求时重新广播同一笔交易
+	State       string
+	Error       string // 失败原因
+	BlockNumber uint64
+	CreatedAt   int64
+	UpdatedAt   int64
+}
+
+func (w Withdraw) MarshalBinary() ([]byte, error) {
+	return json.Marshal(w)
+}
+
+// Transit 切换订单状态 不允许的切换返回 ErrWithdrawState
+func (w *Withdraw) Transit(state string) error {
+	if err := transitState(&w.State, state); err != nil {
+		return err
+	}
+	w.UpdatedAt = time.Now().UnixMilli()
+	return nil
+}
+
+// transitState 按提现的状态机切换状态 批量打款的明细共用
+func transitState(cur *string, state string) error {
+	for _, next := range withdrawNext[*cur] {
+		if next == state {
+			*cur = state
+			return nil
+		}
+	}
+	return ErrWithdrawState
+}
+
+// SameRequest 重复请求的参数是否和已有订单一致 费用档位不影响到账 不比较
+func (w *Withdraw) SameRequest(o *Withdraw) bool {
+	return w.Protocol == o.Protocol && strings.EqualFold(w.CoinName, o.CoinName) &&
+		strings.EqualFold(w.Address, o.Address) && w.Value == o.Value
+}
+
+// CreateWithdraw 创建提现订单 订单号已存在时返回已有的订单和 false
+func CreateWithdraw(w *Withdraw) (*Withdraw, bool, error) {
+	w.State = WithdrawCreated
+	w.CreatedAt = time.Now().UnixMilli()
+	w.UpdatedAt = w.CreatedAt
+	ok, err := Rdb.HSetNX(context.Background(), WithdrawDB, w.OrderId, w).Result()
+	if err != nil {
+		log.Error().Msgf("CreateWithdraw err is %s ", err.Error())
+		return nil, false, err
+	}
+	if !ok {
+		old := GetWithdraw(w.OrderId)
+		if old == nil {
+			return nil, false, errors.New("withdraw order exists but can not be read")
+		}
+		return old, false, nil
+	}
+	return w, true, nil
+}
+
+// UpDataWithdraw 保存提现订单 有交易哈希时同时记录哈希和订单的对应
+func UpDataWithdraw(w *Withdraw) error {
+	_, err := Rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.Background(), WithdrawDB, w.OrderId, w)
+		if w.Hash != "" {
+			pipe.HSet(context.Background(), WithdrawHashDB, w.Hash, w.OrderId)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("UpDataWithdraw err is %s ", err.Error())
+	}
+	return err
+}
+
+// GetWithdraw 根据订单号获取提现订单
+func GetWithdraw(orderId string) *Withdraw {
+	res, err := Rdb.HGet(context.Background(), WithdrawDB, orderId).Result()
+	if err != nil {
+		log.Info().Msgf("GetWithdraw err is %s ", err.Error())
+		return nil
+	}
+	w := &Withdraw{}
+	if err = json.Unmarshal([]byte(res), w); err != nil {
+		log.Info().Msgf("GetWithdraw Unmarshal err is %s ", err.Error())
+		return nil
+	}
+	return w
+}
+
+// RelinkWithdrawHash 订单的交易被加速或取消后跟踪新的交易 原交易的对应保留 以先打包的那笔为准
+func RelinkWithdrawHash(oldHash, newHash string) {
+	w := GetWithdrawByHash(oldHash)
+	if w == nil {
+		return
+	}
+	w.Hash = newHash
+	w.UpdatedAt = time.Now().UnixMilli()
+	_ = UpDataWithdraw(w)
+}
+
+// GetWithdrawByHash 根据交易哈希获取提现订单 不是提现交易返回 nil
+func GetWithdrawByHash(hash string) *Withdraw {
+	orderId, err := Rdb.HGet(context.Background(), WithdrawHashDB, hash).Result()
+	if err != nil {
+		return nil
+	}
+	return GetWithdraw(orderId)
+}
+
+// GetUnfinishedWithdraws 还没有签名或者还没有广播成功的订单 启动时继续处理
+func GetUnfinishedWithdraws() []*Withdraw {
+	list := []*Withdraw{}
+	var cursor uint64
+	for {
+		keys, next, err := Rdb.HScan(context.Background(), WithdrawDB, cursor, "", 500).Result()
+		if err != nil {
+			log.Error().Msgf("GetUnfinishedWithdraws HScan err is %s ", err.Error())
+			return list
+		}
+		for i := 0; i+1 < len(keys); i += 2 {
+			w := &Withdraw{}
+			if err := json.Unmarshal([]byte(keys[i+1]), w); err != nil {
+				continue
+			}
+			if w.State == WithdrawCreated || w.State == WithdrawSigned {
+				list = append(list, w)
+			}
+		}
+		if next == 0 {
+			return list
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,34 @@
+package db
+
+import "testing"
+
+func TestWithdrawTransit(t *testing.T) {
+	w := &Withdraw{State: WithdrawCreated}
+	for _, state := range []string{WithdrawSigned, WithdrawBroadcast, WithdrawConfirmed} {
+		if err := w.Transit(state); err != nil {
+			t.Fatalf("transit to %s err is %s", state, err)
+		}
+	}
+	// 终态不能再切换
+	if err := w.Transit(WithdrawFailed); err != ErrWithdrawState {
+		t.Fatalf("confirmed order transit to failed got %v", err)
+	}
+
+	w = &Withdraw{State: WithdrawCreated}
+	if err := w.Transit(WithdrawBroadcast); err != ErrWithdrawState {
+		t.Fatalf("created order skip signed got %v", err)
+	}
+	if err := w.Transit(WithdrawFailed); err != nil || w.State != WithdrawFailed {
+		t.Fatalf("created order transit to failed got %v %s", err, w.State)
+	}
+}
+
+func TestWithdrawSameRequest(t *testing.T) {
+	w := &Withdraw{Protocol: "eth", Address: "0xAbC", Value: "100", Tier: "slow"}
+	if !w.SameRequest(&Withdraw{Protocol: "eth", Address: "0xabc", Value: "100"}) {
+		t.Fatal("address case and tier should not matter")
+	}
+	if w.SameRequest(&Withdraw{Protocol: "eth", Address: "0xabc", Value: "200"}) {
+		t.Fatal("different value is a conflict")
+	}
+}
@@ -0,0 +1,267 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/types"
+)
+
+var (
+	ErrAbiMethod = errors.New("method not found in contract abi")
+	ErrAbiArgs   = errors.New("wrong number of arguments")
+)
+
+// AbiRegistry 按网络和合约地址上传的 ABI ABI 保存在 Redis 中 解析后的结果缓存在内存中
+type AbiRegistry struct {
+	network string   // 本服务连接的网络 不指定网络时使用
+	cache   sync.Map // 网络:地址 -> *parsedAbi
+}
+
+type parsedAbi struct {
+	raw string
+	abi *abi.ABI
+}
+
+// Abis 全局的 ABI 注册表
+var Abis *AbiRegistry
+
+// NewAbiRegistry 新建 ABI 注册表 network 为本服务连接的网络
+func NewAbiRegistry(network string) *AbiRegistry {
+	return &AbiRegistry{network: network}
+}
+
+// Network 不指定网络时使用本服务连接的网络
+func (r *AbiRegistry) Network(network string) string {
+	if network == "" {
+		return r.network
+	}
+	return network
+}
+
+// Register 上传合约的 ABI 已经存在时覆盖
+func (r *AbiRegistry) Register(network, address, name, abiJSON string) (*db.ContractAbi, error) {
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid contract address %s", address)
+	}
+	if _, err := abi.JSON(strings.NewReader(abiJSON)); err != nil {
+		return nil, err
+	}
+	a := &db.ContractAbi{
+		Network:   r.Network(network),
+		Address:   common.HexToAddress(address).Hex(),
+		Name:      name,
+		Abi:       abiJSON,
+		UpdatedAt: time.Now().UnixMilli(),
+	}
+	if err := db.SetContractAbi(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Remove 删除合约的 ABI
+func (r *AbiRegistry) Remove(network, address string) (bool, error) {
+	return db.DelContractAbi(r.Network(network), common.HexToAddress(address).Hex())
+}
+
+// Lookup 获取合约的 ABI 没有上传返回 nil
+// 每次都读取 Redis 中的 JSON 和缓存一致时直接使用解析好的结果 多个实例更新 ABI 后不会用到旧的
+func (r *AbiRegistry) Lookup(network, address string) *abi.ABI {
+	if address == "" {
+		return nil
+	}
+	network = r.Network(network)
+	address = common.HexToAddress(address).Hex()
+	stored := db.GetContractAbi(network, address)
+	if stored == nil {
+		return nil
+	}
+	key := network + ":" + address
+	if v, ok := r.cache.Load(key); ok && v.(*parsedAbi).raw == stored.Abi {
+		return v.(*parsedAbi).abi
+	}
+	parsed, err := abi.JSON(strings.NewReader(stored.Abi))
+	if err != nil {
+		return nil
+	}
+	r.cache.Store(key, &parsedAbi{raw: stored.Abi, abi: &parsed})
+	return &parsed
+}
+
+// DecodeCallAt 解析已上传 ABI 的合约的调用数据 没有上传或者解析失败返回 nil
+func (r *AbiRegistry) DecodeCallAt(network, address string, data []byte) *types.DecodedCall {
+	a := r.Lookup(network, address)
+	if a == nil {
+		return nil
+	}
+	call, err := DecodeCall(a, data)
+	if err != nil {
+		return nil
+	}
+	return call
+}
+
+// DecodeLogs 解析已上传 ABI 的合约的事件日志 其他合约的日志忽略
+func (r *AbiRegistry) DecodeLogs(network string, logs []*ethTypes.Log) []*types.DecodedLog {
+	list := []*types.DecodedLog{}
+	abis := map[common.Address]*abi.ABI{}
+	for _, l := range logs {
+		a, ok := abis[l.Address]
+		if !ok {
+			a = r.Lookup(network, l.Address.Hex())
+			abis[l.Address] = a
+		}
+		if a == nil {
+			continue
+		}
+		if decoded, err := DecodeLog(a, l); err == nil {
+			list = append(list, decoded)
+		}
+	}
+	return list
+}
+
+// withRegistered 加上目标合约上传的 ABI 用来解析自定义错误
+func withRegistered(to *common.Address, abis []*abi.ABI) []*abi.ABI {
+	if Abis == nil || to == nil {
+		return abis
+	}
+	if a := Abis.Lookup("", to.Hex()); a != nil {
+		return append(abis, a)
+	}
+	return abis
+}
+
+// registeredAbis 目标合约上传的 ABI to 为空表示部署合约
+func registeredAbis(to string) []*abi.ABI {
+	if to == "" {
+		return nil
+	}
+	address := common.HexToAddress(to)
+	return withRegistered(&address, nil)
+}
+
+// abiMethod 按名称或者签名查找方法 重载的方法需要使用签名 比如 transfer(address,uint256)
+func abiMethod(a *abi.ABI, name string) (abi.Method, error) {
+	if m, ok := a.Methods[name]; ok {
+		return m, nil
+	}
+	for _, m := range a.Methods {
+		if m.Sig == name {
+			return m, nil
+		}
+	}
+	return abi.Method{}, ErrAbiMethod
+}
+
+// EncodeCall 按方法名和 JSON 参数生成调用数据
+func EncodeCall(a *abi.ABI, method string, args []json.RawMessage) ([]byte, error) {
+	m, err := abiMethod(a, method)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(m.Inputs) {
+		return nil, ErrAbiArgs
+	}
+	values := make([]interface{}, len(args))
+	for i, arg := range m.Inputs {
+		v, err := jsonToAbi(arg.Type, args[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %v", arg.Name, err)
+		}
+		values[i] = v.Interface()
+	}
+	input, err := m.Inputs.Pack(values...)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, m.ID...), input...), nil
+}
+
+// DecodeOutputs 解析方法的返回值
+func DecodeOutputs(a *abi.ABI, method string, data []byte) ([]types.AbiParam, error) {
+	m, err := abiMethod(a, method)
+	if err != nil {
+		return nil, err
+	}
+	values, err := m.Outputs.Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	return abiParams(m.Outputs, values), nil
+}
+
+// DecodeCall 解析调用数据
+func DecodeCall(a *abi.ABI, data []byte) (*types.DecodedCall, error) {
+	if len(data) < 4 {
+		return nil, ErrAbiMethod
+	}
+	m, err := a.MethodById(data[:4])
+	if err != nil {
+		return nil, err
+	}
+	values, err := m.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &types.DecodedCall{Method: m.RawName, Signature: m.Sig, Params: abiParams(m.Inputs, values)}, nil
+}
+
+// DecodeLog 解析事件日志 动态类型的 indexed 参数只能得到哈希
+func DecodeLog(a *abi.ABI, l *ethTypes.Log) (*types.DecodedLog, error) {
+	if len(l.Topics) == 0 {
+		return nil, errors.New("anonymous log")
+	}
+	event, err := a.EventByID(l.Topics[0])
+	if err != nil {
+		return nil, err
+	}
+	values, err := event.Inputs.NonIndexed().Unpack(l.Data)
+	if err != nil {
+		return nil, err
+	}
+	decoded := &types.DecodedLog{
+		Address:   l.Address.Hex(),
+		LogIndex:  l.Index,
+		Event:     event.RawName,
+		Signature: event.Sig,
+	}
+	topic, value := 1, 0
+	for _, arg := range event.Inputs {
+		param := types.AbiParam{Name: arg.Name, Type: arg.Type.String()}
+		if !arg.Indexed {
+			param.Value = abiToJSON(arg.Type, values[value])
+			value++
+		} else {
+			if topic >= len(l.Topics) {
+				return nil, errors.New("missing indexed topic")
+			}
+			param.Value = topicValue(arg, l.Topics[topic])
+			topic++
+		}
+		decoded.Params = append(decoded.Params, param)
+	}
+	return decoded, nil
+}
+
+// topicValue indexed 参数的值 值类型按 ABI 解析 动态类型返回哈希
+func topicValue(arg abi.Argument, topic common.Hash) interface{} {
+	switch arg.Type.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return topic.Hex()
+	}
+	values, err := abi.Arguments{{Type: arg.Type}}.Unpack(topic.Bytes())
+	if err != nil || len(values) == 0 {
+		return topic.Hex()
+	}
+	return abiToJSON(arg.Type, values[0])
+}
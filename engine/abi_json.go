@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/lmxdawn/wallet/types"
+)
+
+// jsonToAbi 把 JSON 参数转换成 abi 打包需要的 Go 类型
+// 整数可以是数字 10 进制或 16 进制字符串 字节为 16 进制 元组可以是对象或者数组
+func jsonToAbi(t abi.Type, raw json.RawMessage) (reflect.Value, error) {
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		n, err := jsonInteger(raw)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if !abiIntegerFits(t, n) {
+			return reflect.Value{}, fmt.Errorf("value %s out of range for %s", n, t.String())
+		}
+		if t.Size > 64 {
+			return reflect.ValueOf(n), nil
+		}
+		v := reflect.New(t.GetType()).Elem()
+		if t.T == abi.IntTy {
+			v.SetInt(n.Int64())
+		} else {
+			v.SetUint(n.Uint64())
+		}
+		return v, nil
+	case abi.BoolTy:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(b), nil
+	case abi.StringTy:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(s), nil
+	case abi.AddressTy:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil || !common.IsHexAddress(s) {
+			return reflect.Value{}, fmt.Errorf("invalid address %s", raw)
+		}
+		return reflect.ValueOf(common.HexToAddress(s)), nil
+	case abi.BytesTy:
+		var b hexutil.Bytes
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf([]byte(b)), nil
+	case abi.FixedBytesTy, abi.FunctionTy:
+		var b hexutil.Bytes
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.GetType()).Elem()
+		if len(b) != v.Len() {
+			return reflect.Value{}, fmt.Errorf("%s needs %d bytes got %d", t.String(), v.Len(), len(b))
+		}
+		reflect.Copy(v, reflect.ValueOf([]byte(b)))
+		return v, nil
+	case abi.SliceTy, abi.ArrayTy:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return reflect.Value{}, err
+		}
+		var v reflect.Value
+		if t.T == abi.SliceTy {
+			v = reflect.MakeSlice(t.GetType(), len(items), len(items))
+		} else {
+			if len(items) != t.Size {
+				return reflect.Value{}, fmt.Errorf("%s needs %d items got %d", t.String(), t.Size, len(items))
+			}
+			v = reflect.New(t.GetType()).Elem()
+		}
+		for i, item := range items {
+			e, err := jsonToAbi(*t.Elem, item)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("[%d]: %v", i, err)
+			}
+			v.Index(i).Set(e)
+		}
+		return v, nil
+	case abi.TupleTy:
+		items, err := jsonTuple(t, raw)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.GetType()).Elem()
+		for i, elem := range t.TupleElems {
+			e, err := jsonToAbi(*elem, items[i])
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("%s: %v", t.TupleRawNames[i], err)
+			}
+			v.Field(i).Set(e)
+		}
+		return v, nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported type %s", t.String())
+}
+
+// abiIntegerFits 整数是否在类型的范围内
+func abiIntegerFits(t abi.Type, n *big.Int) bool {
+	if t.T == abi.UintTy {
+		return n.Sign() >= 0 && n.BitLen() <= t.Size
+	}
+	limit := math.BigPow(2, int64(t.Size-1))
+	return n.Cmp(new(big.Int).Neg(limit)) >= 0 && n.Cmp(limit) < 0
+}
+
+// jsonInteger 解析数字 或者 10 进制 16 进制的字符串
+func jsonInteger(raw json.RawMessage) (*big.Int, error) {
+	s := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+	}
+	n, ok := math.ParseBig256(s)
+	if !ok {
+		// ParseBig256 不支持负数
+		if n, ok = new(big.Int).SetString(s, 10); !ok {
+			return nil, fmt.Errorf("invalid integer %s", raw)
+		}
+	}
+	return n, nil
+}
+
+// jsonTuple 元组参数 对象按名称取值 数组按顺序取值
+func jsonTuple(t abi.Type, raw json.RawMessage) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err == nil {
+		if len(items) != len(t.TupleElems) {
+			return nil, fmt.Errorf("tuple needs %d items got %d", len(t.TupleElems), len(items))
+		}
+		return items, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, errors.New("tuple must be an object or an array")
+	}
+	items = make([]json.RawMessage, len(t.TupleElems))
+	for i, name := range t.TupleRawNames {
+		v, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("missing tuple field %s", name)
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+// abiToJSON 把解析出的值转换成方便 JSON 输出的格式 整数为 10 进制字符串 字节为 16 进制
+func abiToJSON(t abi.Type, value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		if n, ok := value.(*big.Int); ok {
+			return n.String()
+		}
+		if t.T == abi.IntTy {
+			return strconv.FormatInt(v.Int(), 10)
+		}
+		return strconv.FormatUint(v.Uint(), 10)
+	case abi.AddressTy:
+		return value.(common.Address).Hex()
+	case abi.BytesTy:
+		return hexutil.Encode(value.([]byte))
+	case abi.FixedBytesTy, abi.FunctionTy, abi.HashTy:
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		return hexutil.Encode(b)
+	case abi.SliceTy, abi.ArrayTy:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = abiToJSON(*t.Elem, v.Index(i).Interface())
+		}
+		return list
+	case abi.TupleTy:
+		fields := make(map[string]interface{}, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			name := t.TupleRawNames[i]
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			fields[name] = abiToJSON(*elem, v.Field(i).Interface())
+		}
+		return fields
+	}
+	return value
+}
+
+// abiParams 按参数定义转换解析出的值
+func abiParams(args abi.Arguments, values []interface{}) []types.AbiParam {
+	params := make([]types.AbiParam, 0, len(values))
+	for i, arg := range args {
+		if i >= len(values) {
+			break
+		}
+		params = append(params, types.AbiParam{Name: arg.Name, Type: arg.Type.String(), Value: abiToJSON(arg.Type, values[i])})
+	}
+	return params
+}
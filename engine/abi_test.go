@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+const testAbiStr = `[
+	{"inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"components":[{"name":"id","type":"uint64"},{"name":"tags","type":"bytes32[]"}],"name":"order","type":"tuple"},{"name":"delta","type":"int8"}],"name":"fill","outputs":[{"name":"filled","type":"uint256"},{"name":"owner","type":"address"}],"stateMutability":"nonpayable","type":"function"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"}
+]`
+
+func testAbi(t *testing.T) *abi.ABI {
+	a, err := abi.JSON(strings.NewReader(testAbiStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &a
+}
+
+func rawArgs(args ...string) []json.RawMessage {
+	list := make([]json.RawMessage, len(args))
+	for i, a := range args {
+		list[i] = json.RawMessage(a)
+	}
+	return list
+}
+
+func TestEncodeCall(t *testing.T) {
+	a := testAbi(t)
+	to := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	data, err := EncodeCall(a, "transfer", rawArgs(`"`+to.Hex()+`"`, `"0x10"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := a.Pack("transfer", to, big.NewInt(16))
+	if !bytes.Equal(data, want) {
+		t.Fatalf("got %x want %x", data, want)
+	}
+	call, err := DecodeCall(a, data)
+	if err != nil || call.Method != "transfer" || call.Params[0].Value != to.Hex() || call.Params[1].Value != "16" {
+		t.Fatalf("decode got %+v %v", call, err)
+	}
+	// 元组按名称传入 签名也可以作为方法名
+	tag := `"0x` + strings.Repeat("01", 32) + `"`
+	if _, err := EncodeCall(a, "fill((uint64,bytes32[]),int8)", rawArgs(`{"id":7,"tags":[`+tag+`]}`, `-128`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EncodeCall(a, "fill", rawArgs(`{"id":7,"tags":[]}`, `128`)); err == nil {
+		t.Fatal("int8 overflow should fail")
+	}
+	if _, err := EncodeCall(a, "transfer", rawArgs(`"`+to.Hex()+`"`)); err != ErrAbiArgs {
+		t.Fatalf("args count got %v", err)
+	}
+}
+
+func TestDecodeOutputsAndLog(t *testing.T) {
+	a := testAbi(t)
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	data, _ := a.Methods["fill"].Outputs.Pack(big.NewInt(5), owner)
+	outputs, err := DecodeOutputs(a, "fill", data)
+	if err != nil || outputs[0].Name != "filled" || outputs[0].Value != "5" || outputs[1].Value != owner.Hex() {
+		t.Fatalf("outputs got %+v %v", outputs, err)
+	}
+	value, _ := abi.Arguments{{Type: a.Events["Transfer"].Inputs[2].Type}}.Pack(big.NewInt(9))
+	l := &ethTypes.Log{
+		Address: common.HexToAddress("0x01"),
+		Topics:  []common.Hash{a.Events["Transfer"].ID, common.BytesToHash(owner.Bytes()), common.BytesToHash(owner.Bytes())},
+		Data:    value,
+		Index:   3,
+	}
+	decoded, err := DecodeLog(a, l)
+	if err != nil || decoded.Event != "Transfer" || decoded.LogIndex != 3 || decoded.Params[0].Value != owner.Hex() || decoded.Params[2].Value != "9" {
+		t.Fatalf("log got %+v %v", decoded, err)
+	}
+}
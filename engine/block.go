@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ErrBlockNumber 区块参数不是数字也不是 earliest latest pending safe finalized
+var ErrBlockNumber = errors.New("invalid block number or tag")
+
+// ParseBlockNumber 解析区块参数 支持 16 进制和 10 进制的数字 以及 earliest latest pending safe finalized 为空表示 latest
+func ParseBlockNumber(block string) (rpc.BlockNumber, error) {
+	block = strings.ToLower(strings.TrimSpace(block))
+	if block == "" {
+		return rpc.LatestBlockNumber, nil
+	}
+	if !strings.HasPrefix(block, "0x") {
+		if n, err := strconv.ParseInt(block, 10, 64); err == nil && n >= 0 {
+			return rpc.BlockNumber(n), nil
+		}
+	}
+	var bn rpc.BlockNumber
+	if err := json.Unmarshal([]byte(strconv.Quote(block)), &bn); err != nil {
+		return 0, ErrBlockNumber
+	}
+	return bn, nil
+}
+
+// toCallArg eth_call 和 eth_estimateGas 的调用参数
+func toCallArg(from, to string, data []byte, value *big.Int) interface{} {
+	arg := map[string]interface{}{
+		"from": common.HexToAddress(from),
+	}
+	if to != "" {
+		arg["to"] = common.HexToAddress(to)
+	}
+	if len(data) > 0 {
+		arg["data"] = hexutil.Bytes(data)
+	}
+	if value != nil {
+		arg["value"] = (*hexutil.Big)(value)
+	}
+	return arg
+}
+
+// GetBalanceAt 指定区块的余额 contractAddress 为空表示原生币
+func (w *Worker) GetBalanceAt(address, contractAddress string, block rpc.BlockNumber) (*big.Int, error) {
+	if contractAddress == "" {
+		var balance hexutil.Big
+		err := w.wClient.CallContext(context.Background(), &balance, "eth_getBalance", common.HexToAddress(address), block)
+		if err != nil {
+			return nil, err
+		}
+		return balance.ToInt(), nil
+	}
+	data, err := w.tokenAbi.Pack("balanceOf", common.HexToAddress(address))
+	if err != nil {
+		return nil, err
+	}
+	res, err := w.ETHCall(address, contractAddress, data, block)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(res), nil
+}
+
+// ResolveBlockNumber 把区块标签换成具体的区块号 节点不支持 safe finalized 时按确认数从最新区块往前推
+func (w *Worker) ResolveBlockNumber(block rpc.BlockNumber) (uint64, error) {
+	if block >= 0 {
+		return uint64(block), nil
+	}
+	var head *struct {
+		Number hexutil.Uint64 `json:"number"`
+	}
+	err := w.wClient.CallContext(context.Background(), &head, "eth_getBlockByNumber", block, false)
+	if err == nil && head != nil {
+		return uint64(head.Number), nil
+	}
+	if block != rpc.SafeBlockNumber && block != rpc.FinalizedBlockNumber {
+		if err == nil {
+			err = ethereum.NotFound
+		}
+		return 0, err
+	}
+	latest, err := w.GetBlockNumber()
+	if err != nil {
+		return 0, err
+	}
+	if latest < w.confirms {
+		return 0, nil
+	}
+	return latest - w.confirms, nil
+}
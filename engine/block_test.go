@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestParseBlockNumber(t *testing.T) {
+	cases := map[string]rpc.BlockNumber{
+		"":          rpc.LatestBlockNumber,
+		"latest":    rpc.LatestBlockNumber,
+		"Pending":   rpc.PendingBlockNumber,
+		"earliest":  rpc.EarliestBlockNumber,
+		"safe":      rpc.SafeBlockNumber,
+		"finalized": rpc.FinalizedBlockNumber,
+		"0x10":      16,
+		"16":        16,
+		"0":         0,
+	}
+	for in, want := range cases {
+		got, err := ParseBlockNumber(in)
+		if err != nil || got != want {
+			t.Fatalf("%q got %d err %v want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"-1", "0xzz", "head", "1.5"} {
+		if _, err := ParseBlockNumber(in); err != ErrBlockNumber {
+			t.Fatalf("%q should be invalid got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package engine
+
+import "math/big"
+
+// GasLimit 交易的 gas 上限 标准转账 21000 调用合约时在预估的基础上预留余量
+func GasLimit(estimate uint64) uint64 {
+	if estimate > 21000 {
+		return estimate * 2
+	}
+	return estimate
+}
+
+// GasFee 按 maxFeePerGas 计算的最高手续费
+func GasFee(gasLimit uint64, fee *FeeTier) *big.Int {
+	return new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), fee.MaxFeePerGas)
+}
+
+// SweepAmount 原生币归集扣除最高手续费后的数量 不够手续费时返回 0
+func SweepAmount(balance, gasFee *big.Int) *big.Int {
+	amount := new(big.Int).Sub(balance, gasFee)
+	if amount.Sign() < 0 {
+		return big.NewInt(0)
+	}
+	return amount
+}
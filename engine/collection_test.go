@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestGasLimit(t *testing.T) {
+	if GasLimit(21000) != 21000 {
+		t.Fatal("plain transfer should not reserve gas")
+	}
+	if GasLimit(50000) != 100000 {
+		t.Fatal("contract call should reserve double gas")
+	}
+}
+
+func TestSweepAmount(t *testing.T) {
+	fee := GasFee(21000, &FeeTier{MaxFeePerGas: big.NewInt(10)})
+	if fee.Int64() != 210000 {
+		t.Fatalf("gas fee got %s", fee)
+	}
+	if got := SweepAmount(big.NewInt(1000000), fee); got.Int64() != 790000 {
+		t.Fatalf("sweep amount got %s", got)
+	}
+	if got := SweepAmount(big.NewInt(100), fee); got.Sign() != 0 {
+		t.Fatalf("balance below gas should sweep nothing got %s", got)
+	}
+}
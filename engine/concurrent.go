@@ -18,8 +18,8 @@ type IWorker interface {
 	GetTransactionReceipt(hash string) (int64, error)
 	GetBalance(address string, contractAddress string) (*big.Int, error)
 	CreateWallet() (*types.Wallet, error)
-	Transfer(privateKeyStr string, toAddress string, value *big.Int, nonce uint64, contractAddress string) (string, string, uint64, error)
-	GetGasPrice() (string, error)
+	Transfer(privateKeyStr string, toAddress string, value *big.Int, nonce uint64, contractAddress string, tier string) (string, string, uint64, error)
+	GetGasPrice() (*FeeEstimate, error)
 }
 
 type Scheduler interface {
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"encoding/json"
@@ -11,16 +12,15 @@ import (
 	// "net/rpc"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/consensus/misc"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/params"
 
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/lmxdawn/wallet/db"
@@ -57,9 +57,11 @@ type Worker struct {
 	tokenAbi               abi.ABI // 合约的abi
 	// pendingList 未完成的交易列表
 	pending *sync.Map
+	// fee 费用预估
+	fee *FeeOracle
+	// serviceKeys 本服务自己的钱包 小写地址 -> 私钥 提现热钱包和加油钱包不在用户钱包中
+	serviceKeys sync.Map
 	//Pending                map[string]struct{} // 待执行的交易
-	// TODO 这个锁应该放在用户身上去
-	nonceLock sync.Mutex
 	//TransHistory           map[string][]*types.Transaction // 交易历史记录
 }
 
@@ -99,6 +101,14 @@ func (w *Worker) GetPendingByHex(txHex string) *types.Transaction {
 
 }
 
+// track 记录广播成功的交易 由监控检查是否卡住
+func (w *Worker) track(signTx *ethTypes.Transaction, ts *types.Transaction) {
+	ts.Hash = signTx.Hash().Hex()
+	ts.SentAt = time.Now().UnixMilli()
+	ts.Raw, _ = signTx.MarshalBinary()
+	w.pending.Store(ts.Hash, ts)
+}
+
 // RemovePendingByHex 通过交易的hex删除处于Pending状态的交易
 func (w *Worker) RemovePendingByHex(txHex string) {
 
@@ -141,8 +151,10 @@ func NewWorker(confirms uint64, url string) error {
 		tokenTransferEventHash: tokenTransferEventHash,
 		tokenAbi:               tokenAbi,
 		pending:                &sync.Map{},
+		fee:                    NewFeeOracle(http),
 		//TransHistory:           make(map[string][]*types.Transaction),
 	}
+	Nonces = &NonceManager{http: http}
 	//EWorker.wClient = ethclient.NewClient(rpcClient)
 	return nil
 }
@@ -211,16 +223,14 @@ func (w *Worker) GetBalance(address string, contractAddress string) (*big.Int, e
 			return nil, err
 		}
 		return balance, nil
-	} else {
-		res, err := w.callContract(contractAddress, "balanceOf", account)
-		if err != nil {
-			return nil, err
-		}
-		balance := big.NewInt(0)
-		balance.SetBytes(res)
-		return balance, nil
 	}
-	return nil, nil
+	res, err := w.callContract(contractAddress, "balanceOf", account)
+	if err != nil {
+		return nil, err
+	}
+	balance := big.NewInt(0)
+	balance.SetBytes(res)
+	return balance, nil
 }
 
 // GeneratePublicKey 生成公钥
@@ -268,54 +278,52 @@ func (w *Worker) CreateWallet() (*types.Wallet, error) {
 	}, err
 }
 
-func (w *Worker) Transfer(privateKeyStr string, toAddress string, value *big.Int, nonce uint64, contractAddress string) (string, string, uint64, error) {
-	var data []byte
-	var err error
-	var value20 *big.Int
-	var contractTransferHashSig []byte
-	var contractTransferHash common.Hash
-	var toAddressTmp common.Address
-	var toAddressHex *common.Address
-
-	// 可以和 CallContract 合并
-	if contractAddress != "" {
-
-		contractTransferHashSig = []byte("transfer(address,uint256)")
-		contractTransferHash = crypto.Keccak256Hash(contractTransferHashSig)
-		toAddressTmp = common.HexToAddress(toAddress)
-		toAddressHex = &toAddressTmp
-		data, err = makeEthERC20TransferData(contractTransferHash, toAddressHex, value)
-		if err != nil {
-			return "", "", 0, err
-		}
-		value20 = value
-		value = big.NewInt(0)
-
+// Transfer 转账 tier 为费用档位 为空使用 normal
+func (w *Worker) Transfer(privateKeyStr string, toAddress string, value *big.Int, nonce uint64, contractAddress string, tier string) (string, string, uint64, error) {
+	signTx, err := w.SignTransfer(privateKeyStr, toAddress, value, nonce, contractAddress, tier)
+	if err != nil {
+		return "", "", 0, err
 	}
-
-	// NFT 转账的时候  value 是 tokenID 值
-	return w.sendTransaction(contractAddress, privateKeyStr, toAddress, value, value20, nonce, data)
+	fromAddress, err := w.BroadcastTransfer(signTx, toAddress, value, contractAddress, tier)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return fromAddress.Hex(), signTx.Hash().Hex(), signTx.Nonce(), nil
 }
 
-func (w *Worker) GetGasPrice() (string, string, error) {
-	// 能这样做 ?
-	number, err := w.http.BlockNumber(context.Background())
+// SignTransfer 模拟执行并签名转账 不广播 contractAddress 为空表示原生币
+// nonce 为 0 时由 nonce 管理分配 之后必须调用 BroadcastTransfer 或 Nonces.Release
+func (w *Worker) SignTransfer(privateKeyStr string, toAddress string, value *big.Int, nonce uint64, contractAddress string, tier string) (*ethTypes.Transaction, error) {
+	to, value, data, err := w.transferCall(toAddress, value, contractAddress)
 	if err != nil {
-		log.Error().Msgf("GetGasPrice getBlockNumber err:%v", err)
-		return "", "", err
+		return nil, err
 	}
-	block, err := w.http.BlockByNumber(context.Background(), big.NewInt(int64(number)))
-	if err != nil {
-		log.Error().Msgf("GetGasPrice getBlockByNumber err:%v", err)
-		return "", "", err
+	return w.signTransaction(privateKeyStr, to, value, nonce, data, tier)
+}
+
+// transferCall 转账实际调用的地址 金额和数据 代币转账是发给合约的 由合约内部转账
+func (w *Worker) transferCall(toAddress string, value *big.Int, contractAddress string) (string, *big.Int, []byte, error) {
+	if contractAddress == "" {
+		return toAddress, value, nil, nil
 	}
-	config := params.MainnetChainConfig
-	baseFee := misc.CalcBaseFee(config, block.Header())
-	price, err := w.http.SuggestGasPrice(context.Background())
+	// 可以和 CallContract 合并
+	contractTransferHash := crypto.Keccak256Hash([]byte("transfer(address,uint256)"))
+	toAddressTmp := common.HexToAddress(toAddress)
+	data, err := makeEthERC20TransferData(contractTransferHash, &toAddressTmp, value)
 	if err != nil {
-		return "", "", err
+		return "", nil, nil, err
 	}
-	return price.String(), baseFee.String(), nil
+	return contractAddress, big.NewInt(0), data, nil
+}
+
+// GetGasPrice 各档位的费用
+func (w *Worker) GetGasPrice() (*FeeEstimate, error) {
+	return w.fee.Estimate()
+}
+
+// FeeTier 指定档位的费用
+func (w *Worker) FeeTier(tier string) (*FeeTier, error) {
+	return w.fee.Tier(tier)
 }
 
 // getBlockTransaction 获取主币的交易信息
@@ -435,247 +443,244 @@ func (w *Worker) callContract(contractAddress string, method string, params ...i
 	return hex, nil
 }
 
-// SendContractTrans 发送合约交易
-func (w *Worker) SendContractTrans(privateKeyStr string, tx *ethTypes.DynamicFeeTx) (string, string, uint64, error) {
-	privateKey, err := crypto.HexToECDSA(privateKeyStr)
+// SendContractTrans 发送合约交易 tier 为费用档位
+func (w *Worker) SendContractTrans(privateKeyStr string, tx *ethTypes.DynamicFeeTx, tier string) (string, string, uint64, error) {
+	signTx, err := w.SignContractTrans(privateKeyStr, tx, tier)
+	if err != nil {
+		return "", "", 0, err
+	}
+	ts, err := w.broadcast(signTx, true, tier)
 	if err != nil {
 		return "", "", 0, err
 	}
+	w.recordTrans(ts)
+	return ts.From, ts.Hash, ts.Nonce, nil
+}
+
+// SignContractTrans 模拟执行并签名合约交易 不广播 nonce 由 nonce 管理分配
+func (w *Worker) SignContractTrans(privateKeyStr string, tx *ethTypes.DynamicFeeTx, tier string) (*ethTypes.Transaction, error) {
+	privateKey, err := crypto.HexToECDSA(privateKeyStr)
+	if err != nil {
+		return nil, err
+	}
 	publicKey := privateKey.Public()
 	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
 	if !ok {
-		return "", "", 0, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+		return nil, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
 	}
 
 	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-	gasLimit, err := w.http.EstimateGas(context.Background(), ethereum.CallMsg{
+	// 广播前模拟执行 回滚的交易不发送
+	gasLimit, err := w.Simulate(ethereum.CallMsg{
 		From:  fromAddress,
 		Value: tx.Value,
 		To:    tx.To,
 		Data:  tx.Data,
 	})
 	if err != nil {
-		log.Error().Msgf("EstimateGas error: %s", err.Error())
-		return "", "", 0, err
-	}
-	gasTip, err := w.http.SuggestGasTipCap(context.Background())
-	if err != nil {
-		return "", "", 0, err
+		log.Error().Msgf("SignContractTrans Simulate err is %s ", err.Error())
+		return nil, err
 	}
-	gasPrice, err := w.http.SuggestGasPrice(context.Background())
+	fee, err := w.fee.Tier(tier)
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
 
-	number, err := w.http.BlockNumber(context.Background())
+	tx.GasTipCap = fee.MaxPriorityFeePerGas
+	tx.GasFeeCap = fee.MaxFeePerGas
+	tx.Gas = GasLimit(gasLimit)
+	chainID, err := w.http.NetworkID(context.Background())
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
 
-	w.nonceLock.Lock()
-	defer w.nonceLock.Unlock()
-	tx.Nonce, err = w.http.PendingNonceAt(context.Background(), fromAddress)
+	tx.Nonce, err = Nonces.Reserve(fromAddress)
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
-
-	block, err := w.http.BlockByNumber(context.Background(), big.NewInt(int64(number)))
+	// 签名
+	signTx, err := ethTypes.SignTx(ethTypes.NewTx(tx), ethTypes.LatestSignerForChainID(chainID), privateKey)
 	if err != nil {
-		log.Info().Msgf("BlockByNumber err is %s ", err.Error())
-		return "", "", 0, err
+		log.Error().Msgf("SignTx error: %s", err.Error())
+		Nonces.Release(fromAddress, tx.Nonce)
+		return nil, err
 	}
-	config := params.MainnetChainConfig
-	baseFee := misc.CalcBaseFee(config, block.Header())
+	return signTx, nil
+}
 
-	tx.GasTipCap = gasTip
-	tx.GasFeeCap = gasPrice.Add(gasPrice, baseFee)
-	tx.Gas = gasLimit * 2
-	txData := ethTypes.NewTx(tx)
-	// log.Info().Msgf("tx: %+v", tx)
-	chainID, err := w.http.NetworkID(context.Background())
+// SendRawTransaction 广播其他地方签名的交易 和本服务发送的交易一样记录到 pending 和交易历史
+// 广播失败时不归还 nonce 签名时分配的 nonce 超时后可以通过补洞处理 不是本服务分配的 nonce 不会记为已广播
+func (w *Worker) SendRawTransaction(signTx *ethTypes.Transaction) (*types.Transaction, error) {
+	ts, err := w.broadcast(signTx, false, "")
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
+	w.recordTrans(ts)
+	return ts, nil
+}
 
-	// 签名
-	signTx, err := ethTypes.SignTx(txData, ethTypes.LatestSignerForChainID(chainID), privateKey)
-	// log.Info().Msgf("signTx: %+v", signTx)
+// broadcast 广播签名后的交易并记录到 pending release 为 true 时节点拒绝交易归还 nonce
+// tier 为签名时的费用档位 自动加速按这个档位判断
+func (w *Worker) broadcast(signTx *ethTypes.Transaction, release bool, tier string) (*types.Transaction, error) {
+	fromAddress, err := ethTypes.Sender(ethTypes.LatestSignerForChainID(signTx.ChainId()), signTx)
 	if err != nil {
-		log.Error().Msgf("SignTx error: %s", err.Error())
-		return "", "", 0, err
+		return nil, err
+	}
+	nonce := signTx.Nonce()
+	ts := &types.Transaction{
+		From:      fromAddress.Hex(),
+		Value:     signTx.Value(),
+		Status:    uint(2),
+		Data:      signTx.Data(),
+		Nonce:     nonce,
+		Gas:       signTx.Gas(),
+		GasFeeCap: signTx.GasFeeCap(),
+		GasTipCap: signTx.GasTipCap(),
+		Tier:      tier,
+	}
+	if signTx.To() != nil {
+		ts.To = signTx.To().Hex()
 	}
-
 	err = w.http.SendTransaction(context.Background(), signTx)
 	if err != nil {
-		return "", "", 0, err
+		log.Error().Msgf("send transaction error %s", err.Error())
+		if !BroadcastRejected(err) {
+			// 节点可能已经收到交易 不归还 nonce 记录到 pending 由监控重新广播
+			w.track(signTx, ts)
+			return nil, err
+		}
+		if release {
+			Nonces.Release(fromAddress, nonce)
+		}
+		return nil, err
 	}
+	Nonces.Commit(fromAddress, nonce)
+	w.track(signTx, ts)
+	return ts, nil
+}
 
-	ts := &types.Transaction{
-		Hash:      txData.Hash().String(),
-		From:      fromAddress.String(),
-		To:        tx.To.String(),
-		Value:     tx.Value,
-		Status:    uint(0),
-		Data:      tx.Data,
-		Nonce:     tx.Nonce,
-		Gas:       tx.Gas,
-		GasFeeCap: tx.GasFeeCap,
-		GasTipCap: tx.GasTipCap,
-	}
-	w.pending.Store(signTx.Hash().Hex(), ts)
-	db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), "", int32(ts.Status), ts.Data)
-	return fromAddress.Hex(), signTx.Hash().Hex(), tx.Nonce, err
+// BroadcastRejected 广播的错误是否是节点明确拒绝 超时和网络错误时节点可能已经收到交易 不能当作失败
+// 节点已经有这笔交易 或者 nonce 已经被使用（可能就是这笔交易已经打包）也不是拒绝 以区块监听的结果为准
+func BroadcastRejected(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, known := range []string{"already known", "known transaction", "nonce too low"} {
+		if strings.Contains(msg, known) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordTrans 记录广播的交易 部署合约记录合约地址 代币转账记录真正的接收方和数量 上链后以监听到的为准
+func (w *Worker) recordTrans(ts *types.Transaction) {
+	if ts.To == "" {
+		// 部署合约 合约地址由发送方和 nonce 决定 上链后以凭证为准
+		ts.Action = db.ActionDeploy
+		ts.ContractAddress = crypto.CreateAddress(common.HexToAddress(ts.From), ts.Nonce).Hex()
+		db.UpDateDeployInfo(ts.Hash, ts.From, ts.ContractAddress, ts.Value.String(), int32(ts.Status), ts.Data, 0)
+		return
+	}
+	to, coinName, value := ts.To, "", ts.Value.String()
+	if method, ok := w.tokenAbi.Methods["transfer"]; ok && len(ts.Data) >= 4 && bytes.Equal(ts.Data[:4], method.ID) {
+		if params, err := method.Inputs.Unpack(ts.Data[4:]); err == nil {
+			to, coinName, value = params[0].(common.Address).Hex(), ts.To, params[1].(*big.Int).String()
+		}
+	}
+	db.UpDateTransInfo(ts.Hash, ts.From, to, value, coinName, int32(ts.Status), ts.Data, 0, 0)
 }
 
 // TODO 将所有交易都统一
 
-func (w *Worker) sendTransaction(contractAddress string, privateKeyStr string,
-	toAddress string, value *big.Int, value20 *big.Int, nonce uint64, data []byte, trans ...*types.Transaction) (string, string, uint64, error) {
-	//var trueValue *big.Int
-	//trueValue = value
-	txData := &ethTypes.DynamicFeeTx{}
-	var toAddressHex *common.Address
-	var gasLimit uint64
+// signTransaction 模拟执行 按费用档位签名交易
+func (w *Worker) signTransaction(privateKeyStr string, toAddress string, value *big.Int, nonce uint64, data []byte, tier string) (*ethTypes.Transaction, error) {
 	privateKey, err := crypto.HexToECDSA(privateKeyStr)
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
 
 	publicKey := privateKey.Public()
 	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
 	if !ok {
-		return "", "", 0, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+		return nil, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
 	}
 
 	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-	gasTip, err := w.http.SuggestGasTipCap(context.Background())
+	fee, err := w.fee.Tier(tier)
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
-	gasPrice, err := w.http.SuggestGasPrice(context.Background())
+	toAddressHex := common.HexToAddress(toAddress)
+
+	// 广播前用真实的发送方和金额模拟执行 回滚的交易不发送
+	gasLimit, err := w.Simulate(ethereum.CallMsg{
+		From:  fromAddress,
+		To:    &toAddressHex,
+		Value: value,
+		Data:  data,
+	}, &w.tokenAbi)
 	if err != nil {
-		return "", "", 0, err
+		log.Error().Msgf("signTransaction Simulate err is %s ", err.Error())
+		return nil, err
 	}
+	gasLimit = GasLimit(gasLimit)
 
-	// 加速减速或者取消
-	if len(trans) != 0 {
-		nonce, err = w.http.PendingNonceAt(context.Background(), fromAddress)
-		// 暂时只支持单一的操作
-		pend := trans[0]
-		toAddressTmp := common.HexToAddress(pend.To)
-		toAddressHex = &toAddressTmp
-		//txData.Data = pend.Data
-		txData.Gas = pend.Gas
-		txData.GasFeeCap = gasPrice.Mul(gasPrice, big.NewInt(2))
-		txData.GasTipCap = gasTip.Mul(gasTip, big.NewInt(2))
-		txData.To = toAddressHex
-		//txData.Value = pend.Value
-		txData.Nonce = nonce
-	} else {
-		if nonce <= 0 {
-			// 解决 nonce 竞争问题
-			w.nonceLock.Lock()
-			defer w.nonceLock.Unlock()
-			nonce, err = w.http.PendingNonceAt(context.Background(), fromAddress)
-			if err != nil {
-				return "", "", 0, err
-			}
-
-		}
-
-		//gasLimit = uint64(21000) // 在非合约中的转账 21000 是够的 但是在合约中 这个限制太小
-		//gasLimit = uint64(28000) //
-
-		if contractAddress != "" {
-			toAddressTmp := common.HexToAddress(contractAddress)
-			toAddressHex = &toAddressTmp
-			gasLimit, err = w.http.EstimateGas(context.Background(), ethereum.CallMsg{
-				To:   toAddressHex,
-				Data: data,
-			})
-			gasLimit *= 2
-		} else {
-
-			toAddressTmp := common.HexToAddress(toAddress)
-			toAddressHex = &toAddressTmp
-			// 标准转账 21000
-			gasLimit = uint64(21000)
-		}
+	chainID, err := w.http.NetworkID(context.Background())
+	if err != nil {
+		return nil, err
+	}
 
-		// 预估 gasLimit
-
-		// 20 币和 NFT 交易都可以通过这里 做处理 防止 value 传值错误
-		//if contractAddress != "" {
-		//	value = big.NewInt(0)
-		//
-		//	// 这里 因为是转移20代币 转账的操作是发送给合约的，由合约内部进行 transfer 操作，所以这笔交易是发送给 合约的
-		//	contractAddressHex := common.HexToAddress(contractAddress)
-		//	toAddressHex = &contractAddressHex
-		//}
-		txData = &ethTypes.DynamicFeeTx{
-			Nonce: nonce,
-			To:    toAddressHex,
-			Value: value,
-			Gas:   gasLimit,
-			// 最高的 gas 费
-			GasFeeCap: gasPrice,
-			// 最高小费单价
-			GasTipCap: gasTip,
-			Data:      data,
+	// 没有指定 nonce 时由 nonce 管理分配 广播失败归还
+	if nonce <= 0 {
+		nonce, err = Nonces.Reserve(fromAddress)
+		if err != nil {
+			return nil, err
 		}
 	}
-
-	log.Info().Msgf("tx: %+v", txData)
-	//ethTypes.DynamicFeeTx{}
-
-	tx := ethTypes.NewTx(txData)
-
-	chainID, err := w.http.NetworkID(context.Background())
-	if err != nil {
-		return "", "", 0, err
+	txData := &ethTypes.DynamicFeeTx{
+		Nonce: nonce,
+		To:    &toAddressHex,
+		Value: value,
+		Gas:   gasLimit,
+		// 最高的 gas 费
+		GasFeeCap: fee.MaxFeePerGas,
+		// 最高小费单价
+		GasTipCap: fee.MaxPriorityFeePerGas,
+		Data:      data,
 	}
+	log.Info().Msgf("tx: %+v", txData)
 
 	// 签名
-	signTx, err := ethTypes.SignTx(tx, ethTypes.LatestSignerForChainID(chainID), privateKey)
+	signTx, err := ethTypes.SignTx(ethTypes.NewTx(txData), ethTypes.LatestSignerForChainID(chainID), privateKey)
 	if err != nil {
-		return "", "", 0, err
+		Nonces.Release(fromAddress, nonce)
+		return nil, err
 	}
+	return signTx, nil
+}
 
-	err = w.http.SendTransaction(context.Background(), signTx)
+// BroadcastTransfer 广播 SignTransfer 签名的转账 并记录交易 失败时归还 nonce
+// toAddress value contractAddress tier 和签名时一致 代币转账记录真正的接收方和数量
+func (w *Worker) BroadcastTransfer(signTx *ethTypes.Transaction, toAddress string, value *big.Int, contractAddress string, tier string) (common.Address, error) {
+	ts, err := w.broadcast(signTx, true, tier)
 	if err != nil {
-		log.Error().Msgf("send transaction error %s", err.Error())
-		return "", "", 0, err
+		return common.Address{}, err
 	}
-	// TODO 处理交易失败的情况
-
-	//w.Pending[signTx.Hash().Hex()] = struct{}{}
-
-	// 要落地
-	ts := &types.Transaction{
-		Hash: tx.Hash().String(),
-		From: fromAddress.String(),
-		To:   txData.To.String(),
-		//Value:  trueValue,
-		Status:    uint(2),
-		Data:      data,
-		Nonce:     nonce,
-		Gas:       txData.Gas,
-		GasFeeCap: txData.GasFeeCap,
-		GasTipCap: txData.GasTipCap,
-	}
-	//e.TransHistory[fromAddress.String()] = append(e.TransHistory[fromAddress.String()], &types.Transaction{
-	//	Hash: tx.Hash().String(),
-	//	From: fromAddress.String(),
-	//	To:   txData.To.String(),
-	//	//Value:  trueValue,
-	//	Status: uint(0),
-	//})
 
+	// 代币转账记录真正的接收方和数量 上链后以监听到的为准
+	to, coinName, recordValue := ts.To, "", value
+	if contractAddress != "" {
+		to, coinName = toAddress, contractAddress
+	}
+	if recordValue == nil {
+		recordValue = big.NewInt(0)
+	}
 	// TODO 应该交给批处理
-	db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), "", int32(ts.Status), ts.Data)
-	w.pending.Store(signTx.Hash().Hex(), ts)
-
-	return fromAddress.Hex(), signTx.Hash().Hex(), nonce, nil
+	db.UpDateTransInfo(ts.Hash, ts.From, to, recordValue.String(), coinName, int32(ts.Status), ts.Data, 0, 0)
+	return common.HexToAddress(ts.From), nil
 }
 
 func (w *Worker) TransactionMethod(hash string) ([]byte, error) {
@@ -711,53 +716,13 @@ func (w *Worker) UpPackTransfer(data []byte) *types.Transfer {
 
 }
 
-// Cancel 取消
-func (w *Worker) Cancel(privateKey, from, txHash string) (string, string, uint64, error) {
-	//pend := w.GetPendingByHex(txHash)
-	//if pend == nil {
-	//	return "", "", 0, errors.New("target transaction not in pending")
-	//}
-	// 收款方为发送方 设置为取消
-	//pend.To = pend.From
-
-	//tx, isPend, err := w.http.TransactionByHash(context.Background(), common.HexToHash(txHash))
-	//if err != nil {
-	//	log.Error().Msgf("get transaction by hash error %s", err.Error())
-	//	return "", "", 0, err
-	//}
-	//if !isPend {
-	//	log.Info().Msgf("transaction %s not in pending", txHash)
-	//	return "", "", 0, errors.New("target transaction not in pending")
-	//}
-	pend := &types.Transaction{
-		//Data:      tx.Data(),
-		//Gas:       tx.Gas(),
-		//GasFeeCap: tx.GasFeeCap(),
-		//GasTipCap: tx.GasTipCap(),
-		To: from, // 收款方为发送方 设置为取消
-		//Nonce:     tx.Nonce(),
-		//Value:     tx.Value(),
-	}
-
-	transaction, s, u, err := w.sendTransaction("", privateKey, "", nil, nil, 0, nil, pend)
-	if err != nil {
-		return "", "", 0, err
-	}
-	return transaction, s, u, nil
-}
-
-// SpeedUp 加速
-func (w *Worker) SpeedUp(privateKey, txHash string) (string, string, uint64, error) {
-	pend := w.GetPendingByHex(txHash)
-	if pend == nil {
-		return "", "", 0, errors.New("target transaction not in pending")
-	}
-	// 收款方为发送方 设置为取消
-	transaction, s, u, err := w.sendTransaction("", privateKey, "", nil, nil, 0, nil, pend)
-	if err != nil {
-		return "", "", 0, err
+// toAddressOrNil 地址为空时返回 nil 表示创建合约
+func toAddressOrNil(address string) *common.Address {
+	if address == "" {
+		return nil
 	}
-	return transaction, s, u, nil
+	to := common.HexToAddress(address)
+	return &to
 }
 
 func makeEthERC20TransferData(contractTransferHash common.Hash, toAddress *common.Address, amount *big.Int) ([]byte, error) {
@@ -778,6 +743,12 @@ func (w *Worker) MulSignMode(to, coinName, num, timeStamp string) (int32, string
 	return 0, ""
 }
 
+// PersonalHash personal_sign 签名的哈希 消息前面加上 "\x19Ethereum Signed Message:\n" 和长度
+func PersonalHash(message []byte) []byte {
+	data := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message)
+	return crypto.Keccak256([]byte(data))
+}
+
 func (w *Worker) PersonalSign(message []byte, privateStr string) ([]byte, error) {
 
 	privateKey, err := crypto.HexToECDSA(privateStr)
@@ -785,12 +756,8 @@ func (w *Worker) PersonalSign(message []byte, privateStr string) ([]byte, error)
 		log.Error().Msgf("HexToECDSA error %s", err.Error())
 		return nil, err
 	}
-	// 是否要预 Hash
-	data := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message)
-	//
-	msg := crypto.Keccak256Hash([]byte(data))
 	// 传过来的数据 以及是 加头和 hash 后的 直接签名就好
-	signer, err := crypto.Sign(msg.Bytes(), privateKey)
+	signer, err := crypto.Sign(PersonalHash(message), privateKey)
 
 	if err != nil {
 		log.Error().Msgf("PersonalSign error %s", err.Error())
@@ -800,27 +767,54 @@ func (w *Worker) PersonalSign(message []byte, privateStr string) ([]byte, error)
 	return signer, nil
 }
 
+// SignDataV4 eth_signTypedData_v4 签名 v 为 27/28
 func (w *Worker) SignDataV4(data types.TypedData, privateStr string) (string, string, error) {
+	if err := data.Validate(); err != nil {
+		log.Info().Msgf("SignDataV4 Validate error %s ", err.Error())
+		return "", "", err
+	}
+	typedDataHash, _, err := types.TypedDataAndHash(data)
+	if err != nil {
+		log.Info().Msgf("TypedDataAndHash error %s ", err.Error())
+		return "", "", err
+	}
+	return signHash(typedDataHash, privateStr)
+}
+
+// SignDataV3 eth_signTypedData_v3 签名 和 v4 的区别是不支持数组
+func (w *Worker) SignDataV3(data types.TypedData, privateStr string) (string, string, error) {
+	if data.HasArray() {
+		return "", "", ErrTypedDataArray
+	}
+	return w.SignDataV4(data, privateStr)
+}
 
-	// var tData types.TypedData
+// SignDataV1 eth_signTypedData（v1）签名
+func (w *Worker) SignDataV1(fields []types.LegacyTypedDataField, privateStr string) (string, string, error) {
+	hash, err := types.LegacyTypedDataHash(fields)
+	if err != nil {
+		log.Info().Msgf("LegacyTypedDataHash error %s ", err.Error())
+		return "", "", err
+	}
+	return signHash(hash, privateStr)
+}
+
+// signHash 签名哈希 返回签名地址和签名 v 和 PersonalSign 一样为 27/28
+func signHash(hash []byte, privateStr string) (string, string, error) {
 	privateKey, err := crypto.HexToECDSA(privateStr)
 	if err != nil {
 		log.Error().Msgf("HexToECDSA error %s", err.Error())
 		return "", "", err
 	}
 	from := crypto.PubkeyToAddress(privateKey.PublicKey)
-	typedDataHash, _, err := types.TypedDataAndHash(data)
-	if err != nil {
-		log.Info().Msgf("TypedDataAndHash error %s ", err.Error())
-	}
-	signature, err := crypto.Sign(typedDataHash, privateKey)
+	signature, err := crypto.Sign(hash, privateKey)
 	if err != nil {
 		log.Info().Msgf("Sign error %s", err.Error())
 		return "", "", err
 	}
-
+	signature[crypto.RecoveryIDOffset] += 27
 	sigData := hexutil.Encode(signature)
-	log.Info().Msgf("SignDataV4 Res %s ", sigData)
+	log.Info().Msgf("signHash Res %s ", sigData)
 	return from.Hex(), sigData, nil
 }
 
@@ -832,6 +826,10 @@ func (w *Worker) GetTransactionByHash(hash string) (*RpcTransaction, error) {
 		log.Error().Msgf("GetTransactionByHash error %s", err.Error())
 		return nil, err
 	}
+	if jsonData == nil {
+		// 节点上没有这笔交易
+		return nil, ethereum.NotFound
+	}
 	log.Info().Msgf("GetTransactionByHash Res %v ", jsonData)
 	if jsonData.BlockHash == nil {
 		log.Info().Msgf("GetTransactionByHash Hash is %s is Pending", hash)
@@ -844,56 +842,43 @@ func (w *Worker) GetBlockNumber() (uint64, error) {
 	return w.http.BlockNumber(context.Background())
 }
 
-func (w *Worker) EstimateGas(from, to string, data []byte, value *big.Int) (uint64, error) {
-	fromHex := common.HexToAddress(from)
-	toHex := common.HexToAddress(to)
-	return w.http.EstimateGas(context.Background(), ethereum.CallMsg{
-		From:  fromHex,
-		To:    &toHex,
-		Data:  data,
-		Value: value,
-	})
+// EstimateGas 在指定区块上预估 gas
+func (w *Worker) EstimateGas(from, to string, data []byte, value *big.Int, block rpc.BlockNumber) (uint64, error) {
+	var gas hexutil.Uint64
+	args := []interface{}{toCallArg(from, to, data, value)}
+	// 部分节点不支持区块参数 最新区块时不传
+	if block != rpc.LatestBlockNumber {
+		args = append(args, block)
+	}
+	if err := w.wClient.CallContext(context.Background(), &gas, "eth_estimateGas", args...); err != nil {
+		return 0, callError(err, registeredAbis(to))
+	}
+	return uint64(gas), nil
 }
 
-func (w *Worker) ETHCall(from, to string, data []byte) ([]byte, error) {
-	fromHex := common.HexToAddress(from)
-	toHex := common.HexToAddress(to)
-	number, err := w.http.BlockNumber(context.Background())
-	if err != nil {
-		log.Error().Msgf("BlockNumber error %s", err.Error())
-		return nil, err
-	}
-	// TODO 应该使用这种方法
-	//w.wClient.CallContext()
-	//  最新的区块
-	res, err := w.http.CallContract(context.Background(), ethereum.CallMsg{
-		From: fromHex,
-		To:   &toHex,
-		Data: data,
-	}, big.NewInt(int64(number)))
+// ETHCall 在指定区块上执行只读调用 回滚时返回 *RevertError
+func (w *Worker) ETHCall(from, to string, data []byte, block rpc.BlockNumber) ([]byte, error) {
+	var res hexutil.Bytes
+	err := w.wClient.CallContext(context.Background(), &res, "eth_call", toCallArg(from, to, data, nil), block)
 	if err != nil {
-		log.Error().Msgf("CallContract error %s", err.Error())
-		return nil, err
+		log.Error().Msgf("ETHCall error %s", err.Error())
+		return nil, callError(err, registeredAbis(to))
 	}
 	return res, nil
 }
 
-func (w *Worker) GetBlockByNumber(number *big.Int, isFull bool) (interface{}, error) {
-	if isFull {
-		block, err := w.http.BlockByNumber(context.Background(), number)
-		if err != nil {
-			log.Error().Msgf("BlockByNumber error %s", err.Error())
-			return nil, err
-		}
-		return block, nil
-	}
-	block, err := w.http.HeaderByNumber(context.Background(), number)
+// GetBlockByNumber 获取区块 返回节点原始的 JSON isFull 为 true 时包含完整交易 否则只有交易哈希
+func (w *Worker) GetBlockByNumber(block rpc.BlockNumber, isFull bool) (json.RawMessage, error) {
+	var res json.RawMessage
+	err := w.wClient.CallContext(context.Background(), &res, "eth_getBlockByNumber", block, isFull)
 	if err != nil {
-		log.Error().Msgf("HeaderByNumber error %s", err.Error())
+		log.Error().Msgf("GetBlockByNumber error %s", err.Error())
 		return nil, err
 	}
-
-	return block, nil
+	if len(res) == 0 || string(res) == "null" {
+		return nil, ethereum.NotFound
+	}
+	return res, nil
 }
 
 func (w *Worker) CallContext() {
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/rs/zerolog/log"
+)
+
+// 费用档位 调用方不传时使用 normal
+const (
+	FeeSlow   = "slow"
+	FeeNormal = "normal"
+	FeeFast   = "fast"
+)
+
+const (
+	// feeHistoryBlocks 参考最近多少个区块
+	feeHistoryBlocks = 20
+	// feeCacheTTL 费用缓存时间 大约一个区块
+	feeCacheTTL = 6 * time.Second
+	// feeBaseMultiple maxFeePerGas 预留的 baseFee 倍数 连续满块时 baseFee 每块最多涨 12.5%
+	feeBaseMultiple = 2
+)
+
+// feeTiers 档位和 eth_feeHistory 中小费百分位的对应关系
+var feeTiers = []struct {
+	Name       string
+	Percentile float64
+}{
+	{FeeSlow, 10},
+	{FeeNormal, 50},
+	{FeeFast, 90},
+}
+
+// FeeTier 一个档位的费用
+type FeeTier struct {
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+	Wait                 time.Duration // 预计打包需要的时间
+}
+
+// FeeEstimate 费用预估
+type FeeEstimate struct {
+	BaseFee   *big.Int // 下一个区块的 baseFee
+	BlockTime time.Duration
+	Tiers     map[string]*FeeTier
+	Block     uint64 // 预估基于的最新区块
+}
+
+// Tier 获取档位的费用 不认识的档位使用 normal
+func (e *FeeEstimate) Tier(name string) *FeeTier {
+	if t, ok := e.Tiers[name]; ok {
+		return t
+	}
+	return e.Tiers[FeeNormal]
+}
+
+// FeeOracle 基于 eth_feeHistory 的费用预估 结果短暂缓存 所有发送交易的地方共用
+type FeeOracle struct {
+	http *ethclient.Client
+	lock sync.Mutex
+	last *FeeEstimate
+	at   time.Time
+}
+
+// NewFeeOracle 新建费用预估
+func NewFeeOracle(http *ethclient.Client) *FeeOracle {
+	return &FeeOracle{http: http}
+}
+
+// Estimate 获取各档位的费用 缓存没有过期时直接返回
+func (o *FeeOracle) Estimate() (*FeeEstimate, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.last != nil && time.Since(o.at) < feeCacheTTL {
+		return o.last, nil
+	}
+	est, err := o.fetch()
+	if err != nil {
+		log.Error().Msgf("FeeOracle Estimate err is %s ", err.Error())
+		return nil, err
+	}
+	o.last, o.at = est, time.Now()
+	return est, nil
+}
+
+// Tier 获取指定档位的费用
+func (o *FeeOracle) Tier(name string) (*FeeTier, error) {
+	est, err := o.Estimate()
+	if err != nil {
+		return nil, err
+	}
+	return est.Tier(name), nil
+}
+
+func (o *FeeOracle) fetch() (*FeeEstimate, error) {
+	percentiles := make([]float64, 0, len(feeTiers))
+	for _, t := range feeTiers {
+		percentiles = append(percentiles, t.Percentile)
+	}
+	history, err := o.http.FeeHistory(context.Background(), feeHistoryBlocks, nil, percentiles)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := o.http.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	blockTime := time.Duration(0)
+	if history.OldestBlock != nil && history.OldestBlock.Uint64() < latest.Number.Uint64() {
+		oldest, err := o.http.HeaderByNumber(context.Background(), history.OldestBlock)
+		if err != nil {
+			return nil, err
+		}
+		blocks := latest.Number.Uint64() - oldest.Number.Uint64()
+		blockTime = time.Duration(latest.Time-oldest.Time) * time.Second / time.Duration(blocks)
+	}
+	// BaseFee 比区块数多一个 最后一个是下一个区块的 baseFee
+	baseFee := big.NewInt(0)
+	if n := len(history.BaseFee); n > 0 && history.BaseFee[n-1] != nil {
+		baseFee = history.BaseFee[n-1]
+	}
+	rewards := history.Reward
+	if len(rewards) == 0 {
+		// 节点不支持小费百分位 使用节点建议的小费
+		tip, err := o.http.SuggestGasTipCap(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		rewards = [][]*big.Int{{tip, tip, tip}}
+	}
+	return &FeeEstimate{
+		BaseFee:   baseFee,
+		BlockTime: blockTime,
+		Tiers:     NewFeeTiers(baseFee, rewards, blockTime),
+		Block:     latest.Number.Uint64(),
+	}, nil
+}
+
+// NewFeeTiers 根据最近区块的小费百分位计算各档位的费用
+// 每个档位的小费取对应百分位在各区块中的中位数 高档位不低于低档位
+// 预计时间按最近区块中 最低百分位的小费不高于该档位小费的比例估算 比例为 p 时平均需要 1/p 个区块
+func NewFeeTiers(baseFee *big.Int, rewards [][]*big.Int, blockTime time.Duration) map[string]*FeeTier {
+	tiers := make(map[string]*FeeTier, len(feeTiers))
+	prev := big.NewInt(0)
+	for i, t := range feeTiers {
+		values := make([]*big.Int, 0, len(rewards))
+		for _, r := range rewards {
+			if i < len(r) && r[i] != nil {
+				values = append(values, r[i])
+			}
+		}
+		tip := median(values)
+		if tip.Cmp(prev) < 0 {
+			tip = new(big.Int).Set(prev)
+		}
+		prev = tip
+		maxFee := new(big.Int).Mul(baseFee, big.NewInt(feeBaseMultiple))
+		maxFee.Add(maxFee, tip)
+		tiers[t.Name] = &FeeTier{
+			MaxFeePerGas:         maxFee,
+			MaxPriorityFeePerGas: tip,
+			Wait:                 time.Duration(waitBlocks(tip, rewards)) * blockTime,
+		}
+	}
+	return tiers
+}
+
+// waitBlocks 预计需要等待的区块数
+func waitBlocks(tip *big.Int, rewards [][]*big.Int) int {
+	if len(rewards) == 0 {
+		return 1
+	}
+	included := 0
+	for _, r := range rewards {
+		if len(r) > 0 && r[0] != nil && r[0].Cmp(tip) <= 0 {
+			included++
+		}
+	}
+	if included == 0 {
+		// 最近的区块都不够 至少要等过参考的这些区块
+		return len(rewards) + 1
+	}
+	return (len(rewards) + included - 1) / included
+}
+
+func median(values []*big.Int) *big.Int {
+	if len(values) == 0 {
+		return big.NewInt(0)
+	}
+	sorted := make([]*big.Int, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+	return new(big.Int).Set(sorted[len(sorted)/2])
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestNewFeeTiers(t *testing.T) {
+	rewards := [][]*big.Int{
+		{big.NewInt(1), big.NewInt(2), big.NewInt(5)},
+		{big.NewInt(2), big.NewInt(3), big.NewInt(8)},
+		{big.NewInt(4), big.NewInt(4), big.NewInt(6)},
+		{big.NewInt(3), big.NewInt(3), big.NewInt(7)},
+	}
+	tiers := NewFeeTiers(big.NewInt(100), rewards, 2*time.Second)
+	want := map[string]struct {
+		tip, maxFee int64
+		wait        time.Duration
+	}{
+		FeeSlow:   {3, 203, 4 * time.Second},
+		FeeNormal: {3, 203, 4 * time.Second},
+		FeeFast:   {7, 207, 2 * time.Second},
+	}
+	for name, w := range want {
+		got := tiers[name]
+		if got.MaxPriorityFeePerGas.Int64() != w.tip || got.MaxFeePerGas.Int64() != w.maxFee || got.Wait != w.wait {
+			t.Fatalf("%s got tip %s maxFee %s wait %s", name, got.MaxPriorityFeePerGas, got.MaxFeePerGas, got.Wait)
+		}
+	}
+}
+
+func TestNewFeeTiersMonotonic(t *testing.T) {
+	// 高档位的百分位异常偏低时不低于低档位
+	rewards := [][]*big.Int{{big.NewInt(10), big.NewInt(5), big.NewInt(1)}}
+	tiers := NewFeeTiers(big.NewInt(0), rewards, time.Second)
+	if tiers[FeeNormal].MaxPriorityFeePerGas.Int64() != 10 || tiers[FeeFast].MaxPriorityFeePerGas.Int64() != 10 {
+		t.Fatalf("tiers not monotonic %s %s", tiers[FeeNormal].MaxPriorityFeePerGas, tiers[FeeFast].MaxPriorityFeePerGas)
+	}
+}
+
+func TestFeeEstimateTier(t *testing.T) {
+	est := &FeeEstimate{Tiers: NewFeeTiers(big.NewInt(1), [][]*big.Int{{big.NewInt(1), big.NewInt(2), big.NewInt(3)}}, time.Second)}
+	if est.Tier("") != est.Tiers[FeeNormal] || est.Tier("unknown") != est.Tiers[FeeNormal] {
+		t.Fatal("unknown tier should fall back to normal")
+	}
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrLogRange    = errors.New("invalid block range")
+	ErrLogTooLarge = errors.New("block range too large")
+)
+
+// LogPolicy 日志查询的分段策略
+type LogPolicy struct {
+	Chunk     uint64 // 每次向节点查询的区块数 分段按这个大小对齐 方便命中缓存
+	MaxBlocks uint64 // 一次查询最多的区块数
+}
+
+// NewLogPolicy 根据配置生成策略
+func NewLogPolicy(chunk, maxBlocks uint64) *LogPolicy {
+	if chunk == 0 {
+		chunk = 1
+	}
+	return &LogPolicy{Chunk: chunk, MaxBlocks: maxBlocks}
+}
+
+// LogQuery 日志的过滤条件 和 eth_getLogs 一致 BlockHash 不为空时忽略区块范围
+type LogQuery struct {
+	FromBlock rpc.BlockNumber
+	ToBlock   rpc.BlockNumber
+	BlockHash *common.Hash
+	Addresses []common.Address
+	Topics    [][]common.Hash
+}
+
+// logChunk 一段区块范围 Cache 表示整段已经最终确认 结果可以缓存
+type logChunk struct {
+	From, To uint64
+	Cache    bool
+}
+
+// logChunks 把区块范围按 size 对齐分段 已经最终确认的分段查询整段用来缓存 其他分段只查询需要的部分
+func logChunks(from, to, size, finalized uint64) []logChunk {
+	chunks := []logChunk{}
+	for start := from / size * size; start <= to; start += size {
+		end := start + size - 1
+		if end <= finalized {
+			chunks = append(chunks, logChunk{From: start, To: end, Cache: true})
+			continue
+		}
+		c := logChunk{From: start, To: end}
+		if c.From < from {
+			c.From = from
+		}
+		if c.To > to {
+			c.To = to
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks
+}
+
+// isTooManyResults 节点因为结果太多或者范围太大拒绝查询 缩小范围后可以重试
+func isTooManyResults(err error) bool {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "rate limit") {
+		return false
+	}
+	for _, s := range []string{"more than", "too many", "too large", "exceed", "limit"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLogs 查询日志 大范围按策略分段查询 节点返回结果太多时对半拆分重试 结果按区块和日志序号排序
+func (w *Worker) GetLogs(q *LogQuery, policy *LogPolicy) ([]ethTypes.Log, error) {
+	if q.BlockHash != nil {
+		return w.http.FilterLogs(context.Background(), ethereum.FilterQuery{
+			BlockHash: q.BlockHash,
+			Addresses: q.Addresses,
+			Topics:    q.Topics,
+		})
+	}
+	from, err := w.ResolveBlockNumber(q.FromBlock)
+	if err != nil {
+		return nil, err
+	}
+	to, err := w.ResolveBlockNumber(q.ToBlock)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, ErrLogRange
+	}
+	if policy.MaxBlocks > 0 && to-from+1 > policy.MaxBlocks {
+		return nil, ErrLogTooLarge
+	}
+	finalized, err := w.ResolveBlockNumber(rpc.FinalizedBlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	key := logFilterKey(q)
+	logs := []ethTypes.Log{}
+	for _, c := range logChunks(from, to, policy.Chunk, finalized) {
+		list, err := w.chunkLogs(q, key, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range list {
+			if l.BlockNumber >= from && l.BlockNumber <= to {
+				logs = append(logs, l)
+			}
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+	return logs, nil
+}
+
+// chunkLogs 查询一段 已经最终确认的分段先读缓存
+func (w *Worker) chunkLogs(q *LogQuery, key string, c logChunk) ([]ethTypes.Log, error) {
+	cacheKey := key + ":" + strconv.FormatUint(c.From, 10) + "-" + strconv.FormatUint(c.To, 10)
+	if c.Cache {
+		if data, ok := db.GetLogsCache(cacheKey); ok {
+			var logs []ethTypes.Log
+			if err := json.Unmarshal(data, &logs); err == nil {
+				return logs, nil
+			}
+		}
+	}
+	logs, err := w.filterLogs(q, c.From, c.To)
+	if err != nil {
+		return nil, err
+	}
+	if c.Cache {
+		if data, err := json.Marshal(logs); err == nil {
+			db.SetLogsCache(cacheKey, data)
+		}
+	}
+	return logs, nil
+}
+
+// filterLogs 向节点查询 结果太多时对半拆分
+func (w *Worker) filterLogs(q *LogQuery, from, to uint64) ([]ethTypes.Log, error) {
+	logs, err := w.http.FilterLogs(context.Background(), ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: q.Addresses,
+		Topics:    q.Topics,
+	})
+	if err == nil {
+		return logs, nil
+	}
+	if from == to || !isTooManyResults(err) {
+		log.Error().Msgf("filterLogs %d-%d err is %s ", from, to, err.Error())
+		return nil, err
+	}
+	mid := from + (to-from)/2
+	log.Info().Msgf("filterLogs %d-%d too many results split at %d ", from, to, mid)
+	left, err := w.filterLogs(q, from, mid)
+	if err != nil {
+		return nil, err
+	}
+	right, err := w.filterLogs(q, mid+1, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
+
+// logFilterKey 地址和 topic 相同的查询共用缓存
+func logFilterKey(q *LogQuery) string {
+	data, _ := json.Marshal([]interface{}{q.Addresses, q.Topics})
+	return crypto.Keccak256Hash(data).Hex()
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLogChunks(t *testing.T) {
+	// 1000-1099 已经最终确认 按 100 对齐
+	got := logChunks(1050, 1250, 100, 1099)
+	want := []logChunk{
+		{From: 1000, To: 1099, Cache: true},
+		{From: 1100, To: 1199},
+		{From: 1200, To: 1250},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+	got = logChunks(5, 5, 100, 1000)
+	if len(got) != 1 || got[0] != (logChunk{From: 0, To: 99, Cache: true}) {
+		t.Fatalf("single block got %+v", got)
+	}
+}
+
+func TestIsTooManyResults(t *testing.T) {
+	for _, msg := range []string{
+		"query returned more than 10000 results",
+		"Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range",
+		"exceed maximum block range: 5000",
+	} {
+		if !isTooManyResults(errors.New(msg)) {
+			t.Fatalf("%q should be too many results", msg)
+		}
+	}
+	for _, msg := range []string{"rate limit reached", "connection refused"} {
+		if isTooManyResults(errors.New(msg)) {
+			t.Fatalf("%q should not be too many results", msg)
+		}
+	}
+}
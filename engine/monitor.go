@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/types"
+	"github.com/rs/zerolog/log"
+)
+
+// StuckPolicy 卡住交易的处理策略 每个网络单独配置
+type StuckPolicy struct {
+	StuckAfter time.Duration // 广播多久后还没有打包才处理
+	Interval   time.Duration // 检查间隔
+	MaxBumps   int           // 最多自动加速的次数
+	MaxFee     *big.Int      // 自动加速允许的最高 maxFeePerGas
+}
+
+// NewStuckPolicy 根据配置生成策略 maxFeeGwei 为 0 表示不限制
+func NewStuckPolicy(stuckAfter, interval uint64, maxBumps int, maxFeeGwei uint64) *StuckPolicy {
+	p := &StuckPolicy{
+		StuckAfter: time.Duration(stuckAfter) * time.Second,
+		Interval:   time.Duration(interval) * time.Second,
+		MaxBumps:   maxBumps,
+	}
+	if maxFeeGwei > 0 {
+		p.MaxFee = new(big.Int).Mul(new(big.Int).SetUint64(maxFeeGwei), big.NewInt(params.GWei))
+	}
+	return p
+}
+
+// FeeGap 按当前 baseFee 和建议小费 交易的 maxFeePerGas 还差多少 小于等于 0 表示费用足够
+func FeeGap(ts *types.Transaction, baseFee, gasTip *big.Int) *big.Int {
+	need := new(big.Int).Add(baseFee, gasTip)
+	if ts.GasFeeCap == nil {
+		return need
+	}
+	return need.Sub(need, ts.GasFeeCap)
+}
+
+// IsStuck 交易广播超过等待时间 并且费用低于当前需要的费用
+func (p *StuckPolicy) IsStuck(ts *types.Transaction, baseFee, gasTip *big.Int, now time.Time) bool {
+	if ts.SentAt == 0 || now.Sub(time.UnixMilli(ts.SentAt)) < p.StuckAfter {
+		return false
+	}
+	if FeeGap(ts, baseFee, gasTip).Sign() > 0 {
+		return true
+	}
+	return ts.GasTipCap == nil || ts.GasTipCap.Cmp(gasTip) < 0
+}
+
+// AddServiceKey 登记本服务自己的钱包 这些钱包发出的交易卡住时也能自动加速
+func (w *Worker) AddServiceKey(privateKey string) error {
+	address, err := w.GetAddressByPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	w.serviceKeys.Store(strings.ToLower(address), privateKey)
+	return nil
+}
+
+// signingKey 发送方的私钥 先查用户钱包 再查本服务自己的钱包
+func (w *Worker) signingKey(address string) string {
+	if usr := db.GetUserFromDB(address); usr != nil {
+		return usr.PrivateKey
+	}
+	if key, ok := w.serviceKeys.Load(strings.ToLower(address)); ok {
+		return key.(string)
+	}
+	return ""
+}
+
+// CanBump 还能否自动加速
+func (p *StuckPolicy) CanBump(ts *types.Transaction) bool {
+	return ts.Bumps < p.MaxBumps
+}
+
+// StuckTip 交易发送时档位当前建议的小费 慢档的交易不按 normal 档判断 没有记录档位的按 normal
+func StuckTip(est *FeeEstimate, ts *types.Transaction) *big.Int {
+	return est.Tier(ts.Tier).MaxPriorityFeePerGas
+}
+
+// PendingList 本服务广播还没有打包的交易 address 为空表示所有地址
+func (w *Worker) PendingList(address string) []*types.Transaction {
+	list := []*types.Transaction{}
+	w.pending.Range(func(key, value interface{}) bool {
+		ts := value.(*types.Transaction)
+		if address == "" || strings.EqualFold(ts.From, address) {
+			list = append(list, ts)
+		}
+		return true
+	})
+	return list
+}
+
+// StartMonitor 定时检查卡住的交易 被丢弃的重新广播 费用不足的按策略自动加速
+func (w *Worker) StartMonitor(policy *StuckPolicy) {
+	log.Info().Msgf("StartMonitor start")
+	for {
+		<-time.After(policy.Interval)
+		w.checkPending(policy)
+	}
+}
+
+func (w *Worker) checkPending(policy *StuckPolicy) {
+	est, err := w.fee.Estimate()
+	if err != nil {
+		log.Error().Msgf("checkPending Estimate err is %s ", err.Error())
+		return
+	}
+	now := time.Now()
+	for _, ts := range w.PendingList("") {
+		if ts.SentAt == 0 {
+			continue
+		}
+		baseFee, gasTip := est.BaseFee, StuckTip(est, ts)
+		_, isPending, err := w.http.TransactionByHash(context.Background(), common.HexToHash(ts.Hash))
+		if err == ethereum.NotFound {
+			w.rebroadcast(ts, policy, baseFee, gasTip, now)
+			continue
+		}
+		if err != nil {
+			log.Error().Msgf("checkPending TransactionByHash err is %s ", err.Error())
+			continue
+		}
+		if !isPending {
+			// 已经打包 等区块监听确认后移除
+			continue
+		}
+		if policy.IsStuck(ts, baseFee, gasTip, now) {
+			w.bump(ts, policy)
+		}
+	}
+}
+
+// rebroadcast 交易被节点丢弃 nonce 还没有被使用时重新广播
+func (w *Worker) rebroadcast(ts *types.Transaction, policy *StuckPolicy, baseFee, gasTip *big.Int, now time.Time) {
+	nonce, err := w.http.NonceAt(context.Background(), common.HexToAddress(ts.From), nil)
+	if err != nil {
+		log.Error().Msgf("rebroadcast NonceAt err is %s ", err.Error())
+		return
+	}
+	if nonce > ts.Nonce {
+		// nonce 已经被其他交易使用
+		log.Info().Msgf("rebroadcast %s nonce %d already used ", ts.Hash, ts.Nonce)
+		w.RemovePendingByHex(ts.Hash)
+		return
+	}
+	if len(ts.Raw) == 0 {
+		return
+	}
+	signTx := new(ethTypes.Transaction)
+	if err := signTx.UnmarshalBinary(ts.Raw); err != nil {
+		log.Error().Msgf("rebroadcast UnmarshalBinary err is %s ", err.Error())
+		return
+	}
+	if err := w.http.SendTransaction(context.Background(), signTx); err != nil {
+		log.Info().Msgf("rebroadcast %s err is %s ", ts.Hash, err.Error())
+		// 费用太低被拒绝 加速后重新发送
+		if policy.IsStuck(ts, baseFee, gasTip, now) {
+			w.bump(ts, policy)
+		}
+		return
+	}
+	log.Info().Msgf("rebroadcast %s success ", ts.Hash)
+}
+
+// bump 按策略自动加速
+func (w *Worker) bump(ts *types.Transaction, policy *StuckPolicy) {
+	if !policy.CanBump(ts) {
+		return
+	}
+	key := w.signingKey(ts.From)
+	if key == "" {
+		log.Info().Msgf("bump %s skipped no signing key for %s ", ts.Hash, ts.From)
+		return
+	}
+	_, hash, _, err := w.replace(key, ts.Hash, db.ActionSpeedUp, policy.MaxFee)
+	if err != nil {
+		log.Info().Msgf("bump %s err is %s ", ts.Hash, err.Error())
+		return
+	}
+	if next := w.GetPendingByHex(hash); next != nil {
+		next.Bumps = ts.Bumps + 1
+		// 自动加速后还是按原来的档位判断
+		next.Tier = ts.Tier
+	}
+	log.Info().Msgf("bump %s to %s times %d ", ts.Hash, hash, ts.Bumps+1)
+}
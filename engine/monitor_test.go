@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lmxdawn/wallet/types"
+)
+
+func TestStuckPolicy(t *testing.T) {
+	p := NewStuckPolicy(60, 15, 2, 100)
+	now := time.Now()
+	baseFee, tip := big.NewInt(100), big.NewInt(2)
+	ts := &types.Transaction{
+		SentAt:    now.Add(-2 * time.Minute).UnixMilli(),
+		GasFeeCap: big.NewInt(90),
+		GasTipCap: big.NewInt(2),
+	}
+	if !p.IsStuck(ts, baseFee, tip, now) {
+		t.Fatal("underpriced old transaction should be stuck")
+	}
+	if p.IsStuck(&types.Transaction{SentAt: now.UnixMilli(), GasFeeCap: big.NewInt(90), GasTipCap: big.NewInt(2)}, baseFee, tip, now) {
+		t.Fatal("fresh transaction should not be stuck")
+	}
+	ts.GasFeeCap = big.NewInt(200)
+	if p.IsStuck(ts, baseFee, tip, now) {
+		t.Fatal("well priced transaction should not be stuck")
+	}
+	ts.Bumps = 2
+	if p.CanBump(ts) {
+		t.Fatal("max bumps reached")
+	}
+}
+
+func TestStuckTip(t *testing.T) {
+	p := NewStuckPolicy(60, 15, 2, 0)
+	now := time.Now()
+	est := &FeeEstimate{
+		BaseFee: big.NewInt(100),
+		Tiers: map[string]*FeeTier{
+			FeeSlow:   {MaxPriorityFeePerGas: big.NewInt(1)},
+			FeeNormal: {MaxPriorityFeePerGas: big.NewInt(2)},
+		},
+	}
+	ts := &types.Transaction{
+		SentAt:    now.Add(-2 * time.Minute).UnixMilli(),
+		GasFeeCap: big.NewInt(201),
+		GasTipCap: big.NewInt(1),
+		Tier:      FeeSlow,
+	}
+	if p.IsStuck(ts, est.BaseFee, StuckTip(est, ts), now) {
+		t.Fatal("slow tier transaction priced at the slow tip should not be stuck")
+	}
+	ts.Tier = ""
+	if !p.IsStuck(ts, est.BaseFee, StuckTip(est, ts), now) {
+		t.Fatal("transaction without tier should be judged against normal")
+	}
+}
+
+type rpcErr string
+
+func (e rpcErr) Error() string  { return string(e) }
+func (e rpcErr) ErrorCode() int { return -32000 }
+
+func TestBroadcastRejected(t *testing.T) {
+	if !BroadcastRejected(fmt.Errorf("send: %w", rpcErr("insufficient funds for gas * price + value"))) {
+		t.Fatal("json-rpc error from the node is a rejection")
+	}
+	if BroadcastRejected(rpcErr("already known")) || BroadcastRejected(rpcErr("nonce too low")) {
+		t.Fatal("a transaction the node already has is not rejected")
+	}
+	if BroadcastRejected(context.DeadlineExceeded) || BroadcastRejected(errors.New("connection reset")) {
+		t.Fatal("timeout and network errors are not definitive")
+	}
+}
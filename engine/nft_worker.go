@@ -7,17 +7,14 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/consensus/misc"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/params"
 	"github.com/lmxdawn/wallet/types"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"math/big"
 	"strings"
-	"sync"
 )
 
 var NFT *NFTWorker
@@ -27,7 +24,6 @@ type NFTWorker struct {
 	tokenTransferEventHash common.Hash
 	tokenAbi               abi.ABI             // 合约的abi
 	Pending                map[string]struct{} // 待执行的交易
-	nonceLock              sync.Mutex
 }
 
 // NewNFTWorker 新建 NFT 交易者
@@ -58,7 +54,20 @@ func NewNFTWorker(url string) error {
 }
 
 // NFTTransfer NFT 转账 在这里直接走NFT的转账交易就行了 特殊处理 不和币种一样 循环监听
-func NFTTransfer(contractAddress, from, privateKey string, to, tokenID string) (string, string, uint64, error) {
+func NFTTransfer(contractAddress, from, privateKey string, to, tokenID string, tier string) (string, string, uint64, error) {
+	signTx, err := SignNFTTransfer(contractAddress, from, privateKey, to, tokenID, tier)
+	if err != nil {
+		return "", "", 0, err
+	}
+	ts, err := EWorker.broadcast(signTx, true, tier)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return ts.From, ts.Hash, ts.Nonce, nil
+}
+
+// SignNFTTransfer 模拟执行并签名 NFT 转账 不广播
+func SignNFTTransfer(contractAddress, from, privateKey string, to, tokenID string, tier string) (*ethTypes.Transaction, error) {
 
 	contractTransferHashSig := []byte("transferFrom(address,address,uint256)")
 	contractTransferHash := crypto.Keccak256Hash(contractTransferHashSig)
@@ -69,77 +78,66 @@ func NFTTransfer(contractAddress, from, privateKey string, to, tokenID string) (
 	data, err := makeEthERC721TransferData(contractTransferHash, formAddressHex, toAddressHex, tokenID)
 	if err != nil {
 		log.Error().Msgf("makeEthERC721TransferData err is %s ", err.Error())
-		return "", "", 0, err
+		return nil, err
 	}
-	return send721Transaction(contractAddress, privateKey, data)
+	return sign721Transaction(contractAddress, privateKey, data, tier)
 }
 
-func send721Transaction(contractAddress string, privateKeyStr string, data []byte) (string, string, uint64, error) {
+func sign721Transaction(contractAddress string, privateKeyStr string, data []byte, tier string) (*ethTypes.Transaction, error) {
 	var nonce uint64
 	privateKey, err := crypto.HexToECDSA(privateKeyStr)
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
 
 	publicKey := privateKey.Public()
 	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
 	if !ok {
-		return "", "", 0, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+		return nil, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
 	}
 	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-	NFT.nonceLock.Lock()
-	defer NFT.nonceLock.Unlock()
-	nonce, err = NFT.http.PendingNonceAt(context.Background(), fromAddress)
-	if err != nil {
-		return "", "", 0, err
-	}
 	var toAddressHex *common.Address
 	toAddressTmp := common.HexToAddress(contractAddress)
 	toAddressHex = &toAddressTmp
 	var gasLimit uint64
 	//gasLimit = uint64(21000) // 在非合约中的转账 21000 是够的 但是在合约中 这个限制太小
 	//gasLimit = uint64(200000) //
-	// 预估 gasLimit
-	gasLimit, err = NFT.http.EstimateGas(context.Background(), ethereum.CallMsg{
-		//From: fromAddress,
+	// 广播前用真实的发送方模拟执行 回滚的交易不发送
+	gasLimit, err = EWorker.Simulate(ethereum.CallMsg{
+		From: fromAddress,
 		To:   toAddressHex,
 		Data: data,
-	})
+	}, &NFT.tokenAbi)
 	if err != nil {
-		log.Error().Msgf("EstimateGas err is %s ", err.Error())
+		log.Error().Msgf("sign721Transaction Simulate err is %s ", err.Error())
+		return nil, err
 	}
 
 	log.Info().Msgf("gasLimit is %d ", gasLimit)
-	gasPrice, err := NFT.http.SuggestGasPrice(context.Background())
-	if err != nil {
-		return "", "", 0, err
-	}
-	gasTip, err := NFT.http.SuggestGasTipCap(context.Background())
+	// 和 Worker 共用费用预估
+	fee, err := EWorker.FeeTier(tier)
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
-	number, err := NFT.http.BlockNumber(context.Background())
+
+	chainID, err := NFT.http.NetworkID(context.Background())
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
 
-	// 获取 baseFee
-	block, err := NFT.http.BlockByNumber(context.Background(), big.NewInt(int64(number)))
+	// 和 Worker 共用 nonce 管理 同一地址不会分配到相同的 nonce
+	nonce, err = Nonces.Reserve(fromAddress)
 	if err != nil {
-		log.Info().Msgf("BlockByNumber err is %s ", err.Error())
-		return "", "", 0, err
+		return nil, err
 	}
-	config := params.MainnetChainConfig
-	baseFee := misc.CalcBaseFee(config, block.Header())
 
-	// NFT 转账 polygon 没有计算 baseFee
 	txData := &ethTypes.DynamicFeeTx{
 		Nonce: nonce,
 		To:    toAddressHex,
 		// gas 单位上限
-		Gas:       gasLimit * 2,
-		GasFeeCap: gasPrice.Add(gasPrice, baseFee),
-		GasTipCap: gasTip,
+		Gas:       GasLimit(gasLimit),
+		GasFeeCap: fee.MaxFeePerGas,
+		GasTipCap: fee.MaxPriorityFeePerGas,
 		Data:      data,
 	}
 	// 使用type 0 的方式能够完成交易
@@ -156,22 +154,13 @@ func send721Transaction(contractAddress string, privateKeyStr string, data []byt
 	//}
 	tx := ethTypes.NewTx(txData)
 
-	chainID, err := NFT.http.NetworkID(context.Background())
-	if err != nil {
-		return "", "", 0, err
-	}
-
 	// 签名
 	signTx, err := ethTypes.SignTx(tx, ethTypes.LatestSignerForChainID(chainID), privateKey)
 	if err != nil {
-		return "", "", 0, err
-	}
-	err = NFT.http.SendTransaction(context.Background(), signTx)
-	if err != nil {
-		return "", "", 0, err
+		Nonces.Release(fromAddress, nonce)
+		return nil, err
 	}
-
-	return fromAddress.Hex(), signTx.Hash().Hex(), nonce, nil
+	return signTx, nil
 }
 
 // CheckIsOwner 检查是否是 NFT 的拥有者
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/types"
+	"github.com/rs/zerolog/log"
+)
+
+// nonceLease 分配后超过这个时间还没有广播结果 认为请求已经中断 这个 nonce 可以被补洞占用
+const nonceLease = 5 * time.Minute
+
+// NonceManager 按地址分配 nonce 状态保存在 Redis 中 多个实例和 Worker/NFTWorker 共用
+type NonceManager struct {
+	http *ethclient.Client
+}
+
+// Nonces 全局的 nonce 管理
+var Nonces *NonceManager
+
+// Reserve 为地址分配一个 nonce 广播后必须调用 Commit 或 Release
+func (m *NonceManager) Reserve(from common.Address) (uint64, error) {
+	chainNonce, err := m.http.PendingNonceAt(context.Background(), from)
+	if err != nil {
+		return 0, err
+	}
+	return db.ReserveNonce(from.Hex(), chainNonce)
+}
+
+// Commit 交易广播成功 只处理 Reserve 或补洞分配过的 nonce
+func (m *NonceManager) Commit(from common.Address, nonce uint64) {
+	db.CommitNonce(from.Hex(), nonce)
+}
+
+// Release 交易签名或广播失败 归还 nonce
+func (m *NonceManager) Release(from common.Address, nonce uint64) {
+	db.ReleaseNonce(from.Hex(), nonce)
+}
+
+// Gaps 检查地址的 nonce 空洞 空洞之后的交易在链上无法打包
+// 链上 pending nonce 之后 分配过但没有在广播中也没有广播成功的都是空洞
+// 链上 pending nonce 本身已经广播过却不在交易池中 说明交易被丢弃了 同样是空洞
+func (m *NonceManager) Gaps(from common.Address) ([]uint64, *db.NonceState, error) {
+	chainNonce, err := m.http.PendingNonceAt(context.Background(), from)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := db.GetNonceState(from.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+	sent := make(map[uint64]bool, len(state.Sent))
+	for _, n := range state.Sent {
+		sent[n] = true
+	}
+	expire := time.Now().Add(-nonceLease).UnixMilli()
+	gaps := []uint64{}
+	for n := chainNonce; n < state.Next; n++ {
+		if at, ok := state.Inflight[n]; ok && at > expire {
+			continue
+		}
+		if sent[n] && n != chainNonce {
+			continue
+		}
+		gaps = append(gaps, n)
+	}
+	return gaps, state, nil
+}
+
+// Repair 用 0 金额的转给自己的交易填补 nonce 空洞 返回补洞交易的哈希
+func (m *NonceManager) Repair(privateKeyStr string) ([]string, error) {
+	privateKey, err := crypto.HexToECDSA(privateKeyStr)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyECDSA, ok := privateKey.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+	}
+	from := crypto.PubkeyToAddress(*publicKeyECDSA)
+	gaps, _, err := m.Gaps(from)
+	if err != nil {
+		return nil, err
+	}
+	if len(gaps) == 0 {
+		return []string{}, nil
+	}
+	fee, err := EWorker.FeeTier(FeeFast)
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := m.http.NetworkID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	for _, nonce := range gaps {
+		claimed, err := db.ClaimNonce(from.Hex(), nonce, nonceLease)
+		if err != nil {
+			return hashes, err
+		}
+		if !claimed {
+			continue
+		}
+		// 被丢弃的交易可能还在部分节点的交易池中 提高费用保证能替换掉
+		tx := ethTypes.NewTx(&ethTypes.DynamicFeeTx{
+			Nonce:     nonce,
+			To:        &from,
+			Value:     big.NewInt(0),
+			Gas:       21000,
+			GasFeeCap: new(big.Int).Mul(fee.MaxFeePerGas, big.NewInt(2)),
+			GasTipCap: new(big.Int).Mul(fee.MaxPriorityFeePerGas, big.NewInt(2)),
+		})
+		signTx, err := ethTypes.SignTx(tx, ethTypes.LatestSignerForChainID(chainID), privateKey)
+		if err != nil {
+			m.Release(from, nonce)
+			return hashes, err
+		}
+		if err = m.http.SendTransaction(context.Background(), signTx); err != nil {
+			log.Error().Msgf("Repair nonce %d err is %s ", nonce, err.Error())
+			m.Release(from, nonce)
+			return hashes, err
+		}
+		m.Commit(from, nonce)
+		hashes = append(hashes, signTx.Hash().Hex())
+		EWorker.track(signTx, &types.Transaction{
+			Hash:      signTx.Hash().Hex(),
+			From:      from.Hex(),
+			To:        from.Hex(),
+			Value:     big.NewInt(0),
+			Status:    uint(2),
+			Nonce:     nonce,
+			Gas:       signTx.Gas(),
+			GasFeeCap: signTx.GasFeeCap(),
+			GasTipCap: signTx.GasTipCap(),
+		})
+		db.UpDateTransInfo(signTx.Hash().Hex(), from.Hex(), from.Hex(), "0", "", 2, nil, 0, 0)
+		log.Info().Msgf("Repair nonce %d of %s with %s ", nonce, from.Hex(), signTx.Hash().Hex())
+	}
+	return hashes, nil
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// 批量打款的方式
+const (
+	PayoutSequential = "sequential" // 热钱包按 nonce 顺序逐笔发送
+	PayoutDisperse   = "disperse"   // 每个币种按批次调用 Disperse 合约
+)
+
+// DisperseChunk 一笔 Disperse 调用最多的接收方数量 防止超过区块的 gas 上限
+const DisperseChunk = 100
+
+// disperseAbiStr Disperse 合约 https://disperse.app
+const disperseAbiStr = `[
+	{"inputs":[{"name":"recipients","type":"address[]"},{"name":"values","type":"uint256[]"}],"name":"disperseEther","outputs":[],"stateMutability":"payable","type":"function"},
+	{"inputs":[{"name":"token","type":"address"},{"name":"recipients","type":"address[]"},{"name":"values","type":"uint256[]"}],"name":"disperseToken","outputs":[],"stateMutability":"nonpayable","type":"function"}
+]`
+
+var disperseAbi, _ = abi.JSON(strings.NewReader(disperseAbiStr))
+
+var (
+	ErrReceiptTimeout  = errors.New("wait for transaction receipt timeout")
+	ErrTxApproveFailed = errors.New("token approve transaction failed")
+)
+
+// DisperseCall Disperse 合约的调用 原生币调用 disperseEther 金额为总和 代币调用 disperseToken
+func DisperseCall(coinName string, recipients []common.Address, values []*big.Int) (*big.Int, []byte, error) {
+	if coinName == "" {
+		total := big.NewInt(0)
+		for _, v := range values {
+			total.Add(total, v)
+		}
+		data, err := disperseAbi.Pack("disperseEther", recipients, values)
+		return total, data, err
+	}
+	data, err := disperseAbi.Pack("disperseToken", common.HexToAddress(coinName), recipients, values)
+	return big.NewInt(0), data, err
+}
+
+// EstimateTransfer 用真实的发送方模拟转账 返回预估的 gas
+func (w *Worker) EstimateTransfer(from, toAddress string, value *big.Int, contractAddress string) (uint64, error) {
+	to, value, data, err := w.transferCall(toAddress, value, contractAddress)
+	if err != nil {
+		return 0, err
+	}
+	return w.EstimateCall(from, to, value, data)
+}
+
+// EstimateCall 用真实的发送方模拟调用 返回预估的 gas
+func (w *Worker) EstimateCall(from, to string, value *big.Int, data []byte) (uint64, error) {
+	toAddress := common.HexToAddress(to)
+	return w.Simulate(ethereum.CallMsg{
+		From:  common.HexToAddress(from),
+		To:    &toAddress,
+		Value: value,
+		Data:  data,
+	}, &w.tokenAbi, &disperseAbi)
+}
+
+// SignCall 模拟执行并签名合约调用 不广播 nonce 由 nonce 管理分配
+func (w *Worker) SignCall(privateKeyStr, to string, value *big.Int, data []byte, tier string) (*ethTypes.Transaction, error) {
+	return w.signTransaction(privateKeyStr, to, value, 0, data, tier)
+}
+
+// Allowance 代币授权给 spender 的额度
+func (w *Worker) Allowance(contractAddress, owner, spender string) (*big.Int, error) {
+	res, err := w.callContract(contractAddress, "allowance", common.HexToAddress(owner), common.HexToAddress(spender))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(res), nil
+}
+
+// ApproveData 代币授权的调用数据
+func (w *Worker) ApproveData(spender string, amount *big.Int) ([]byte, error) {
+	return w.tokenAbi.Pack("approve", common.HexToAddress(spender), amount)
+}
+
+// WaitReceipt 等待交易上链 返回交易的执行状态 1 成功 0 失败
+func (w *Worker) WaitReceipt(hash common.Hash, timeout time.Duration) (uint64, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		receipt, err := w.http.TransactionReceipt(context.Background(), hash)
+		if err == nil {
+			return receipt.Status, nil
+		}
+		if err != ethereum.NotFound {
+			return 0, err
+		}
+		if time.Now().After(deadline) {
+			return 0, ErrReceiptTimeout
+		}
+		time.Sleep(3 * time.Second)
+	}
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDisperseCall(t *testing.T) {
+	recipients := []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02")}
+	values := []*big.Int{big.NewInt(3), big.NewInt(4)}
+
+	value, data, err := DisperseCall("", recipients, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Int64() != 7 || !bytes.Equal(data[:4], disperseAbi.Methods["disperseEther"].ID) {
+		t.Fatalf("disperseEther got value %s selector %x", value, data[:4])
+	}
+
+	value, data, err = DisperseCall("0x00000000000000000000000000000000000000aa", recipients, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Sign() != 0 || !bytes.Equal(data[:4], disperseAbi.Methods["disperseToken"].ID) {
+		t.Fatalf("disperseToken got value %s selector %x", value, data[:4])
+	}
+	args, err := disperseAbi.Methods["disperseToken"].Inputs.Unpack(data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0].(common.Address) != common.HexToAddress("0xaa") || len(args[1].([]common.Address)) != 2 {
+		t.Fatalf("disperseToken args got %v", args)
+	}
+}
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/types"
+	"github.com/rs/zerolog/log"
+)
+
+// MinReplaceBump 节点替换交易池中相同 nonce 交易要求的最小涨幅 百分比 对应 geth 的 txpool.pricebump
+const MinReplaceBump = 10
+
+var (
+	ErrTxNotPending = errors.New("target transaction not in pending")
+	ErrTxNotOwner   = errors.New("target transaction not sent by this wallet")
+	ErrFeeCeiling   = errors.New("replacement fee exceeds the fee ceiling")
+)
+
+// BumpFee 替换交易的费用 至少是原费用加上最小涨幅 当前建议费用更高时使用建议费用
+func BumpFee(old, suggested *big.Int) *big.Int {
+	// 向上取整 old * (100 + bump) / 100
+	min := new(big.Int).Mul(old, big.NewInt(100+MinReplaceBump))
+	min.Add(min, big.NewInt(99))
+	min.Div(min, big.NewInt(100))
+	if suggested != nil && suggested.Cmp(min) > 0 {
+		return new(big.Int).Set(suggested)
+	}
+	return min
+}
+
+// Cancel 取消 用原交易的 nonce 发送 0 金额给自己
+func (w *Worker) Cancel(privateKey, txHash string) (string, string, uint64, error) {
+	return w.replace(privateKey, txHash, db.ActionCancel, nil)
+}
+
+// SpeedUp 加速 用原交易的 nonce 和内容提高费用重发
+func (w *Worker) SpeedUp(privateKey, txHash string) (string, string, uint64, error) {
+	return w.replace(privateKey, txHash, db.ActionSpeedUp, nil)
+}
+
+// replace 替换交易池中还没有打包的交易 maxFee 不为 nil 时新的 maxFeePerGas 不能超过它
+func (w *Worker) replace(privateKeyStr, txHash, action string, maxFee *big.Int) (string, string, uint64, error) {
+	privateKey, err := crypto.HexToECDSA(privateKeyStr)
+	if err != nil {
+		return "", "", 0, err
+	}
+	publicKeyECDSA, ok := privateKey.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return "", "", 0, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+	}
+	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
+
+	orig, isPending, err := w.http.TransactionByHash(context.Background(), common.HexToHash(txHash))
+	if err == ethereum.NotFound {
+		// 被节点丢弃的交易 使用广播时保存的交易
+		if pend := w.GetPendingByHex(txHash); pend != nil && len(pend.Raw) > 0 {
+			orig = new(ethTypes.Transaction)
+			err = orig.UnmarshalBinary(pend.Raw)
+			isPending = err == nil
+		}
+	}
+	if err != nil {
+		log.Error().Msgf("replace TransactionByHash err is %s ", err.Error())
+		return "", "", 0, err
+	}
+	if !isPending {
+		return "", "", 0, ErrTxNotPending
+	}
+	chainID, err := w.http.NetworkID(context.Background())
+	if err != nil {
+		return "", "", 0, err
+	}
+	signer := ethTypes.LatestSignerForChainID(chainID)
+	sender, err := ethTypes.Sender(signer, orig)
+	if err != nil || sender != fromAddress {
+		return "", "", 0, ErrTxNotOwner
+	}
+
+	// 替换交易至少按 fast 档位的费用
+	fee, err := w.fee.Tier(FeeFast)
+	if err != nil {
+		return "", "", 0, err
+	}
+	tip := BumpFee(orig.GasTipCap(), fee.MaxPriorityFeePerGas)
+	feeCap := BumpFee(orig.GasFeeCap(), fee.MaxFeePerGas)
+	if feeCap.Cmp(tip) < 0 {
+		feeCap = new(big.Int).Set(tip)
+	}
+	if maxFee != nil && feeCap.Cmp(maxFee) > 0 {
+		return "", "", 0, ErrFeeCeiling
+	}
+
+	txData := &ethTypes.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     orig.Nonce(),
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+	}
+	if action == db.ActionCancel {
+		txData.To = &fromAddress
+		txData.Value = big.NewInt(0)
+		txData.Gas = 21000
+	} else {
+		txData.To = orig.To()
+		txData.Value = orig.Value()
+		txData.Data = orig.Data()
+		txData.Gas = orig.Gas()
+	}
+
+	signTx, err := ethTypes.SignTx(ethTypes.NewTx(txData), signer, privateKey)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if err = w.http.SendTransaction(context.Background(), signTx); err != nil {
+		log.Error().Msgf("replace SendTransaction err is %s ", err.Error())
+		return "", "", 0, err
+	}
+
+	ts := &types.Transaction{
+		Hash:      signTx.Hash().Hex(),
+		From:      fromAddress.Hex(),
+		Value:     txData.Value,
+		Status:    uint(2),
+		Data:      txData.Data,
+		Nonce:     txData.Nonce,
+		Gas:       txData.Gas,
+		GasFeeCap: txData.GasFeeCap,
+		GasTipCap: txData.GasTipCap,
+		Action:    action,
+		Tier:      FeeFast,
+	}
+	if txData.To != nil {
+		ts.To = txData.To.Hex()
+	}
+	w.RemovePendingByHex(orig.Hash().Hex())
+	w.track(signTx, ts)
+
+	record := &db.Transfer{
+		Hex:      ts.Hash,
+		From:     ts.From,
+		To:       ts.From,
+		Value:    "0",
+		Data:     ts.Data,
+		Status:   int32(ts.Status),
+		Action:   action,
+		Replaces: orig.Hash().Hex(),
+	}
+	if action == db.ActionSpeedUp {
+		// 加速沿用原交易记录中的接收方和币种 代币转账的接收方不是 To
+		record.To, record.Value = ts.To, ts.Value.String()
+		if old := db.GetTransferByHash(orig.Hash().Hex()); old != nil {
+			record.To, record.Value, record.CoinName = old.To, old.Value, old.CoinName
+			record.ContractAddress = old.ContractAddress
+		}
+	}
+	db.UpDateReplaceInfo(record)
+	// 提现订单和批量打款跟踪新的交易 否则替换后的交易打包了订单也不会确认
+	db.RelinkWithdrawHash(record.Replaces, ts.Hash)
+	db.RelinkPayoutHash(record.Replaces, ts.Hash)
+	return fromAddress.Hex(), ts.Hash, ts.Nonce, nil
+}
@@ -0,0 +1,23 @@
+package engine
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBumpFee(t *testing.T) {
+	cases := []struct {
+		old, suggested, want int64
+	}{
+		{100, 0, 110},
+		{101, 0, 112}, // 向上取整
+		{100, 150, 150},
+		{100, 105, 110},
+	}
+	for _, c := range cases {
+		got := BumpFee(big.NewInt(c.old), big.NewInt(c.suggested))
+		if got.Int64() != c.want {
+			t.Fatalf("BumpFee(%d, %d) = %s want %d", c.old, c.suggested, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+)
+
+// RawCall 原样转发 JSON-RPC 请求到节点 结果不做解析
+func (w *Worker) RawCall(method string, params []json.RawMessage) (json.RawMessage, error) {
+	args := make([]interface{}, 0, len(params))
+	for _, p := range params {
+		args = append(args, p)
+	}
+	var res json.RawMessage
+	if err := w.wClient.CallContext(context.Background(), &res, method, args...); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		res = json.RawMessage("null")
+	}
+	return res, nil
+}
+
+// ChainID 当前网络的 chainId
+func (w *Worker) ChainID() (*big.Int, error) {
+	return w.http.ChainID(context.Background())
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// eip1271Abi 合约钱包的签名验证 https://eips.ethereum.org/EIPS/eip-1271
+const eip1271AbiStr = `[{"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],"name":"isValidSignature","outputs":[{"name":"","type":"bytes4"}],"stateMutability":"view","type":"function"}]`
+
+var eip1271Abi, _ = abi.JSON(strings.NewReader(eip1271AbiStr))
+
+// eip1271MagicValue 签名有效时 isValidSignature 的返回值
+var eip1271MagicValue = []byte{0x16, 0x26, 0xba, 0x7e}
+
+var (
+	ErrSignatureLength = errors.New("signature must be 65 bytes")
+	ErrTypedDataArray  = errors.New("eth_signTypedData_v3 does not support arrays")
+)
+
+// RecoverAddress 从签名中恢复签名地址 v 支持 0/1 和 27/28
+func RecoverAddress(hash []byte, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, ErrSignatureLength
+	}
+	s := make([]byte, len(sig))
+	copy(s, sig)
+	if s[crypto.RecoveryIDOffset] >= 27 {
+		s[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(hash, s)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// 签名验证的方式
+const (
+	SignatureEcrecover = "ecrecover" // 普通地址 从签名恢复地址
+	SignatureEIP1271   = "eip1271"   // 合约钱包 调用 isValidSignature
+)
+
+// SignatureCheck 签名验证的结果
+type SignatureCheck struct {
+	Valid  bool   // 签名是否有效
+	Signer string // ecrecover 恢复出的地址 签名格式不对时为空
+	Method string // 签名有效时的验证方式
+}
+
+// CheckSignature 验证 address 对 hash 的签名 恢复出的地址不一致时 如果 address 是合约则调用 EIP-1271 的 isValidSignature
+func (w *Worker) CheckSignature(address common.Address, hash []byte, sig []byte) (*SignatureCheck, error) {
+	check := &SignatureCheck{}
+	if signer, err := RecoverAddress(hash, sig); err == nil {
+		check.Signer = signer.Hex()
+		if signer == address {
+			check.Valid, check.Method = true, SignatureEcrecover
+			return check, nil
+		}
+	}
+	code, err := w.http.CodeAt(context.Background(), address, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) == 0 {
+		return check, nil
+	}
+	data, err := eip1271Abi.Pack("isValidSignature", common.BytesToHash(hash), sig)
+	if err != nil {
+		return nil, err
+	}
+	res, err := w.ETHCall(common.Address{}.Hex(), address.Hex(), data, rpc.LatestBlockNumber)
+	if err != nil {
+		// 合约不支持或者回滚都认为签名无效
+		if _, ok := err.(*RevertError); ok {
+			return check, nil
+		}
+		return nil, err
+	}
+	if len(res) >= 4 && bytes.Equal(res[:4], eip1271MagicValue) {
+		check.Valid, check.Method = true, SignatureEIP1271
+	}
+	return check, nil
+}
+
+// VerifySignature 验证 address 对 hash 的签名 支持合约钱包
+func (w *Worker) VerifySignature(address common.Address, hash []byte, sig []byte) (bool, error) {
+	check, err := w.CheckSignature(address, hash, sig)
+	if err != nil {
+		return false, err
+	}
+	return check.Valid, nil
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rs/zerolog/log"
+)
+
+// 回滚原因的类型
+const (
+	RevertKindError   = "error"   // require / revert("reason") 对应 Error(string)
+	RevertKindPanic   = "panic"   // assert 溢出等 对应 Panic(uint256)
+	RevertKindCustom  = "custom"  // 合约 ABI 中定义的自定义错误
+	RevertKindUnknown = "unknown" // 无法解析
+)
+
+var (
+	revertErrorSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+	revertPanicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+)
+
+// panicReasons solidity 内置的 Panic 错误码
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler panic",
+	0x01: "assert failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to zero-initialized function",
+}
+
+// RevertError 模拟执行时合约回滚 广播的话会在链上失败并消耗 gas
+type RevertError struct {
+	Kind      string        `json:"kind"`                // 回滚原因的类型
+	Reason    string        `json:"reason"`              // 解析后的原因
+	Code      string        `json:"code,omitempty"`      // Panic 的错误码
+	Signature string        `json:"signature,omitempty"` // 自定义错误的签名
+	Args      []interface{} `json:"args,omitempty"`      // 自定义错误的参数
+	Data      string        `json:"data,omitempty"`      // 原始的回滚数据
+}
+
+func (e *RevertError) Error() string {
+	return "execution reverted: " + e.Reason
+}
+
+// DecodeRevert 解析回滚数据 abis 为目标合约已知的 ABI 用来解析自定义错误
+func DecodeRevert(data []byte, abis ...*abi.ABI) *RevertError {
+	rev := &RevertError{Kind: RevertKindUnknown, Data: hexutil.Encode(data)}
+	if len(data) < 4 {
+		rev.Reason = "no revert reason"
+		return rev
+	}
+	selector := data[:4]
+	switch {
+	case bytes.Equal(selector, revertErrorSelector):
+		reason, err := abi.UnpackRevert(data)
+		if err == nil {
+			rev.Kind, rev.Reason = RevertKindError, reason
+			return rev
+		}
+	case bytes.Equal(selector, revertPanicSelector):
+		if len(data) == 4+32 {
+			code := new(big.Int).SetBytes(data[4:])
+			rev.Kind, rev.Code = RevertKindPanic, hexutil.EncodeBig(code)
+			rev.Reason = "panic " + rev.Code
+			if code.IsUint64() {
+				if reason, ok := panicReasons[code.Uint64()]; ok {
+					rev.Reason = reason
+				}
+			}
+			return rev
+		}
+	}
+	for _, a := range abis {
+		if a == nil {
+			continue
+		}
+		for _, e := range a.Errors {
+			if !bytes.Equal(selector, e.ID[:4]) {
+				continue
+			}
+			rev.Kind, rev.Signature, rev.Reason = RevertKindCustom, e.Sig, e.Name
+			if args, err := e.Inputs.Unpack(data[4:]); err == nil {
+				rev.Args = args
+				rev.Reason = fmt.Sprintf("%s%v", e.Name, args)
+			}
+			return rev
+		}
+	}
+	rev.Reason = "unknown revert " + hexutil.Encode(selector)
+	return rev
+}
+
+// Simulate 广播前用真实的发送方和金额模拟执行 返回预估的 gas
+// 合约回滚时返回 *RevertError 其他错误 比如余额不足 原样返回 目标合约上传过 ABI 时用来解析自定义错误
+func (w *Worker) Simulate(msg ethereum.CallMsg, abis ...*abi.ABI) (uint64, error) {
+	if _, err := w.http.PendingCallContract(context.Background(), msg); err != nil {
+		log.Info().Msgf("Simulate call err is %s ", err.Error())
+		return 0, callError(err, withRegistered(msg.To, abis))
+	}
+	gas, err := w.http.EstimateGas(context.Background(), msg)
+	if err != nil {
+		log.Info().Msgf("Simulate EstimateGas err is %s ", err.Error())
+		return 0, callError(err, withRegistered(msg.To, abis))
+	}
+	return gas, nil
+}
+
+// callError 从节点返回的错误中取出回滚数据
+func callError(err error, abis []*abi.ABI) error {
+	if de, ok := err.(rpc.DataError); ok {
+		if s, ok := de.ErrorData().(string); ok {
+			if data, decodeErr := hexutil.Decode(s); decodeErr == nil {
+				return DecodeRevert(data, abis...)
+			}
+		}
+	}
+	if strings.HasPrefix(err.Error(), "execution reverted") {
+		// 节点没有返回回滚数据
+		reason := strings.TrimPrefix(strings.TrimPrefix(err.Error(), "execution reverted"), ": ")
+		if reason == "" {
+			reason = "no revert reason"
+		}
+		return &RevertError{Kind: RevertKindUnknown, Reason: reason}
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestDecodeRevert(t *testing.T) {
+	// revert("ERC20: transfer amount exceeds balance")
+	reason := hexutil.MustDecode("0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000026" +
+		"45524332303a207472616e7366657220616d6f756e7420657863656564732062" +
+		"616c616e63650000000000000000000000000000000000000000000000000000")
+	rev := DecodeRevert(reason)
+	if rev.Kind != RevertKindError || rev.Reason != "ERC20: transfer amount exceeds balance" {
+		t.Fatalf("Error(string) got %+v", rev)
+	}
+
+	panicData := append(hexutil.MustDecode("0x4e487b71"), common.LeftPadBytes([]byte{0x11}, 32)...)
+	rev = DecodeRevert(panicData)
+	if rev.Kind != RevertKindPanic || rev.Code != "0x11" || rev.Reason != "arithmetic overflow or underflow" {
+		t.Fatalf("Panic(uint256) got %+v", rev)
+	}
+
+	contractAbi, err := abi.JSON(strings.NewReader(`[{"inputs":[{"name":"available","type":"uint256"},{"name":"required","type":"uint256"}],"name":"InsufficientBalance","type":"error"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := contractAbi.Errors["InsufficientBalance"]
+	args, _ := e.Inputs.Pack(big.NewInt(1), big.NewInt(2))
+	custom := append(append([]byte{}, e.ID[:4]...), args...)
+	rev = DecodeRevert(custom, &contractAbi)
+	if rev.Kind != RevertKindCustom || rev.Signature != "InsufficientBalance(uint256,uint256)" || len(rev.Args) != 2 {
+		t.Fatalf("custom error got %+v", rev)
+	}
+
+	// 没有 ABI 时无法解析
+	rev = DecodeRevert(custom)
+	if rev.Kind != RevertKindUnknown {
+		t.Fatalf("custom error without abi got %+v", rev)
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// siwe 消息的固定内容 https://eips.ethereum.org/EIPS/eip-4361
+const (
+	siweHeaderSuffix  = " wants you to sign in with your Ethereum account:"
+	siweVersion       = "1"
+	siweNonceLength   = 16
+	siweNonceAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+var (
+	ErrSiweFormat   = errors.New("invalid siwe message format")
+	ErrSiweAddress  = errors.New("siwe address must be an EIP-55 checksum address")
+	ErrSiweDomain   = errors.New("siwe domain mismatch")
+	ErrSiweURI      = errors.New("siwe uri does not match domain")
+	ErrSiweVersion  = errors.New("unsupported siwe version")
+	ErrSiweChainID  = errors.New("siwe chain id mismatch")
+	ErrSiweNonce    = errors.New("siwe nonce must be at least 8 alphanumeric characters")
+	ErrSiweExpired  = errors.New("siwe message expired")
+	ErrSiweNotValid = errors.New("siwe message not yet valid")
+)
+
+// SiweMessage EIP-4361 登录消息
+type SiweMessage struct {
+	Scheme         string
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        uint64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// NewSiweNonce 生成登录用的随机 nonce
+func NewSiweNonce() (string, error) {
+	b := make([]byte, siweNonceLength)
+	max := big.NewInt(int64(len(siweNonceAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = siweNonceAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// ParseSiweMessage 按 EIP-4361 的格式解析登录消息
+func ParseSiweMessage(msg string) (*SiweMessage, error) {
+	lines := strings.Split(strings.ReplaceAll(msg, "\r\n", "\n"), "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], siweHeaderSuffix) {
+		return nil, ErrSiweFormat
+	}
+	m := &SiweMessage{Domain: strings.TrimSuffix(lines[0], siweHeaderSuffix)}
+	if i := strings.Index(m.Domain, "://"); i >= 0 {
+		m.Scheme, m.Domain = m.Domain[:i], m.Domain[i+3:]
+	}
+	if m.Domain == "" {
+		return nil, ErrSiweFormat
+	}
+	if !common.IsHexAddress(lines[1]) || common.HexToAddress(lines[1]).Hex() != lines[1] {
+		return nil, ErrSiweAddress
+	}
+	m.Address = common.HexToAddress(lines[1])
+	i := 2
+	// 地址后面是空行 然后是可选的说明和空行
+	if i >= len(lines) || lines[i] != "" {
+		return nil, ErrSiweFormat
+	}
+	i++
+	if i < len(lines) && lines[i] != "" && !strings.HasPrefix(lines[i], "URI: ") {
+		m.Statement = lines[i]
+		i++
+		if i >= len(lines) || lines[i] != "" {
+			return nil, ErrSiweFormat
+		}
+		i++
+	} else if i < len(lines) && lines[i] == "" {
+		i++
+	}
+	field := func(name string, optional bool) (string, bool, error) {
+		prefix := name + ": "
+		if i < len(lines) && strings.HasPrefix(lines[i], prefix) {
+			v := strings.TrimPrefix(lines[i], prefix)
+			i++
+			return v, true, nil
+		}
+		if optional {
+			return "", false, nil
+		}
+		return "", false, ErrSiweFormat
+	}
+	var err error
+	var v string
+	var ok bool
+	if m.URI, _, err = field("URI", false); err != nil {
+		return nil, err
+	}
+	if m.Version, _, err = field("Version", false); err != nil {
+		return nil, err
+	}
+	if v, _, err = field("Chain ID", false); err != nil {
+		return nil, err
+	}
+	if m.ChainID, err = strconv.ParseUint(v, 10, 64); err != nil {
+		return nil, ErrSiweFormat
+	}
+	if m.Nonce, _, err = field("Nonce", false); err != nil {
+		return nil, err
+	}
+	if v, _, err = field("Issued At", false); err != nil {
+		return nil, err
+	}
+	if m.IssuedAt, err = time.Parse(time.RFC3339Nano, v); err != nil {
+		return nil, ErrSiweFormat
+	}
+	if v, ok, _ = field("Expiration Time", true); ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, ErrSiweFormat
+		}
+		m.ExpirationTime = &t
+	}
+	if v, ok, _ = field("Not Before", true); ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, ErrSiweFormat
+		}
+		m.NotBefore = &t
+	}
+	m.RequestID, _, _ = field("Request ID", true)
+	if i < len(lines) && lines[i] == "Resources:" {
+		i++
+		for i < len(lines) && strings.HasPrefix(lines[i], "- ") {
+			m.Resources = append(m.Resources, strings.TrimPrefix(lines[i], "- "))
+			i++
+		}
+	}
+	// 允许末尾有一个换行
+	if i < len(lines) && !(i == len(lines)-1 && lines[i] == "") {
+		return nil, ErrSiweFormat
+	}
+	if !isSiweNonce(m.Nonce) {
+		return nil, ErrSiweNonce
+	}
+	return m, nil
+}
+
+func isSiweNonce(nonce string) bool {
+	if len(nonce) < 8 {
+		return false
+	}
+	for _, r := range nonce {
+		if !strings.ContainsRune(siweNonceAlphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate 检查消息是否发给本服务 以及是否在有效期内
+func (m *SiweMessage) Validate(domain string, chainID uint64, now time.Time) error {
+	if !strings.EqualFold(m.Domain, domain) {
+		return ErrSiweDomain
+	}
+	u, err := url.Parse(m.URI)
+	if err != nil || u.Scheme == "" || !strings.EqualFold(u.Host, m.Domain) {
+		return ErrSiweURI
+	}
+	if m.Version != siweVersion {
+		return ErrSiweVersion
+	}
+	if m.ChainID != chainID {
+		return ErrSiweChainID
+	}
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return ErrSiweExpired
+	}
+	if m.NotBefore != nil && now.Before(*m.NotBefore) {
+		return ErrSiweNotValid
+	}
+	return nil
+}
+
+// VerifySiwe 验证登录消息的签名 支持合约钱包
+func (w *Worker) VerifySiwe(msg string, m *SiweMessage, signature string) (bool, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return false, err
+	}
+	return w.VerifySignature(m.Address, PersonalHash([]byte(msg)), sig)
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const siweExample = `example.com wants you to sign in with your Ethereum account:
+0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2
+
+Sign in to the wallet service.
+
+URI: https://example.com/login
+Version: 1
+Chain ID: 1
+Nonce: 32891756abcdEF
+Issued At: 2021-09-30T16:25:24Z
+Expiration Time: 2021-09-30T17:25:24Z
+Resources:
+- ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/`
+
+func TestParseSiweMessage(t *testing.T) {
+	m, err := ParseSiweMessage(siweExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Domain != "example.com" || m.Statement != "Sign in to the wallet service." || m.ChainID != 1 ||
+		m.Nonce != "32891756abcdEF" || m.ExpirationTime == nil || len(m.Resources) != 1 {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	now := time.Date(2021, 9, 30, 16, 30, 0, 0, time.UTC)
+	if err := m.Validate("example.com", 1, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate("evil.com", 1, now); err != ErrSiweDomain {
+		t.Fatalf("domain got %v", err)
+	}
+	if err := m.Validate("example.com", 5, now); err != ErrSiweChainID {
+		t.Fatalf("chain got %v", err)
+	}
+	if err := m.Validate("example.com", 1, now.Add(time.Hour)); err != ErrSiweExpired {
+		t.Fatalf("expiry got %v", err)
+	}
+	// 没有说明时地址后面两个空行
+	noStatement := strings.Replace(siweExample, "Sign in to the wallet service.\n", "", 1)
+	if m, err := ParseSiweMessage(noStatement); err != nil || m.Statement != "" {
+		t.Fatalf("no statement got %v %v", m, err)
+	}
+	lower := strings.Replace(siweExample, "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2", 1)
+	if _, err := ParseSiweMessage(lower); err != ErrSiweAddress {
+		t.Fatalf("checksum got %v", err)
+	}
+}
+
+func TestRecoverAddress(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	hash := PersonalHash([]byte(siweExample))
+	sig, _ := crypto.Sign(hash, key)
+	sig[64] += 27
+	signer, err := RecoverAddress(hash, sig)
+	if err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("got %s %v", signer.Hex(), err)
+	}
+}
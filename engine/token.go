@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNotToken 地址不是 ERC-20 代币合约
+var ErrNotToken = errors.New("contract is not an erc20 token")
+
+var tokenStringArgs = func() abi.Arguments {
+	t, _ := abi.NewType("string", "", nil)
+	return abi.Arguments{{Type: t}}
+}()
+
+// TokenMeta 从合约读取的代币信息
+type TokenMeta struct {
+	Name        string
+	Symbol      string
+	Decimals    uint8
+	TotalSupply *big.Int
+}
+
+// TokenMetadata 调用合约的 name symbol decimals totalSupply
+// 地址没有代码 或者 decimals totalSupply 回滚 返回值不对时返回 ErrNotToken name symbol 是可选的 读不到为空
+func (w *Worker) TokenMetadata(contractAddress string) (*TokenMeta, error) {
+	if !common.IsHexAddress(contractAddress) {
+		return nil, ErrNotToken
+	}
+	code, err := w.http.CodeAt(context.Background(), common.HexToAddress(contractAddress), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) == 0 {
+		return nil, ErrNotToken
+	}
+	meta := &TokenMeta{}
+	res, err := w.tokenCall(contractAddress, "decimals")
+	if err != nil {
+		return nil, err
+	}
+	decimals, err := decodeTokenUint(res)
+	if err != nil || !decimals.IsUint64() || decimals.Uint64() > 255 {
+		return nil, ErrNotToken
+	}
+	meta.Decimals = uint8(decimals.Uint64())
+	if res, err = w.tokenCall(contractAddress, "totalSupply"); err != nil {
+		return nil, err
+	}
+	if meta.TotalSupply, err = decodeTokenUint(res); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		method string
+		value  *string
+	}{{"name", &meta.Name}, {"symbol", &meta.Symbol}} {
+		res, err := w.tokenCall(contractAddress, f.method)
+		if err == ErrNotToken {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		*f.value = decodeTokenString(res)
+	}
+	return meta, nil
+}
+
+// tokenCall 调用代币的只读方法 合约回滚时返回 ErrNotToken 其他错误原样返回
+func (w *Worker) tokenCall(contractAddress, method string) ([]byte, error) {
+	res, err := w.callContract(contractAddress, method)
+	if err != nil {
+		if _, ok := callError(err, nil).(*RevertError); ok {
+			return nil, ErrNotToken
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// decodeTokenUint 解析 uint256 的返回值 长度不对说明不是代币
+func decodeTokenUint(data []byte) (*big.Int, error) {
+	if len(data) != 32 {
+		return nil, ErrNotToken
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// decodeTokenString 解析 name symbol 的返回值 早期的代币比如 MKR 返回 bytes32
+func decodeTokenString(data []byte) string {
+	if values, err := tokenStringArgs.Unpack(data); err == nil {
+		return values[0].(string)
+	}
+	if len(data) == 32 {
+		return string(bytes.TrimRight(data, "\x00"))
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestDecodeTokenString(t *testing.T) {
+	data, _ := tokenStringArgs.Pack("USD Coin")
+	if got := decodeTokenString(data); got != "USD Coin" {
+		t.Fatalf("string got %q", got)
+	}
+	// MKR 的 symbol 是 bytes32
+	mkr := common.RightPadBytes([]byte("MKR"), 32)
+	if got := decodeTokenString(mkr); got != "MKR" {
+		t.Fatalf("bytes32 got %q", got)
+	}
+	if got := decodeTokenString(hexutil.MustDecode("0x1234")); got != "" {
+		t.Fatalf("invalid got %q", got)
+	}
+}
+
+func TestDecodeTokenUint(t *testing.T) {
+	v, err := decodeTokenUint(common.LeftPadBytes([]byte{18}, 32))
+	if err != nil || v.Int64() != 18 {
+		t.Fatalf("got %v %v", v, err)
+	}
+	// 没有代码的地址调用返回空
+	if _, err := decodeTokenUint(nil); err != ErrNotToken {
+		t.Fatalf("empty got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/lmxdawn/wallet/types"
+)
+
+func TestLegacyTypedDataHash(t *testing.T) {
+	// eth-sig-util typedSignatureHash 的测试数据
+	hash, err := types.LegacyTypedDataHash([]types.LegacyTypedDataField{{Type: "string", Name: "message", Value: "Hi, Alice!"}})
+	if err != nil || hexutil.Encode(hash) != "0x14b9f24872e28cc49e72dc104d7380d8e0ba84a3fe2e712704bcac66a5702bd5" {
+		t.Fatalf("got %s %v", hexutil.Encode(hash), err)
+	}
+}
+
+func TestSignDataV4(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	priv := hexutil.Encode(crypto.FromECDSA(key))[2:]
+	data := types.TypedData{
+		Types: types.Types{
+			"EIP712Domain": {{Name: "name", Type: "string"}, {Name: "chainId", Type: "uint256"}},
+			"Mail":         {{Name: "contents", Type: "string"}},
+		},
+		PrimaryType: "Mail",
+		Domain:      types.TypedDataDomain{Name: "test", ChainId: math.NewHexOrDecimal256(1)},
+		Message:     types.TypedDataMessage{"contents": "hello"},
+	}
+	w := &Worker{}
+	from, sig, err := w.SignDataV4(data, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := hexutil.MustDecode(sig)
+	if b[64] != 27 && b[64] != 28 {
+		t.Fatalf("v should be 27/28 got %d", b[64])
+	}
+	hash, _, _ := types.TypedDataAndHash(data)
+	if signer, err := RecoverAddress(hash, b); err != nil || signer.Hex() != from {
+		t.Fatalf("recovered %s want %s", signer.Hex(), from)
+	}
+	data.PrimaryType = "Order"
+	if _, _, err := w.SignDataV4(data, priv); err == nil {
+		t.Fatal("undefined primary type should fail")
+	}
+}
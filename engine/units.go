@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// NativeDecimals 原生币的精度
+const NativeDecimals = 18
+
+var (
+	ErrAmount          = errors.New("invalid amount")
+	ErrAmountPrecision = errors.New("amount has more decimal places than the token decimals")
+)
+
+// ParseUnits 把展示的数量按精度转成最小单位的整数 比如 0.5 精度 18 为 500000000000000000
+// 只接受非负的十进制数 小数位超过精度会丢失精度 返回 ErrAmountPrecision 末尾多余的 0 不算
+func ParseUnits(s string, decimals uint8) (*big.Int, error) {
+	intPart, frac, hasDot := strings.Cut(strings.TrimSpace(s), ".")
+	if !isDigits(intPart) || (hasDot && !isDigits(frac)) {
+		return nil, ErrAmount
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > int(decimals) {
+		return nil, ErrAmountPrecision
+	}
+	v, _ := new(big.Int).SetString(intPart+frac+strings.Repeat("0", int(decimals)-len(frac)), 10)
+	// 超过 uint256 链上无法表示
+	if v.BitLen() > 256 {
+		return nil, ErrAmount
+	}
+	return v, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatUnits 把最小单位的整数按精度转成展示的数量 去掉小数末尾的 0 比如 1500000000000000000 精度 18 为 1.5
+func FormatUnits(v *big.Int, decimals uint8) string {
+	if v == nil {
+		return ""
+	}
+	s := new(big.Int).Abs(v).String()
+	d := int(decimals)
+	if d == 0 {
+		return v.String()
+	}
+	if len(s) <= d {
+		s = strings.Repeat("0", d-len(s)+1) + s
+	}
+	res := s[:len(s)-d]
+	if frac := strings.TrimRight(s[len(s)-d:], "0"); frac != "" {
+		res += "." + frac
+	}
+	if v.Sign() < 0 {
+		res = "-" + res
+	}
+	return res
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestFormatUnits(t *testing.T) {
+	cases := []struct {
+		v        string
+		decimals uint8
+		want     string
+	}{
+		{"1500000000000000000", 18, "1.5"},
+		{"1000000", 6, "1"},
+		{"1", 18, "0.000000000000000001"},
+		{"0", 18, "0"},
+		{"-2500", 3, "-2.5"},
+		{"123", 0, "123"},
+	}
+	for _, c := range cases {
+		v, _ := new(big.Int).SetString(c.v, 10)
+		if got := FormatUnits(v, c.decimals); got != c.want {
+			t.Fatalf("FormatUnits(%s, %d) got %s want %s", c.v, c.decimals, got, c.want)
+		}
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	cases := []struct {
+		s        string
+		decimals uint8
+		want     string
+	}{
+		{"0.5", 18, "500000000000000000"},
+		{"1", 6, "1000000"},
+		{"1.230000", 2, "123"},
+		{"0.000000000000000001", 18, "1"},
+		{"123456789012345678901234567890", 18, "123456789012345678901234567890000000000000000000"},
+		{"7", 0, "7"},
+	}
+	for _, c := range cases {
+		v, err := ParseUnits(c.s, c.decimals)
+		if err != nil || v.String() != c.want {
+			t.Fatalf("ParseUnits(%s, %d) got %v %v want %s", c.s, c.decimals, v, err, c.want)
+		}
+	}
+	if _, err := ParseUnits("0.0000001", 6); err != ErrAmountPrecision {
+		t.Fatalf("precision got %v", err)
+	}
+	if _, err := ParseUnits("1.5", 0); err != ErrAmountPrecision {
+		t.Fatalf("precision got %v", err)
+	}
+	for _, s := range []string{"", "-1", "1e18", ".5", "5.", "1.2.3", "0x10", "1 000"} {
+		if _, err := ParseUnits(s, 18); err != ErrAmount {
+			t.Fatalf("ParseUnits(%q) got %v", s, err)
+		}
+	}
+	// 超过 uint256
+	if _, err := ParseUnits("1"+strings.Repeat("0", 60), 18); err != ErrAmount {
+		t.Fatalf("overflow got %v", err)
+	}
+}
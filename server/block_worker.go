@@ -3,14 +3,16 @@ package server
 import (
 	"context"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/consensus/misc"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/lmxdawn/wallet/config"
 	"github.com/lmxdawn/wallet/db"
 	"github.com/lmxdawn/wallet/engine"
+	"github.com/lmxdawn/wallet/scheduler"
 	"github.com/lmxdawn/wallet/types"
 	"github.com/rs/zerolog/log"
 	"math/big"
+	"strings"
 	"sync"
 	"time"
 )
@@ -29,128 +31,298 @@ type ListTrans struct {
 
 var TransMap *ListTrans
 
-// listenAllBlock 监听所有区块 不断的监听所有的区块 并将其加入到队列中 等待使用
-func listenAllBlock(initNum uint64) {
-	startNum := initNum
+// transSaved timeToDB 写入数据库的交易哈希 提交者据此判断区块是否已经全部落地
+var transSaved = make(chan string, 1024)
 
-	// 循环 不阻塞 跳出
+// transferEventHash 20 和 721 合约 Transfer 事件的签名
+var transferEventHash = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// blockResult 单个区块中与本服务钱包相关的交易
+type blockResult struct {
+	num      uint64
+	hash     common.Hash
+	parent   common.Hash
+	trans    map[string]*types.Transaction // 交易哈希 -> 交易
+	outgoing map[string]bool               // 交易哈希 -> 是否是本服务钱包转出
+	remain   int                           // 还未取回凭证的交易数量
+	unsaved  int                           // 已经提交还未写入数据库的交易数量 只在提交者中读写
+}
+
+// blockPipeline 区块追赶流水线
+// 多个区块 worker 和凭证 worker 并发拉取数据，由唯一的提交者按区块号顺序提交，
+// 游标只会越过连续处理完成的区块，处理中的区块数量由 slots 限制形成背压
+type blockPipeline struct {
+	conf       config.EngineConfig
+	scheduler  engine.Scheduler
+	signer     ethTypes.Signer
+	blockOut   chan *blockResult
+	receiptOut chan types.Transaction
+	slots      chan struct{}
+}
+
+func newBlockPipeline(conf config.EngineConfig) (*blockPipeline, error) {
+	chainID, err := ListenHttp.NetworkID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &blockPipeline{
+		conf:       conf,
+		scheduler:  scheduler.NewQueueScheduler(),
+		signer:     ethTypes.LatestSignerForChainID(chainID),
+		blockOut:   make(chan *blockResult),
+		receiptOut: make(chan types.Transaction),
+		slots:      make(chan struct{}, conf.BlockWindow),
+	}, nil
+}
+
+// run 启动流水线 从 start 开始处理区块
+func (p *blockPipeline) run(start uint64) {
+	p.scheduler.BlockRun()
+	p.scheduler.ReceiptRun()
+	for i := uint64(0); i < p.conf.BlockCount; i++ {
+		go p.blockWorker()
+	}
+	for i := uint64(0); i < p.conf.ReceiptCount; i++ {
+		go p.receiptWorker()
+	}
+	go p.commit(start)
+	p.dispatch(start)
+}
+
+// dispatch 不断派发满足确认数的区块 处理中的区块达到上限时阻塞
+func (p *blockPipeline) dispatch(next uint64) {
 	for {
-		var toBlock uint64
-		// 从最新的节点开始监听
 		nowNumber, err := ListenHttp.BlockNumber(context.Background())
 		if err != nil {
-			log.Error().Msgf("listenAllBlock init err is %s ", err.Error())
-			return
-		}
-		toBlock = startNum + 100
-		if startNum == 0 {
-			// 从创世区块开始查询
-			toBlock = nowNumber
-		} else if toBlock > nowNumber {
-			// 截至最新的区块
-			toBlock = nowNumber
-		}
-
-		// 开始监听
-		for i := startNum; i < toBlock; i++ {
-			if err := listenBlock(int64(i)); err != nil {
-				log.Info().Msgf("listenAllBlock listenBlock err is %s ", err.Error())
-				// 长事件错误要换链
-				log.Info().Msgf("等待%d秒，当前已是最新区块", 10)
-				<-time.After(time.Duration(10) * time.Second)
+			log.Error().Msgf("blockPipeline dispatch BlockNumber err is %s ", err.Error())
+		}
+		for err == nil && nowNumber >= p.conf.Confirms && next <= nowNumber-p.conf.Confirms {
+			p.slots <- struct{}{}
+			p.scheduler.BlockSubmit(next)
+			next++
+		}
+		<-time.After(time.Duration(p.conf.BlockAfterTime) * time.Second)
+	}
+}
+
+// blockWorker 拉取区块并筛选出本服务钱包的交易 失败则等待后重试同一个区块
+func (p *blockPipeline) blockWorker() {
+	in := p.scheduler.BlockWorkerChan()
+	for {
+		p.scheduler.BlockWorkerReady(in)
+		num := <-in
+		res, err := p.listenBlock(num)
+		for err != nil {
+			log.Info().Msgf("blockPipeline listenBlock %d err is %s ", num, err.Error())
+			<-time.After(time.Duration(p.conf.BlockAfterTime) * time.Second)
+			res, err = p.listenBlock(num)
+		}
+		p.blockOut <- res
+	}
+}
+
+// receiptWorker 获取交易凭证 失败则等待后重试
+func (p *blockPipeline) receiptWorker() {
+	in := p.scheduler.ReceiptWorkerChan()
+	for {
+		p.scheduler.ReceiptWorkerReady(in)
+		ts := <-in
+		hash := common.HexToHash(ts.Hash)
+		receipt, err := ListenHttp.TransactionReceipt(context.Background(), hash)
+		for err != nil {
+			log.Info().Msgf("blockPipeline TransactionReceipt %s err is %s ", ts.Hash, err.Error())
+			<-time.After(time.Duration(p.conf.ReceiptAfterTime) * time.Second)
+			receipt, err = ListenHttp.TransactionReceipt(context.Background(), hash)
+		}
+		ts.Status = uint(receipt.Status)
+		ts.HasCheck = true
+		if ts.Action == db.ActionDeploy {
+			ts.ContractAddress = receipt.ContractAddress.Hex()
+		}
+		ts.LogIndex = transferLogIndex(receipt, ts.To)
+		// 上传过 ABI 的合约 解析调用参数和事件
+		ts.Call = engine.Abis.DecodeCallAt(p.conf.Network, ts.To, ts.Data)
+		ts.Events = engine.Abis.DecodeLogs(p.conf.Network, receipt.Logs)
+		p.receiptOut <- ts
+	}
+}
+
+// transferLogIndex 找出合约 Transfer 事件的日志序号 没有则是原生币转账 返回 0
+func transferLogIndex(receipt *ethTypes.Receipt, contract string) uint {
+	for _, l := range receipt.Logs {
+		if len(l.Topics) > 0 && l.Topics[0] == transferEventHash && l.Address.Hex() == contract {
+			return l.Index
+		}
+	}
+	return 0
+}
+
+// commit 收集区块和凭证结果 按区块号顺序提交并推进游标
+func (p *blockPipeline) commit(next uint64) {
+	pending := make(map[uint64]*blockResult)
+	// 已经提交 但交易还没有全部落地的区块 按区块号顺序
+	var unsaved []*blockResult
+	// 已经提交还未落地的交易哈希 -> 所在的区块
+	waiting := make(map[string]*blockResult)
+	var last common.Hash
+	for {
+		select {
+		case res := <-p.blockOut:
+			pending[res.num] = res
+			res.remain = len(res.trans)
+			for _, ts := range res.trans {
+				p.scheduler.ReceiptSubmit(*ts)
+			}
+		case ts := <-p.receiptOut:
+			res := pending[ts.BlockNumber.Uint64()]
+			temp := res.trans[ts.Hash]
+			temp.Status = ts.Status
+			temp.HasCheck = ts.HasCheck
+			temp.ContractAddress = ts.ContractAddress
+			temp.LogIndex = ts.LogIndex
+			temp.Call = ts.Call
+			temp.Events = ts.Events
+			res.remain--
+		case hash := <-transSaved:
+			if res, ok := waiting[hash]; ok {
+				res.unsaved--
+				delete(waiting, hash)
+			}
+		}
+
+		for {
+			res, ok := pending[next]
+			if !ok || res.remain > 0 {
+				break
+			}
+			// 父区块和上一个提交的区块不一致 说明确认数内发生了回滚
+			if last != (common.Hash{}) && res.parent != last {
+				p.notifyReorg(res, last)
 			}
+			last = res.hash
+			res.unsaved = len(res.trans)
+			for hash := range res.trans {
+				waiting[hash] = res
+			}
+			p.commitBlock(res)
+			unsaved = append(unsaved, res)
+			delete(pending, next)
+			next++
+			<-p.slots
+		}
+		// 游标只越过交易已经全部写入数据库的区块 落地前崩溃的话重启后重新处理
+		saved := uint64(0)
+		for len(unsaved) > 0 && unsaved[0].unsaved == 0 {
+			saved = unsaved[0].num
+			unsaved = unsaved[1:]
+		}
+		if saved > 0 {
+			_ = db.UpDataBlockCursor(p.conf.Network, saved)
 		}
-		startNum = toBlock
 	}
 }
 
-// listenBlock 监听单个区块 并提取其中的交易信息
-func listenBlock(blockNum int64) error {
-	block, err := ListenHttp.BlockByNumber(context.Background(), big.NewInt(blockNum))
+// notifyReorg 通知区块回滚 last 为之前提交的上一个区块哈希
+func (p *blockPipeline) notifyReorg(res *blockResult, last common.Hash) {
+	log.Error().Msgf("blockPipeline reorg at %d parent is %s committed is %s ", res.num, res.parent.Hex(), last.Hex())
+	ev := db.NewNotifyEvent(db.NotifyReorg, res.hash.Hex(), 0)
+	ev.BlockNumber = res.num
+	ev.From = last.Hex()
+	ev.To = res.parent.Hex()
+	_ = db.AddNotifyEvents(ev)
+}
+
+// commitBlock 把区块中已经拿到凭证的交易交给落地流程
+func (p *blockPipeline) commitBlock(res *blockResult) {
+	for hash, ts := range res.trans {
+		TransMap.TransMap.Store(hash, ts)
+		if res.outgoing[hash] {
+			TransMap.From[ts.From] = append(TransMap.From[ts.From], ts)
+		} else {
+			TransMap.To[ts.To] = append(TransMap.To[ts.To], ts)
+		}
+		// 部署成功的合约 按配置交给后台判断 是代币才加入监听
+		if ts.Action == db.ActionDeploy && ts.Status == 1 && p.conf.TrackDeployments {
+			discoverToken(ts.ContractAddress)
+		}
+		// 删除 Pending 中的交易
+		engine.EWorker.RemovePendingByHex(hash)
+		log.Info().Msgf("blockPipeline commit Trans Hash is %s blockNum is %d status is %d", hash, res.num, ts.Status)
+	}
+}
+
+// listenBlock 获取单个区块 并提取其中本服务钱包的交易信息
+func (p *blockPipeline) listenBlock(num uint64) (*blockResult, error) {
+	blockNum := new(big.Int).SetUint64(num)
+	block, err := ListenHttp.BlockByNumber(context.Background(), blockNum)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	res := &blockResult{
+		num:      num,
+		hash:     block.Hash(),
+		parent:   block.ParentHash(),
+		trans:    make(map[string]*types.Transaction),
+		outgoing: make(map[string]bool),
 	}
-	chainID, err := ListenHttp.NetworkID(context.Background())
-	config := params.MainnetChainConfig
-	baseFee := misc.CalcBaseFee(config, block.Header())
 
 	for _, tx := range block.Transactions() {
-		// 如果接收方地址为空，则是创建合约的交易，忽略过去
-		// TODO 还是需要处理
-		if tx.To() == nil {
-			continue
-		}
-		msg, err := tx.AsMessage(ethTypes.LatestSignerForChainID(chainID), baseFee)
+		from, err := ethTypes.Sender(p.signer, tx)
 		if err != nil {
 			continue
 		}
 
 		ts := &types.Transaction{
-			BlockNumber: big.NewInt(blockNum),
+			BlockNumber: blockNum,
 			BlockHash:   block.Hash().Hex(),
 			Hash:        tx.Hash().Hex(),
-			From:        msg.From().Hex(),
-			To:          tx.To().Hex(),
+			From:        from.Hex(),
 			Gas:         tx.Gas(),
 			GasTipCap:   tx.GasTipCap(),
 			GasFeeCap:   tx.GasFeeCap(),
 			Nonce:       tx.Nonce(),
 			Value:       tx.Value(),
-			Data:        msg.Data(),
+			Data:        tx.Data(),
 			Dirty:       false,
 		}
-		// 先判断是否是本钱包用户的交易
-		if db.CheckWalletIsInDB(msg.From().Hex()) {
-			TransMap.TransMap.Store(ts.Hash, ts)
-			TransMap.From[msg.From().Hex()] = append(TransMap.From[msg.From().Hex()], ts)
-			log.Info().Msgf("listenBlock find Trans Hash is %s from %s blockNum is %d", ts.Hash, msg.From().Hex(), blockNum)
-		} else if db.CheckWalletIsInDB(tx.To().Hex()) {
+		// 接收方地址为空是创建合约的交易 只记录本服务钱包发起的 合约地址等拿到凭证后再补上
+		if tx.To() == nil {
+			if db.CheckWalletIsInDB(ts.From) {
+				ts.Action = db.ActionDeploy
+				res.trans[ts.Hash] = ts
+				res.outgoing[ts.Hash] = true
+				log.Info().Msgf("listenBlock find Deploy Hash is %s from %s blockNum is %d", ts.Hash, ts.From, num)
+			}
+			continue
+		}
+		ts.To = tx.To().Hex()
+		// 先判断是否是本钱包用户或提现热钱包的交易
+		if db.CheckWalletIsInDB(ts.From) || isHotWallet(ts.From) {
+			res.trans[ts.Hash] = ts
+			res.outgoing[ts.Hash] = true
+			log.Info().Msgf("listenBlock find Trans Hash is %s from %s blockNum is %d", ts.Hash, ts.From, num)
+		} else if db.CheckWalletIsInDB(ts.To) {
 			// TODO 可能需要解析出真正的 to 地址
-			TransMap.TransMap.Store(ts.Hash, ts)
-			TransMap.To[msg.To().Hex()] = append(TransMap.To[msg.To().Hex()], ts)
-			log.Info().Msgf("listenBlock find Trans Hash is %s to %s blockNum is %d", ts.Hash, msg.To().Hex(), blockNum)
+			res.trans[ts.Hash] = ts
+			log.Info().Msgf("listenBlock find Trans Hash is %s to %s blockNum is %d", ts.Hash, ts.To, num)
 		}
 	}
-	return nil
+	return res, nil
 }
 
-// startGetReceipt 开始获取交易情况
-func startGetReceipt(maxListenLine int) {
-	log.Info().Msgf("startGetReceipt start")
-	// 不断监听交易状态
-	for {
-		TransMap.TransMap.Range(func(key, value interface{}) bool {
-			ts := value.(*types.Transaction)
-			// 这笔交易已经判断了
-			if ts.HasCheck {
-				return true
-			}
-			hash := common.HexToHash(ts.Hash)
-			// 这里获取的 一定是被执行的交易
-			receipt, err := ListenHttp.TransactionReceipt(context.Background(), hash)
-			if err != nil {
-				log.Info().Msgf("startGetReceipt TransactionReceipt err is %s", err.Error())
-				return true
-			}
-			latest, err := ListenHttp.BlockNumber(context.Background())
-			if err != nil {
-				log.Info().Msgf("startGetReceipt BlockNumber err is %s", err.Error())
-				return true
-			}
-			// 判断确认数
-			confirms := latest - receipt.BlockNumber.Uint64() + 1
-			if confirms > 5 {
-				return true
-			}
-			status := receipt.Status
-			ts.Status = uint(status)
-			ts.HasCheck = true
-			// 删除 Pending 中的交易
-			engine.EWorker.RemovePendingByHex(ts.Hash)
-			log.Info().Msgf("startGetReceipt TransactionReceipt %+v", ts)
-			return true
-		})
+// startBlock 计算流水线的起始区块 配置优先 其次是上次处理到的位置 最后是最新区块
+func startBlock(conf config.EngineConfig) uint64 {
+	if conf.BlockInit > 0 {
+		return conf.BlockInit
+	}
+	if cursor, ok := db.GetBlockCursor(conf.Network); ok {
+		return cursor + 1
 	}
+	num, err := ListenHttp.BlockNumber(context.Background())
+	if err != nil {
+		log.Fatal().Msgf("startBlock BlockNumber err is %s ", err.Error())
+	}
+	return num
 }
 
 // timeToDB 定时写入数据库
@@ -167,9 +339,20 @@ func timeToDB() {
 			if !ts.HasCheck {
 				return true
 			}
+			// 批量打款的交易 更新明细状态
+			confirmPayout(ts)
+
+			// 部署合约 没有接收方
+			if ts.Action == db.ActionDeploy {
+				db.UpDateDeployInfo(ts.Hash, ts.From, ts.ContractAddress, ts.Value.String(), int32(ts.Status), ts.Data, blockNumber(ts))
+				saveDecoded(ts)
+				ts.Dirty = true
+				transSaved <- ts.Hash
+				return true
+			}
 
 			// TODO 监听 NFT 的话就要在这里也做处理 做初步区分
-			coin, ok := CoinList.Mapping[ts.To]
+			coin, ok := CoinList.Get(ts.To)
 			// 锻造的话 From 会是 0 地址
 			isFromContract := engine.EWorker.IsContract(ts.From)
 			isToContract := engine.EWorker.IsContract(ts.To)
@@ -177,9 +360,9 @@ func timeToDB() {
 			// 从合约转入
 			if isFromContract {
 				if !ok {
-					db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), "", int32(ts.Status), ts.Data)
+					saveListenTrans(ts, ts.To, ts.Value.String(), "")
 				} else if coin != nil {
-					db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), coin.ContractAddress, int32(ts.Status), ts.Data)
+					saveListenTrans(ts, ts.To, ts.Value.String(), coin.ContractAddress)
 				}
 			}
 
@@ -187,23 +370,23 @@ func timeToDB() {
 			if isToContract {
 				if !ok {
 					if transfer := engine.EWorker.UpPackTransfer(ts.Data); transfer != nil {
-						db.UpDateTransInfo(ts.Hash, ts.From, transfer.To, transfer.Value.String(), "", int32(ts.Status), ts.Data)
+						saveListenTrans(ts, transfer.To, transfer.Value.String(), "")
 					}
 				} else if coin != nil {
 					if coin.IsNFT {
 						if transferFrom := engine.NFT.UnPackTransferFrom(ts.Data); transferFrom != nil {
-							db.UpDateTransInfo(ts.Hash, ts.From, transferFrom.To, ts.Value.String(), coin.ContractAddress, int32(ts.Status), ts.Data)
+							saveListenTrans(ts, transferFrom.To, ts.Value.String(), coin.ContractAddress)
 						} else {
 							// 解析失败 使用传入的 目的地址兜底
-							db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), coin.ContractAddress, int32(ts.Status), ts.Data)
+							saveListenTrans(ts, ts.To, ts.Value.String(), coin.ContractAddress)
 						}
 					} else {
 						// To 会是合约地址 TODO 解析出真正的接受用户地址地址
 						if transfer := engine.EWorker.UpPackTransfer(ts.Data); transfer != nil {
-							db.UpDateTransInfo(ts.Hash, ts.From, transfer.To, transfer.Value.String(), coin.ContractAddress, int32(ts.Status), ts.Data)
+							saveListenTrans(ts, transfer.To, transfer.Value.String(), coin.ContractAddress)
 						} else {
 							// 解析失败 使用传入的 目的地址兜底
-							db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), coin.ContractAddress, int32(ts.Status), ts.Data)
+							saveListenTrans(ts, ts.To, ts.Value.String(), coin.ContractAddress)
 						}
 					}
 				}
@@ -212,13 +395,15 @@ func timeToDB() {
 			// 直接是用户之间的交易
 			if !isToContract && !isFromContract {
 				if !ok {
-					db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), "", int32(ts.Status), ts.Data)
+					saveListenTrans(ts, ts.To, ts.Value.String(), "")
 				} else if coin != nil {
-					db.UpDateTransInfo(ts.Hash, ts.From, ts.To, ts.Value.String(), coin.ContractAddress, int32(ts.Status), ts.Data)
+					saveListenTrans(ts, ts.To, ts.Value.String(), coin.ContractAddress)
 				}
 			}
 
+			saveDecoded(ts)
 			ts.Dirty = true
+			transSaved <- ts.Hash
 			log.Info().Msgf("Success UpDateTransInfo to db %+v time is %s ", ts, time.Now().Format("2006-01-02 15:04:05"))
 			return true
 		})
@@ -226,15 +411,130 @@ func timeToDB() {
 
 }
 
-func Init() {
+// saveListenTrans 落地监听到的交易 本服务钱包相关的事件同时写入通知发件箱
+func saveListenTrans(ts *types.Transaction, to, value, coinName string) {
+	var events []*db.NotifyEvent
+	isFrom := db.CheckWalletIsInDB(ts.From)
+	isTo := db.CheckWalletIsInDB(to)
+	coin, ok := CoinList.Get(coinName)
+	// 提现订单的交易 热钱包不在用户钱包中 按订单通知 执行失败的通知 tx_failed
+	if order := db.GetWithdrawByHash(ts.Hash); order != nil {
+		confirmWithdraw(order, ts)
+		typ := db.NotifyWithdraw
+		if order.State == db.WithdrawFailed {
+			typ = db.NotifyTxFailed
+		}
+		ev := db.NewNotifyEvent(typ, ts.Hash, ts.LogIndex)
+		ev.Address = ts.From
+		ev.OrderId = order.OrderId
+		events = append(events, ev)
+		isFrom = false
+	}
+	// 执行失败的交易没有转移资产 只通知发出方 tx_failed 不发转账类的事件
+	switch {
+	case ts.Status == 0:
+		if isFrom {
+			ev := db.NewNotifyEvent(db.NotifyTxFailed, ts.Hash, ts.LogIndex)
+			ev.Address = ts.From
+			events = append(events, ev)
+		}
+	case ok && coin.IsNFT && (isFrom || isTo):
+		ev := db.NewNotifyEvent(db.NotifyNFTTransfer, ts.Hash, ts.LogIndex)
+		ev.Address = to
+		if isFrom {
+			ev.Address = ts.From
+		}
+		events = append(events, ev)
+	default:
+		if isFrom {
+			ev := db.NewNotifyEvent(db.NotifyWithdraw, ts.Hash, ts.LogIndex)
+			ev.Address = ts.From
+			events = append(events, ev)
+		}
+		// 加油站补的手续费不算充值
+		if isTo && !isGasStation(ts.From) {
+			ev := db.NewNotifyEvent(db.NotifyDeposit, ts.Hash, ts.LogIndex)
+			ev.Address = to
+			events = append(events, ev)
+		}
+	}
+	meta := coinMeta(coinName)
+	if ok && coin.IsNFT {
+		meta = nil
+	}
+	for _, ev := range events {
+		ev.CoinName = coinName
+		ev.From = ts.From
+		ev.To = to
+		ev.Value = value
+		if v, isNum := new(big.Int).SetString(value, 10); isNum && meta != nil {
+			ev.Symbol, ev.Decimals = meta.Symbol, meta.Decimals
+			ev.FormattedValue = engine.FormatUnits(v, meta.Decimals)
+		}
+		ev.Status = int32(ts.Status)
+		ev.BlockNumber = blockNumber(ts)
+	}
+	db.UpDateTransInfo(ts.Hash, ts.From, to, value, coinName, int32(ts.Status), ts.Data, blockNumber(ts), ts.LogIndex, events...)
+}
+
+// saveDecoded 交易记录中加上按 ABI 解析出的调用和事件
+func saveDecoded(ts *types.Transaction) {
+	if ts.Call == nil && len(ts.Events) == 0 {
+		return
+	}
+	db.UpDateTransDecoded(ts.Hash, ts.Call, ts.Events)
+}
+
+// confirmWithdraw 提现交易上链 更新订单状态
+func confirmWithdraw(order *db.Withdraw, ts *types.Transaction) {
+	state := db.WithdrawConfirmed
+	if ts.Status == 0 {
+		state = db.WithdrawFailed
+		order.Error = "transaction execution failed"
+	} else if isCancelTx(ts) {
+		state = db.WithdrawFailed
+		order.Error = "transaction cancelled"
+	}
+	if err := order.Transit(state); err != nil {
+		log.Info().Msgf("confirmWithdraw %s from %s err is %s ", order.OrderId, order.State, err.Error())
+		return
+	}
+	// 加速前的交易先打包时 以打包的交易为准
+	order.Hash = ts.Hash
+	order.BlockNumber = blockNumber(ts)
+	db.UpDataWithdraw(order)
+}
+
+// isCancelTx 取消交易 用原交易的 nonce 给自己转 0 金额
+func isCancelTx(ts *types.Transaction) bool {
+	return strings.EqualFold(ts.From, ts.To) && len(ts.Data) == 0 && (ts.Value == nil || ts.Value.Sign() == 0)
+}
+
+// isHotWallet 是否是提现热钱包
+func isHotWallet(address string) bool {
+	return hotWallet != "" && strings.EqualFold(hotWallet, address)
+}
+
+// blockNumber 交易所在的区块 未上链为 0
+func blockNumber(ts *types.Transaction) uint64 {
+	if ts.BlockNumber == nil {
+		return 0
+	}
+	return ts.BlockNumber.Uint64()
+}
+
+func Init(conf config.EngineConfig) {
 	TransMap = &ListTrans{
 		TransMap: &sync.Map{},
 		From:     map[string][]*types.Transaction{},
 		To:       map[string][]*types.Transaction{},
 	}
-	num, _ := ListenHttp.BlockNumber(context.Background())
+	pipeline, err := newBlockPipeline(conf)
+	if err != nil {
+		log.Fatal().Msgf("newBlockPipeline err is %s ", err.Error())
+		return
+	}
 	// 从制定和块开始监听
-	go listenAllBlock(num)
-	go startGetReceipt(5)
+	go pipeline.run(startBlock(conf))
 	go timeToDB()
 }
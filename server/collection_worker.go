@@ -0,0 +1,259 @@
+package server
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lmxdawn/wallet/config"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/engine"
+	"github.com/rs/zerolog/log"
+)
+
+// errSweepNoGas 原生币不够支付手续费
+var errSweepNoGas = errors.New("insufficient native balance for gas")
+
+// collector 归集 把本服务钱包中达到阈值的余额转到归集地址
+type collector struct {
+	to       string              // 归集地址
+	interval time.Duration       // 定时归集的间隔
+	workers  int                 // 同时归集的地址数量
+	plan     map[string]*big.Int // 币种合约地址 -> 阈值 空字符串表示原生币
+	lock     sync.Mutex          // 同一时间只有一次归集
+}
+
+// sweeper 没有配置归集地址时为 nil
+var sweeper *collector
+
+// startCollection 读取归集配置 配置了阈值时定时归集
+func startCollection(conf config.EngineConfig) {
+	if conf.CollectionAddress == "" {
+		return
+	}
+	if !common.IsHexAddress(conf.CollectionAddress) {
+		log.Fatal().Msgf("collection_address %s is not address ", conf.CollectionAddress)
+	}
+	plan := make(map[string]*big.Int)
+	if conf.CollectionMax != "" {
+		max, ok := new(big.Int).SetString(conf.CollectionMax, 10)
+		if !ok {
+			log.Fatal().Msgf("collection_max %s is not number ", conf.CollectionMax)
+		}
+		if max.Sign() > 0 {
+			plan[""] = max
+		}
+	}
+	for coin, v := range conf.CollectionCoins {
+		max, ok := new(big.Int).SetString(v, 10)
+		if !common.IsHexAddress(coin) || !ok {
+			log.Fatal().Msgf("collection_coins %s: %s err ", coin, v)
+		}
+		plan[coin] = max
+	}
+	workers := int(conf.CollectionCount)
+	if workers <= 0 {
+		workers = 1
+	}
+	sweeper = &collector{
+		to:       common.HexToAddress(conf.CollectionAddress).Hex(),
+		interval: time.Duration(conf.CollectionAfterTime) * time.Second,
+		workers:  workers,
+		plan:     plan,
+	}
+	if len(plan) > 0 && sweeper.interval > 0 {
+		go sweeper.run()
+	}
+}
+
+func (c *collector) run() {
+	log.Info().Msgf("collection start to %s every %s ", c.to, c.interval)
+	for {
+		<-time.After(c.interval)
+		if _, err := c.sweep(db.SweepSchedule, "", c.plan, false); err != nil {
+			log.Info().Msgf("collection sweep err is %s ", err.Error())
+		}
+	}
+}
+
+// sweep 归集一次 address 为空表示所有地址 preview 为 true 时只计算不发送
+func (c *collector) sweep(trigger, address string, plan map[string]*big.Int, preview bool) (*db.Sweep, error) {
+	if !c.lock.TryLock() {
+		return nil, ErrCollectionRunning
+	}
+	defer c.lock.Unlock()
+
+	var usrs []*db.User
+	if address != "" {
+		usr := db.GetUserFromDB(address)
+		if usr == nil {
+			return nil, ErrWalletNotInDB
+		}
+		usrs = append(usrs, usr)
+	} else {
+		usrs = db.GetAllAddress()
+	}
+	s := &db.Sweep{
+		Trigger:   trigger,
+		Preview:   preview,
+		To:        c.to,
+		Tier:      engine.FeeSlow,
+		StartedAt: time.Now().UnixMilli(),
+		Items:     []*db.SweepItem{},
+	}
+	s.Id = strconv.FormatInt(s.StartedAt, 10)
+
+	jobs := make(chan *db.User)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for usr := range jobs {
+				items := c.sweepAddress(usr, plan, preview)
+				lock.Lock()
+				s.Items = append(s.Items, items...)
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, usr := range usrs {
+		jobs <- usr
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(s.Items, func(i, j int) bool {
+		if s.Items[i].Address != s.Items[j].Address {
+			return s.Items[i].Address < s.Items[j].Address
+		}
+		return s.Items[i].CoinName < s.Items[j].CoinName
+	})
+	s.Totals = sweepTotals(s.Items)
+	s.FinishedAt = time.Now().UnixMilli()
+	if !preview {
+		db.AddSweep(s)
+	}
+	log.Info().Msgf("collection %s %s done items %d ", s.Id, trigger, len(s.Items))
+	return s, nil
+}
+
+// sweepAddress 归集一个地址 先归集代币 代币的手续费从原生币中预留 剩余的原生币扣除手续费后归集
+// 余额没有达到阈值的币种不记录
+func (c *collector) sweepAddress(usr *db.User, plan map[string]*big.Int, preview bool) []*db.SweepItem {
+	if strings.EqualFold(usr.Address, c.to) || isHotWallet(usr.Address) || isGasStation(usr.Address) {
+		return nil
+	}
+	items := []*db.SweepItem{}
+	native, err := engine.EWorker.GetBalance(usr.Address, "")
+	if err != nil {
+		return append(items, &db.SweepItem{Address: usr.Address, Error: err.Error()})
+	}
+	coins := make([]string, 0, len(plan))
+	for coin := range plan {
+		if coin != "" {
+			coins = append(coins, coin)
+		}
+	}
+	sort.Strings(coins)
+	for _, coin := range coins {
+		balance, err := engine.EWorker.GetBalance(usr.Address, coin)
+		if err != nil {
+			items = append(items, &db.SweepItem{Address: usr.Address, CoinName: coin, Error: err.Error()})
+			continue
+		}
+		if balance.Sign() == 0 || balance.Cmp(plan[coin]) < 0 {
+			continue
+		}
+		item := &db.SweepItem{Address: usr.Address, CoinName: coin, Balance: balance.String(), Amount: balance.String()}
+		items = append(items, item)
+		gasFee, err := transferGasFee(usr.Address, c.to, balance, coin, engine.FeeSlow)
+		if err != nil {
+			item.Error = err.Error()
+			continue
+		}
+		item.GasFee = gasFee.String()
+		if native.Cmp(gasFee) < 0 {
+			// 配置了加油站时补足差额 预览只记录需要补的数量
+			if station == nil {
+				item.Error = errSweepNoGas.Error()
+				continue
+			}
+			lack := new(big.Int).Sub(gasFee, native)
+			item.TopUp = lack.String()
+			if !preview {
+				if err := station.topUp(usr.Address, coin, lack); err != nil {
+					item.Error = err.Error()
+					continue
+				}
+			}
+			native = new(big.Int).Add(native, lack)
+		}
+		if !preview {
+			_, hash, _, err := engine.EWorker.Transfer(usr.PrivateKey, c.to, balance, 0, coin, engine.FeeSlow)
+			if err != nil {
+				item.Error = err.Error()
+				continue
+			}
+			item.Hash = hash
+		}
+		native = new(big.Int).Sub(native, gasFee)
+	}
+
+	max, ok := plan[""]
+	if !ok || native.Sign() == 0 || native.Cmp(max) < 0 {
+		return items
+	}
+	item := &db.SweepItem{Address: usr.Address, Balance: native.String()}
+	items = append(items, item)
+	gasFee, err := transferGasFee(usr.Address, c.to, native, "", engine.FeeSlow)
+	if err != nil {
+		item.Error = err.Error()
+		return items
+	}
+	amount := engine.SweepAmount(native, gasFee)
+	item.Amount, item.GasFee = amount.String(), gasFee.String()
+	if amount.Sign() == 0 {
+		item.Error = errSweepNoGas.Error()
+		return items
+	}
+	if !preview {
+		// 发送时的费用和这里一致 费用缓存刚好过期导致余额不足的 下次归集重试
+		_, hash, _, err := engine.EWorker.Transfer(usr.PrivateKey, c.to, amount, 0, "", engine.FeeSlow)
+		if err != nil {
+			item.Error = err.Error()
+			return items
+		}
+		item.Hash = hash
+	}
+	return items
+}
+
+// sweepTotals 每个币种成功归集（预览时为可以归集）的总额
+func sweepTotals(items []*db.SweepItem) []*db.SweepTotal {
+	totals := []*db.SweepTotal{}
+	sums := make(map[string]*big.Int)
+	counts := make(map[string]int)
+	for _, item := range items {
+		amount, ok := new(big.Int).SetString(item.Amount, 10)
+		if item.Error != "" || !ok {
+			continue
+		}
+		if _, ok := sums[item.CoinName]; !ok {
+			sums[item.CoinName] = big.NewInt(0)
+		}
+		sums[item.CoinName].Add(sums[item.CoinName], amount)
+		counts[item.CoinName]++
+	}
+	for coin, sum := range sums {
+		totals = append(totals, &db.SweepTotal{CoinName: coin, Amount: sum.String(), Count: counts[coin]})
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].CoinName < totals[j].CoinName })
+	return totals
+}
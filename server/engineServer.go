@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/lmxdawn/wallet/db"
 	"github.com/lmxdawn/wallet/engine"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -18,12 +20,44 @@ type Coin struct {
 	CoinName        string // 根据链上的不同 默认为 ETH 或者 MATIC
 	ContractAddress string // 合约地址 为空表示主币
 	IsNFT           bool   // 是否是 NFT
+	Symbol          string // 代币符号
+	Decimals        uint8  // 代币精度
 }
 
-// ListenCoinList 所有需要监听的代币列表
+// ListenCoinList 所有需要监听的代币列表 区块监听 代币发现和接口会同时读写 通过方法加锁访问
 type ListenCoinList struct {
-	List    []*Coin          // 遍历列表
-	Mapping map[string]*Coin // map 直接查询
+	lock    sync.RWMutex
+	list    []*Coin          // 遍历列表
+	mapping map[string]*Coin // map 直接查询
+}
+
+// Get 按合约地址查询 主币的合约地址为空
+func (l *ListenCoinList) Get(contractAddress string) (*Coin, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	coin, ok := l.mapping[contractAddress]
+	return coin, ok
+}
+
+// List 当前所有代币的副本 遍历时不持有锁
+func (l *ListenCoinList) List() []*Coin {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	list := make([]*Coin, len(l.list))
+	copy(list, l.list)
+	return list
+}
+
+// add 合约地址不存在时加入 已存在返回 false
+func (l *ListenCoinList) add(coin *Coin) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.mapping[coin.ContractAddress]; ok {
+		return false
+	}
+	l.list = append(l.list, coin)
+	l.mapping[coin.ContractAddress] = coin
+	return true
 }
 
 var CoinList *ListenCoinList
@@ -33,13 +67,13 @@ var ListenHttp *ethclient.Client
 
 func CoinInit(url string) {
 	CoinList = &ListenCoinList{
-		Mapping: make(map[string]*Coin),
+		mapping: make(map[string]*Coin),
 	}
 	// 读取数据库 把所有需要监听的 打入内存中
 	tokens := db.GetAll20TokenFromDB()
 	for _, v := range tokens {
 		temp := v
-		if !AddCoin(temp.CoinName, temp.ContractAddress, true, temp.IsNFT) {
+		if !AddCoin(temp, true) {
 			log.Fatal().Msgf("init AddCoin err")
 		}
 	}
@@ -56,22 +90,22 @@ func CoinInit(url string) {
 }
 
 // AddCoin 添加代币 进行过滤监听
-func AddCoin(coinName, contractAddress string, isInit, isNFT bool) bool {
+func AddCoin(ct *db.CoinType, isInit bool) bool {
 	if CoinList == nil {
 		log.Fatal().Msgf("AddCoin CoinList is nil ")
 		return false
 	}
-	if _, ok := CoinList.Mapping[contractAddress]; ok {
+	coin := &Coin{
+		CoinName:        ct.CoinName,
+		ContractAddress: ct.ContractAddress,
+		IsNFT:           ct.IsNFT,
+		Symbol:          ct.Symbol,
+		Decimals:        ct.Decimals,
+	}
+	if !CoinList.add(coin) {
 		log.Info().Msgf("AddCoin contractAddress is exist ")
 		return false
 	}
-	coin := &Coin{
-		CoinName:        coinName,
-		ContractAddress: contractAddress,
-		IsNFT:           isNFT,
-	}
-	CoinList.List = append(CoinList.List, coin)
-	CoinList.Mapping[contractAddress] = coin
 
 	// 初始化的时候集中处理
 	if isInit {
@@ -178,6 +212,7 @@ func timerUpDataBalance(dur time.Duration) {
 				return
 			}
 			nUsrs := []*db.User{}
+			metas := map[string]*db.TokenMeta{}
 			for _, v := range usrs {
 				temp := v
 				newAsset := []*db.CoinAssets{}
@@ -193,6 +228,19 @@ func timerUpDataBalance(dur time.Duration) {
 						continue
 					}
 					t.Num = balance
+					// 之前添加的代币没有记录精度
+					meta, ok := metas[t.ContractAddress]
+					if !ok {
+						meta = coinMeta(t.ContractAddress)
+						metas[t.ContractAddress] = meta
+					}
+					if meta != nil {
+						t.Decimals = meta.Decimals
+						t.Balance = engine.FormatUnits(balance, meta.Decimals)
+						if meta.Symbol != "" {
+							t.Symbol = meta.Symbol
+						}
+					}
 					newAsset = append(newAsset, t)
 				}
 
@@ -235,6 +283,93 @@ func GetTransFromLink(address string) []byte {
 	return body
 }
 
+// tokenDiscovery 部署合约的交易上链后 合约地址交给后台判断是否是代币 不阻塞区块落地
+var tokenDiscovery = make(chan string, 100)
+
+// startTokenDiscovery 后台注册新部署的代币 先给启动时加载的代币补上元数据 依赖 Worker
+func startTokenDiscovery() {
+	log.Info().Msgf("startTokenDiscovery start")
+	go func() {
+		backfillTokenMeta()
+		for contractAddress := range tokenDiscovery {
+			if _, ok := CoinList.Get(contractAddress); ok {
+				continue
+			}
+			_, _ = registerToken(contractAddress)
+		}
+	}()
+}
+
+// discoverToken 部署的合约加入后台队列 队列满时丢弃 可以通过 /addCoin 手动添加
+func discoverToken(contractAddress string) {
+	select {
+	case tokenDiscovery <- contractAddress:
+	default:
+		log.Info().Msgf("discoverToken queue is full drop %s ", contractAddress)
+	}
+}
+
+// backfillTokenMeta 之前添加的代币没有元数据时从合约读取 读取失败的下次启动再补
+func backfillTokenMeta() {
+	for _, coin := range CoinList.List() {
+		if coin.ContractAddress == "" || coin.IsNFT || coinMeta(coin.ContractAddress) != nil {
+			continue
+		}
+		if _, err := saveTokenMeta(coin.ContractAddress); err != nil {
+			continue
+		}
+		log.Info().Msgf("backfillTokenMeta %s ", coin.ContractAddress)
+	}
+}
+
+// saveTokenMeta 从合约读取代币的名称 符号 精度 总量 按网络保存 不是代币返回 engine.ErrNotToken
+func saveTokenMeta(contractAddress string) (*db.TokenMeta, error) {
+	info, err := engine.EWorker.TokenMetadata(contractAddress)
+	if err != nil {
+		log.Info().Msgf("saveTokenMeta %s err is %s ", contractAddress, err.Error())
+		return nil, err
+	}
+	meta := &db.TokenMeta{
+		Network:         networkName,
+		ContractAddress: common.HexToAddress(contractAddress).Hex(),
+		Name:            info.Name,
+		Symbol:          info.Symbol,
+		Decimals:        info.Decimals,
+		TotalSupply:     info.TotalSupply.String(),
+		UpdatedAt:       time.Now().UnixMilli(),
+	}
+	if err := db.SetTokenMeta(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// registerToken 读取并保存代币的元数据后加入全局监听 不是代币返回 engine.ErrNotToken
+func registerToken(contractAddress string) (*db.TokenMeta, error) {
+	meta, err := saveTokenMeta(contractAddress)
+	if err != nil {
+		return nil, err
+	}
+	ct := &db.CoinType{
+		ContractAddress: contractAddress,
+		CoinName:        meta.Symbol,
+		Symbol:          meta.Symbol,
+		Decimals:        meta.Decimals,
+	}
+	// 用户部分要更新 但是不再增加监听 先添加监听 再加用户数据
+	db.UpDataCoinInfoToDB(ct)
+	AddCoin(ct, false)
+	return meta, nil
+}
+
+// coinMeta 币种的元数据 原生币精度为 18 没有记录的代币返回 nil
+func coinMeta(coinName string) *db.TokenMeta {
+	if coinName == "" {
+		return &db.TokenMeta{Network: networkName, Decimals: engine.NativeDecimals}
+	}
+	return db.GetTokenMeta(networkName, common.HexToAddress(coinName).Hex())
+}
+
 func takeCoinListen(coin *Coin) {
 	// 是否需要拉去这个代币是记录
 }
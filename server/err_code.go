@@ -25,6 +25,28 @@ var (
 	ErrNoCoin             = &Errno{Code: 10018, Message: "代币不存在"}
 	ErrNoAccount          = &Errno{Code: 10019, Message: "账户不存在"}
 	ErrNotOwnNft          = &Errno{Code: 10020, Message: "没有拥有该NFT"}
+	ErrNoSubscription     = &Errno{Code: 10021, Message: "订阅不存在"}
+	ErrWebhookDeliver     = &Errno{Code: 10022, Message: "回调投递失败"}
+	ErrTxReverted         = &Errno{Code: 10023, Message: "交易模拟执行失败"}
+	ErrNoWithdrawKey      = &Errno{Code: 10024, Message: "未配置提现私钥"}
+	ErrNoDisperse         = &Errno{Code: 10025, Message: "未配置批量打款合约"}
+	ErrNoCollection       = &Errno{Code: 10026, Message: "未配置归集地址"}
+	ErrCollectionRunning  = &Errno{Code: 10027, Message: "归集正在进行中"}
+	ErrNoGasStation       = &Errno{Code: 10028, Message: "未配置加油钱包"}
+	ErrSiweMessage        = &Errno{Code: 10029, Message: "登录消息有误"}
+	ErrSiweNonce          = &Errno{Code: 10030, Message: "登录nonce无效或已使用"}
+	ErrSignature          = &Errno{Code: 10031, Message: "签名验证失败"}
+	ErrAddressBound       = &Errno{Code: 10032, Message: "地址已绑定其他账户"}
+	ErrTypedData          = &Errno{Code: 10033, Message: "类型数据有误"}
+	ErrChainIdMismatch    = &Errno{Code: 10034, Message: "chainId与钱包当前网络不一致"}
+	ErrNoAbi              = &Errno{Code: 10035, Message: "合约未上传ABI"}
+	ErrAbi                = &Errno{Code: 10036, Message: "合约ABI有误"}
+	ErrNotToken           = &Errno{Code: 10037, Message: "合约不是ERC20代币"}
+	ErrAmount             = &Errno{Code: 10038, Message: "数量有误"}
+	ErrAmountPrecision    = &Errno{Code: 10039, Message: "数量的小数位超过代币精度"}
+	ErrWithdrawConflict   = &Errno{Code: 10040, Message: "订单号已存在且提现参数不一致"}
+	ErrNoSiweDomain       = &Errno{Code: 10041, Message: "未配置以太坊登录域名"}
+	ErrWithdrawBusy       = &Errno{Code: 10042, Message: "提现订单正在处理 请稍后查询"}
 )
 
 // Errno ...
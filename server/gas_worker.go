@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lmxdawn/wallet/config"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/engine"
+	"github.com/rs/zerolog/log"
+)
+
+// gasTopUpTimeout 等待补手续费的交易上链的时间
+const gasTopUpTimeout = 3 * time.Minute
+
+var (
+	errGasBudget = errors.New("gas station daily budget exceeded")
+	errGasTopUp  = errors.New("gas top-up transaction failed")
+)
+
+// gasStation 加油站 只有代币的地址转出代币前 由加油钱包补足需要的原生币手续费
+type gasStation struct {
+	key     string   // 加油钱包的私钥
+	address string   // 加油钱包的地址
+	budget  *big.Int // 每天最多补的原生币总额 nil 表示不限制
+	lock    sync.Mutex
+}
+
+// station 没有配置加油钱包时为 nil
+var station *gasStation
+
+// startGasStation 读取加油站配置
+func startGasStation(conf config.EngineConfig) {
+	if conf.GasPrivateKey == "" {
+		return
+	}
+	address, err := engine.EWorker.GetAddressByPrivateKey(conf.GasPrivateKey)
+	if err != nil {
+		log.Fatal().Msgf("gas_private_key err is %s ", err.Error())
+	}
+	station = &gasStation{key: conf.GasPrivateKey, address: address}
+	// 加油钱包发出的交易卡住时也自动加速
+	_ = engine.EWorker.AddServiceKey(conf.GasPrivateKey)
+	if conf.GasDailyBudget != "" {
+		budget, ok := new(big.Int).SetString(conf.GasDailyBudget, 10)
+		if !ok {
+			log.Fatal().Msgf("gas_daily_budget %s is not number ", conf.GasDailyBudget)
+		}
+		if budget.Sign() > 0 {
+			station.budget = budget
+		}
+	}
+}
+
+// fundGas 地址转出代币前检查原生币够不够手续费 不够时由加油站补足差额并等待上链
+// 没有配置加油站或者转出的是原生币时不处理
+func fundGas(from, to string, value *big.Int, coinName, tier string) error {
+	if station == nil || coinName == "" {
+		return nil
+	}
+	need, err := transferGasFee(from, to, value, coinName, tier)
+	if err != nil {
+		return err
+	}
+	balance, err := engine.EWorker.GetBalance(from, "")
+	if err != nil {
+		return err
+	}
+	if balance.Cmp(need) >= 0 {
+		return nil
+	}
+	return station.topUp(from, coinName, new(big.Int).Sub(need, balance))
+}
+
+// topUp 给地址补 value 的原生币 预算按广播时记录 等待交易上链后返回
+func (g *gasStation) topUp(address, coinName string, value *big.Int) error {
+	g.lock.Lock()
+	day := db.GasDay(time.Now())
+	if g.budget != nil {
+		spent := db.GasSpentTotal(db.GetGasSpends(day))
+		if spent.Add(spent, value).Cmp(g.budget) > 0 {
+			g.lock.Unlock()
+			log.Info().Msgf("gas station top-up %s for %s over budget ", address, coinName)
+			return errGasBudget
+		}
+	}
+	_, hash, _, err := engine.EWorker.Transfer(g.key, address, value, 0, "", engine.FeeNormal)
+	if err != nil {
+		g.lock.Unlock()
+		log.Error().Msgf("gas station top-up %s err is %s ", address, err.Error())
+		return err
+	}
+	db.AddGasSpend(day, coinName, value)
+	g.lock.Unlock()
+
+	log.Info().Msgf("gas station top-up %s value %s for %s hash %s ", address, value, coinName, hash)
+	status, err := engine.EWorker.WaitReceipt(common.HexToHash(hash), gasTopUpTimeout)
+	if err != nil {
+		return err
+	}
+	if status != 1 {
+		return errGasTopUp
+	}
+	return nil
+}
+
+// isGasStation 是否是加油钱包 加油钱包补的手续费不算充值
+func isGasStation(address string) bool {
+	return station != nil && strings.EqualFold(station.address, address)
+}
+
+// transferGasFee 按费用档位 maxFeePerGas 计算的转账最高手续费
+func transferGasFee(from, to string, value *big.Int, coinName, tier string) (*big.Int, error) {
+	gas, err := engine.EWorker.EstimateTransfer(from, to, value, coinName)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := engine.EWorker.FeeTier(tier)
+	if err != nil {
+		return nil, err
+	}
+	return engine.GasFee(engine.GasLimit(gas), fee), nil
+}
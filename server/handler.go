@@ -3,12 +3,18 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"math/big"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/btcsuite/websocket"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
@@ -178,45 +184,99 @@ func AddNewCoin(c *gin.Context) {
 			return
 		}
 	}
+	// 从合约读取代币信息 不是代币的地址不能添加
+	meta, err := registerToken(newCoin.ContractAddress)
+	if err == engine.ErrNotToken {
+		APIResponse(c, ErrNotToken, nil)
+		return
+	}
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
 	usr.Assets[usr.CurrentNetWork.NetWorkName].Coin = append(usr.Assets[usr.CurrentNetWork.NetWorkName].Coin, &db.CoinAssets{
 		ContractAddress: newCoin.ContractAddress,
-		Symbol:          newCoin.CoinName,
+		Symbol:          meta.Symbol,
+		Decimals:        meta.Decimals,
 	})
 
-	// 用户部分要更新 但是不再增加监听 先添加监听 再加用户数据
-	db.UpDataCoinInfoToDB(newCoin.CoinName, newCoin.ContractAddress, false)
-
 	// 更新用户数据
 	db.UpDataUserInfo(usr)
-	AddCoin(newCoin.CoinName, newCoin.ContractAddress, false, false)
 	APIResponse(c, nil, usr.Assets[usr.CurrentNetWork.NetWorkName].Coin)
 	return
 }
 
-// GetActivity 获取钱包活动信息 交易记录
+// GetActivity 获取钱包活动信息 交易记录 按区块倒序分页
 func GetActivity(c *gin.Context) {
 	var walletActivity GetWalletActivity
-	res := WalletActivityRes{}
+	res := WalletActivityRes{History: []*ActivityRes{}}
 	if err := c.ShouldBindJSON(&walletActivity); err != nil {
-		APIResponse(c, err, nil)
+		HandleValidatorError(c, err)
 		return
 	}
-	//worker := engine.EWorker
-	// 查询历史记录
-	trans := db.GetTransferFromDB(walletActivity.UserAddress)
+	trans, next := db.GetTransferPage(&db.TransferQuery{
+		Address:   walletActivity.UserAddress,
+		CoinName:  walletActivity.CoinName,
+		Direction: walletActivity.Direction,
+		Status:    walletActivity.Status,
+		StartTime: walletActivity.StartTime,
+		EndTime:   walletActivity.EndTime,
+		Cursor:    walletActivity.Cursor,
+		Limit:     walletActivity.Limit,
+	})
+	metas := map[string]*db.TokenMeta{}
 	for _, v := range trans {
-		temp := v
-		res.History = append(res.History, &types.Transaction{
-			Hash: temp.Hex,
-			From: temp.From,
-			To:   temp.To,
-			//Value:           big.NewInt(temp.Value),
-		})
+		meta, ok := metas[v.CoinName]
+		if !ok {
+			meta = coinMeta(v.CoinName)
+			metas[v.CoinName] = meta
+		}
+		res.History = append(res.History, newActivityRes(walletActivity.UserAddress, v, meta))
 	}
-	//res.History = worker.TransHistory[walletActivity.UserAddress]
 	res.UserAddress = walletActivity.UserAddress
+	res.NextCursor = next
 	APIResponse(c, nil, res)
-	return
+}
+
+// newActivityRes 转换成返回的交易记录 address 为查询的钱包地址 meta 为币种的元数据 没有记录时不格式化数量
+func newActivityRes(address string, ts *db.Transfer, meta *db.TokenMeta) *ActivityRes {
+	direction := db.DirectionIn
+	if strings.EqualFold(ts.From, address) {
+		direction = db.DirectionOut
+	}
+	// 被替换的交易不会再上链
+	note := ""
+	if ts.ReplacedBy != "" {
+		note = "replaced by " + ts.ReplacedBy
+		if ts.ReplacedAction == db.ActionCancel {
+			note = "cancelled"
+		}
+	}
+	res := &ActivityRes{
+		Note:            note,
+		Replaces:        ts.Replaces,
+		ReplacedBy:      ts.ReplacedBy,
+		Hash:            ts.Hex,
+		From:            ts.From,
+		To:              ts.To,
+		Value:           ts.Value,
+		CoinName:        ts.CoinName,
+		Direction:       direction,
+		Status:          ts.Status,
+		TimeStamp:       ts.TimeStamp,
+		BlockNumber:     ts.BlockNumber,
+		LogIndex:        ts.LogIndex,
+		Action:          ts.Action,
+		ContractAddress: ts.ContractAddress,
+	}
+	if meta != nil {
+		res.Symbol = meta.Symbol
+		res.Decimals = meta.Decimals
+		if v, ok := new(big.Int).SetString(ts.Value, 10); ok {
+			res.FormattedValue = engine.FormatUnits(v, meta.Decimals)
+		}
+	}
+	return res
 }
 
 // Transaction
@@ -233,15 +293,10 @@ func Transaction(c *gin.Context) {
 		return
 	}
 
-	num, err := strconv.Atoi(sT.Num)
-	if err != nil {
-		APIResponse(c, err, nil)
-		return
-	}
 	// TODO 根据地址 数据库中查询获取到 privateKey
 	usr := db.GetUserFromDB(sT.From)
-	if err != nil {
-		APIResponse(c, err, nil)
+	if usr == nil {
+		APIResponse(c, ErrWalletNotInDB, nil)
 		return
 	}
 	// 检查用户是否存在该种代币
@@ -257,22 +312,48 @@ func Transaction(c *gin.Context) {
 			return
 		}
 	}
+	// 数量是展示单位的十进制数 按币种精度转成最小单位
+	meta, err := amountMeta(sT.CoinName)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	value, err := engine.ParseUnits(sT.Num, meta.Decimals)
+	if err != nil {
+		APIResponse(c, amountError(err), nil)
+		return
+	}
 	// TODO 检查是否是多签 若是则走多签的流程
 	if usr.SingType != db.SingerSign {
-		usr.MulSignMode(sT.To, sT.CoinName, sT.Num)
+		usr.MulSignMode(sT.To, sT.CoinName, value.String())
 	}
 
+	if sT.SignOnly {
+		signTx, err := engine.EWorker.SignTransfer(usr.PrivateKey, sT.To, value, 0, sT.CoinName, sT.Tier)
+		signed := newSignedRes(usr.Address, signTx)
+		if signed != nil {
+			signed.setValue(value, meta)
+		}
+		APISendResponse(c, err, signed)
+		return
+	}
+	// 只有代币的地址 由加油站补足手续费
+	if err := fundGas(usr.Address, sT.To, value, sT.CoinName, sT.Tier); err != nil {
+		APISendResponse(c, err, nil)
+		return
+	}
 	worker := engine.EWorker
 	// 后端签名
 	// 这里 返回的仅是放到了交易池里面等到被执行，并没有实际的被真正的执行 还是处于 pending 状态
-	fromHex, signHex, nonce, err := worker.Transfer(usr.PrivateKey, sT.To, big.NewInt(int64(num)), 0, sT.CoinName)
+	fromHex, signHex, nonce, err := worker.Transfer(usr.PrivateKey, sT.To, value, 0, sT.CoinName, sT.Tier)
 	if err != nil {
-		APIResponse(c, err, nil)
+		APISendResponse(c, err, nil)
 		return
 	}
 	res.FromHex = fromHex
 	res.SignHax = signHex
 	res.Nonce = nonce
+	res.setValue(value, meta)
 	log.Info().Msgf("交易发送执行成功")
 	APIResponse(c, nil, res)
 	return
@@ -285,23 +366,29 @@ func EstimateGas(c *gin.Context) {
 		HandleValidatorError(c, err)
 		return
 	}
-	val := new(big.Int)
-	_, ok := val.SetString(cr.Value[2:], 16)
-	if !ok {
-		log.Error().Msgf("big.Int.SetString is false")
-		//APIResponse(c, , nil)
+	block, err := engine.ParseBlockNumber(cr.Block)
+	if err != nil {
+		APIResponse(c, ErrParam, nil)
 		return
 	}
-	data, err := hexutil.Decode(cr.Data)
+	var val *big.Int
+	if cr.Value != "" {
+		if val, err = hexutil.DecodeBig(cr.Value); err != nil {
+			log.Error().Msgf("hexutil.DecodeBig err: %s", err.Error())
+			APIResponse(c, ErrParam, nil)
+			return
+		}
+	}
+	data, _, err := callData(&cr)
 	if err != nil {
-		log.Error().Msgf("hexutil.Decode err: %s", err.Error())
+		log.Error().Msgf("EstimateGas callData err: %s", err.Error())
 		APIResponse(c, err, nil)
 		return
 	}
-	gaslimit, err := engine.EWorker.EstimateGas(cr.From, cr.To, data, val)
+	gaslimit, err := engine.EWorker.EstimateGas(cr.From, cr.To, data, val, block)
 	if err != nil {
 		log.Error().Msgf("EstimateGas err: %s", err.Error())
-		APIResponse(c, err, nil)
+		APISendResponse(c, err, nil)
 		return
 	}
 	APIResponse(c, nil, gaslimit*2)
@@ -314,13 +401,23 @@ func GetBalance(c *gin.Context) {
 		HandleValidatorError(c, err)
 		return
 	}
+	block, err := engine.ParseBlockNumber(balanceReq.Block)
+	if err != nil {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
 	// 代币是 20 币 直接使用20 协议中的 balanceOf
-	balance, err := engine.EWorker.GetBalance(balanceReq.UserAddress, balanceReq.CoinName)
+	balance, err := engine.EWorker.GetBalanceAt(balanceReq.UserAddress, balanceReq.CoinName, block)
 	if err != nil {
 		APIResponse(c, err, nil)
 		return
 	}
 	res := GetBalanceRes{Balance: balance.String()}
+	if meta := coinMeta(balanceReq.CoinName); meta != nil {
+		res.Symbol = meta.Symbol
+		res.Decimals = meta.Decimals
+		res.Formatted = engine.FormatUnits(balance, meta.Decimals)
+	}
 	APIResponse(c, nil, res)
 	return
 }
@@ -417,8 +514,9 @@ func GetWalletInfo(c *gin.Context) {
 
 	usr := db.GetUserFromDB(address)
 	info.User = usr
-	//trans :=db.GetTransferFromDB(address)
-	info.Trans = append(info.Trans, db.GetTransferFromDB(address)...)
+	// 只返回最近的一页 更多的通过 getActivity 分页获取
+	trans, _ := db.GetTransferPage(&db.TransferQuery{Address: address})
+	info.Trans = append(info.Trans, trans...)
 
 	log.Info().Msgf("GetWalletInfo info is %v ", usr)
 	APIResponse(c, nil, info)
@@ -566,6 +664,7 @@ func Login(c *gin.Context) {
 	if err != nil {
 		log.Info().Msgf("Login bind err is %s ", err.Error())
 		APIResponse(c, err, nil)
+		return
 	}
 	ac := db.GetAccountInfo(lR.Account)
 
@@ -575,7 +674,8 @@ func Login(c *gin.Context) {
 	}
 
 	// TODO 加密
-	if ac.PassWD != lR.PassWD {
+	// 空密码不能登录 没有设置密码的账户也不能用密码登录
+	if lR.PassWD == "" || ac.PassWD == "" || ac.PassWD != lR.PassWD {
 		APIResponse(c, ErrPasswdErr, nil)
 		return
 	}
@@ -624,8 +724,9 @@ func AddNFT(c *gin.Context) {
 		return
 	}
 	// 全局的存一下 仅做交易过滤使用
-	AddCoin("", aT.ContractAddress, false, true)
-	db.UpDataCoinInfoToDB("", aT.ContractAddress, true)
+	ct := &db.CoinType{ContractAddress: aT.ContractAddress, IsNFT: true}
+	AddCoin(ct, false)
+	db.UpDataCoinInfoToDB(ct)
 	APIResponse(c, nil, db.GetUserFromDB(aT.UserAddress))
 }
 
@@ -639,18 +740,30 @@ func NFTTransfer(c *gin.Context) {
 		HandleValidatorError(c, err)
 		return
 	}
-	// 检查用户的账户中是否有这个钱包地址
-	ac := db.GetAccountInfo(account)
 	// 先检查一下是否导入了这个代币
-	if _, ok := CoinList.Mapping[nT.ContractAddress]; !ok {
+	if _, ok := CoinList.Get(nT.ContractAddress); !ok {
 		APIResponse(c, ErrNotOwnNft, nil)
+		return
 	}
-	log.Debug().Msgf("ac.WalletList is %v from account is %s ", ac.WalletList, nT.From)
 	usr := db.GetUserFromDB(nT.From)
-	fromHx, signHx, nonce, err := engine.NFTTransfer(nT.ContractAddress, nT.From, usr.PrivateKey, nT.To, nT.TokenID)
+	if usr == nil {
+		APIResponse(c, ErrWalletNotInDB, nil)
+		return
+	}
+	// 检查用户的账户中是否有这个钱包地址
+	if account == "" || !checkOwnWallets(account, []string{nT.From}) {
+		APIResponse(c, ErrNoPremission, nil)
+		return
+	}
+	if nT.SignOnly {
+		signTx, err := engine.SignNFTTransfer(nT.ContractAddress, nT.From, usr.PrivateKey, nT.To, nT.TokenID, nT.Tier)
+		APISendResponse(c, err, newSignedRes(usr.Address, signTx))
+		return
+	}
+	fromHx, signHx, nonce, err := engine.NFTTransfer(nT.ContractAddress, nT.From, usr.PrivateKey, nT.To, nT.TokenID, nT.Tier)
 	if err != nil {
 		log.Error().Msgf("NFTTransfer err is %s", err.Error())
-		APIResponse(c, err, nil)
+		APISendResponse(c, err, nil)
 		return
 	}
 	// 更新用户的 NFT 状态
@@ -679,9 +792,13 @@ func SpeedUp(c *gin.Context) {
 		return
 	}
 	ac := db.GetUserFromDB(sR.Address)
+	if ac == nil {
+		APIResponse(c, ErrWalletNotInDB, nil)
+		return
+	}
 	sp, s, u, err := engine.EWorker.SpeedUp(ac.PrivateKey, sR.TxHash)
 	if err != nil {
-		log.Info().Msgf("Cancel err is %s ", err.Error())
+		log.Info().Msgf("SpeedUp err is %s ", err.Error())
 		APIResponse(c, err, nil)
 		return
 	}
@@ -700,7 +817,11 @@ func Cancel(c *gin.Context) {
 		return
 	}
 	ac := db.GetUserFromDB(cR.Address)
-	cancel, s, u, err := engine.EWorker.Cancel(ac.PrivateKey, cR.Address, cR.TxHash)
+	if ac == nil {
+		APIResponse(c, ErrWalletNotInDB, nil)
+		return
+	}
+	cancel, s, u, err := engine.EWorker.Cancel(ac.PrivateKey, cR.TxHash)
 	if err != nil {
 		log.Info().Msgf("Cancel err is %s ", err.Error())
 		APIResponse(c, err, nil)
@@ -748,6 +869,7 @@ func PersonalSign(c *gin.Context) {
 	APIResponse(c, nil, res)
 }
 
+// SignTypeDataV4 类型数据签名 支持 v1 v3 v4
 func SignTypeDataV4(c *gin.Context) {
 	var sr SignTypeDataV4Req
 	var res SendTransactionRes
@@ -756,18 +878,20 @@ func SignTypeDataV4(c *gin.Context) {
 		return
 	}
 	log.Info().Msgf("SignTypeDataV4 is %+v ", sr)
-
-	log.Info().Msgf("SignTypeDataV4 offer is %s ", sr.Message["offerer"].(string))
-	usr := db.GetUserFromDB(sr.Message["offerer"].(string))
+	usr := db.GetUserFromDB(common.HexToAddress(sr.From).Hex())
 	if usr == nil {
-		log.Info().Msgf("SignTypeDataV4 get User is nil,address is %s ", sr.Message["offerer"].(string))
+		log.Info().Msgf("SignTypeDataV4 get User is nil,address is %s ", sr.From)
 		APIResponse(c, ErrWalletNotInDB, nil)
 		return
 	}
-	from, s, err := engine.EWorker.SignDataV4(sr.TypedData, usr.PrivateKey)
+	from, s, err := signTypedData(usr, sr.Version, &sr.TypedData, sr.Data)
+	if err == errTypedDataChain {
+		APIResponse(c, ErrChainIdMismatch, nil)
+		return
+	}
 	if err != nil {
 		log.Info().Msgf("SignTypeDataV4 err is %s ", err.Error())
-		APIResponse(c, err, nil)
+		APIResponse(c, &Err{Code: ErrTypedData.Code, Message: ErrTypedData.Message + ": " + err.Error(), Err: err}, nil)
 		return
 	}
 	res.FromHex = from
@@ -775,6 +899,39 @@ func SignTypeDataV4(c *gin.Context) {
 	APIResponse(c, nil, res)
 }
 
+var errTypedDataChain = errors.New("typed data domain chainId does not match the wallet network")
+
+// signTypedData 按版本签名类型数据 v3 v4 的 domain 带 chainId 时必须和钱包当前网络一致
+func signTypedData(usr *db.User, version string, data *types.TypedData, legacy []types.LegacyTypedDataField) (string, string, error) {
+	if version == "v1" {
+		return engine.EWorker.SignDataV1(legacy, usr.PrivateKey)
+	}
+	if err := data.Validate(); err != nil {
+		return "", "", err
+	}
+	if data.Domain.ChainId != nil {
+		chainID, err := walletChainID(usr)
+		if err != nil {
+			return "", "", err
+		}
+		if (*big.Int)(data.Domain.ChainId).Cmp(chainID) != 0 {
+			return "", "", errTypedDataChain
+		}
+	}
+	if version == "v3" {
+		return engine.EWorker.SignDataV3(*data, usr.PrivateKey)
+	}
+	return engine.EWorker.SignDataV4(*data, usr.PrivateKey)
+}
+
+// walletChainID 钱包当前网络的 chainId 没有设置时使用节点的
+func walletChainID(usr *db.User) (*big.Int, error) {
+	if usr.CurrentNetWork != nil && usr.CurrentNetWork.ChainID != 0 {
+		return new(big.Int).SetUint64(uint64(usr.CurrentNetWork.ChainID)), nil
+	}
+	return engine.EWorker.ChainID()
+}
+
 // CallContract 直接调用ABI
 func CallContract(c *gin.Context) {
 	var aR CallContractReq
@@ -791,23 +948,38 @@ func CallContract(c *gin.Context) {
 		return
 	}
 	val := new(big.Int)
-	val.SetString(aR.Value[2:], 16)
-	toTemp := common.HexToAddress(aR.To)
-	dec, err := hexutil.Decode(aR.Data)
+	if aR.Value != "" {
+		var err error
+		if val, err = hexutil.DecodeBig(aR.Value); err != nil {
+			log.Error().Msgf("CallContract hexutil.DecodeBig err is %s ", err.Error())
+			APIResponse(c, ErrParam, nil)
+			return
+		}
+	}
+	dec, _, err := callData(&aR)
 	if err != nil {
-		log.Error().Msgf("CallContract Decode err is %s ", err.Error())
+		log.Error().Msgf("CallContract callData err is %s ", err.Error())
 		APIResponse(c, err, nil)
 		return
 	}
 	tx := &ethTypes.DynamicFeeTx{
-		To:    &toTemp,
 		Value: val,
 		Data:  dec,
 	}
-	contractTrans, s, u, err := engine.EWorker.SendContractTrans(ac.PrivateKey, tx)
+	// 不传 to 则是部署合约
+	if aR.To != "" {
+		toTemp := common.HexToAddress(aR.To)
+		tx.To = &toTemp
+	}
+	if aR.SignOnly {
+		signTx, err := engine.EWorker.SignContractTrans(ac.PrivateKey, tx, aR.Tier)
+		APISendResponse(c, err, newSignedRes(ac.Address, signTx))
+		return
+	}
+	contractTrans, s, u, err := engine.EWorker.SendContractTrans(ac.PrivateKey, tx, aR.Tier)
 	if err != nil {
 		log.Error().Msgf("CallContract SendContractTrans err is %s ", err.Error())
-		APIResponse(c, err, nil)
+		APISendResponse(c, err, nil)
 		return
 	}
 	res.FromHex = contractTrans
@@ -860,19 +1032,32 @@ func GetGasPrice(c *gin.Context) {
 	// }
 
 	// 选择 不同的链
-	suggestPrice, basePrice, err := engine.EWorker.GetGasPrice()
+	est, err := engine.EWorker.GetGasPrice()
 	if err != nil {
 		log.Error().Msgf("GetGasPrice err is %s ", err.Error())
 		HandleValidatorError(c, err)
 		return
 	}
 	// 返回建议费用 和 最低费用
-	res.SuggestPrice = suggestPrice
-	res.BasePrice = basePrice
+	res.SuggestPrice = est.Tier(engine.FeeNormal).MaxFeePerGas.String()
+	res.BasePrice = est.BaseFee.String()
+	res.BlockNumber = est.Block
+	res.BlockTime = est.BlockTime.Seconds()
+	res.Slow = newFeeTierRes(est.Tier(engine.FeeSlow))
+	res.Normal = newFeeTierRes(est.Tier(engine.FeeNormal))
+	res.Fast = newFeeTierRes(est.Tier(engine.FeeFast))
 	APIResponse(c, nil, res)
 
 }
 
+func newFeeTierRes(t *engine.FeeTier) *FeeTierRes {
+	return &FeeTierRes{
+		MaxFeePerGas:         t.MaxFeePerGas.String(),
+		MaxPriorityFeePerGas: t.MaxPriorityFeePerGas.String(),
+		Wait:                 t.Wait.Seconds(),
+	}
+}
+
 // ETHCall  Call 合约
 func ETHCall(c *gin.Context) {
 	var cR CallContractReq
@@ -880,34 +1065,1201 @@ func ETHCall(c *gin.Context) {
 		HandleValidatorError(c, err)
 		return
 	}
-	data, err := hexutil.Decode(cR.Data)
+	block, err := engine.ParseBlockNumber(cR.Block)
 	if err != nil {
-		HandleValidatorError(c, err)
+		APIResponse(c, ErrParam, nil)
 		return
 	}
-	res, err := engine.EWorker.ETHCall(cR.From, cR.To, data)
+	data, a, err := callData(&cR)
 	if err != nil {
 		HandleValidatorError(c, err)
 		return
 	}
-	APIResponse(c, nil, hexutil.Encode(res))
+	res, err := engine.EWorker.ETHCall(cR.From, cR.To, data, block)
+	if err != nil {
+		APISendResponse(c, err, nil)
+		return
+	}
+	if a == nil {
+		APIResponse(c, nil, hexutil.Encode(res))
+		return
+	}
+	outputs, err := engine.DecodeOutputs(a, cR.Method, res)
+	if err != nil {
+		APIResponse(c, &Err{Code: ErrAbi.Code, Message: ErrAbi.Message + ": " + err.Error(), Err: err}, nil)
+		return
+	}
+	APIResponse(c, nil, EthCallRes{Raw: hexutil.Encode(res), Outputs: outputs})
 }
 
+// GetBlockByNumber 获取区块
 func GetBlockByNumber(c *gin.Context) {
 	var bR GetBlockByNumberReq
 	if err := c.ShouldBindJSON(&bR); err != nil {
 		HandleValidatorError(c, err)
 		return
 	}
-	// val := new(big.Int)
-	// res,ok :=val.SetString(bR.Number[2:], 16)
-	// if !ok {
+	number, err := engine.ParseBlockNumber(bR.Number)
+	if err != nil {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	// 节点原始的 JSON 字段和 eth_getBlockByNumber 一致
+	block, err := engine.EWorker.GetBlockByNumber(number, bR.IsFull)
+	if err == ethereum.NotFound {
+		APIResponse(c, ErrNotData, nil)
+		return
+	}
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, block)
+}
 
-	// }
-	block, err := engine.EWorker.GetBlockByNumber(nil, bR.IsFull)
+// GetDeadNotify 获取死信队列中投递失败的通知
+func GetDeadNotify(c *gin.Context) {
+	APIResponse(c, nil, db.GetDeadNotifyEvents())
+}
+
+// ReplayNotify 重新投递通知
+func ReplayNotify(c *gin.Context) {
+	var rR ReplayNotifyReq
+	if err := c.ShouldBindJSON(&rR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	for _, id := range rR.Ids {
+		if err := db.ReplayNotifyEvent(id); err != nil {
+			log.Info().Msgf("ReplayNotify err is %s ", err.Error())
+			APIResponse(c, ErrNotData, id)
+			return
+		}
+	}
+	APIResponse(c, nil, nil)
+}
+
+// newWebhookRes 转换成返回的订阅信息
+func newWebhookRes(sub *db.Subscription, withSecret bool) *WebhookRes {
+	res := &WebhookRes{
+		Id:        sub.Id,
+		Url:       sub.Url,
+		Events:    sub.Events,
+		Wallets:   sub.Wallets,
+		Coins:     sub.Coins,
+		Disabled:  sub.Disabled,
+		CreatedAt: sub.CreatedAt,
+	}
+	if withSecret {
+		res.Secret = sub.Secret
+		res.OldSecretExpire = sub.OldSecretExpire
+	}
+	return res
+}
+
+// getOwnSubscription 获取当前账户的订阅 不属于该账户时返回 nil
+func getOwnSubscription(c *gin.Context, id string) *db.Subscription {
+	sub := db.GetSubscription(id)
+	if sub == nil || sub.Account != c.GetHeader("Account") {
+		return nil
+	}
+	return sub
+}
+
+// checkOwnWallets 检查过滤的钱包是否都属于该账户
+func checkOwnWallets(account string, wallets []string) bool {
+	if len(wallets) == 0 {
+		return true
+	}
+	ac := db.GetAccountInfo(account)
+	if ac == nil {
+		return false
+	}
+	for _, w := range wallets {
+		own := false
+		for _, v := range ac.WalletList {
+			if strings.EqualFold(v, w) {
+				own = true
+				break
+			}
+		}
+		if !own {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateWebhook 新建通知订阅
+func CreateWebhook(c *gin.Context) {
+	account := c.GetHeader("Account")
+	var cR CreateWebhookReq
+	if err := c.ShouldBindJSON(&cR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if !checkOwnWallets(account, cR.Wallets) {
+		APIResponse(c, ErrNoPremission, nil)
+		return
+	}
+	sub := db.NewSubscription(account, cR.Url, cR.Events, cR.Wallets, cR.Coins)
+	if err := db.UpDataSubscription(sub); err != nil {
+		APIResponse(c, InternalServerError, nil)
+		return
+	}
+	APIResponse(c, nil, newWebhookRes(sub, true))
+}
+
+// GetWebhookList 获取账户的通知订阅
+func GetWebhookList(c *gin.Context) {
+	list := []*WebhookRes{}
+	for _, sub := range db.GetAllSubscription(c.GetHeader("Account")) {
+		list = append(list, newWebhookRes(sub, false))
+	}
+	APIResponse(c, nil, list)
+}
+
+// UpdateWebhook 修改通知订阅
+func UpdateWebhook(c *gin.Context) {
+	var uR UpdateWebhookReq
+	if err := c.ShouldBindJSON(&uR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	sub := getOwnSubscription(c, uR.Id)
+	if sub == nil {
+		APIResponse(c, ErrNoSubscription, nil)
+		return
+	}
+	if !checkOwnWallets(sub.Account, uR.Wallets) {
+		APIResponse(c, ErrNoPremission, nil)
+		return
+	}
+	if uR.Url != "" {
+		sub.Url = uR.Url
+	}
+	if uR.Events != nil {
+		sub.Events = uR.Events
+	}
+	if uR.Wallets != nil {
+		sub.Wallets = uR.Wallets
+	}
+	if uR.Coins != nil {
+		sub.Coins = uR.Coins
+	}
+	if uR.Disabled != nil {
+		sub.Disabled = *uR.Disabled
+	}
+	if err := db.UpDataSubscription(sub); err != nil {
+		APIResponse(c, InternalServerError, nil)
+		return
+	}
+	APIResponse(c, nil, newWebhookRes(sub, false))
+}
+
+// DelWebhook 删除通知订阅 未投递的事件不再投递
+func DelWebhook(c *gin.Context) {
+	var dR WebhookIdReq
+	if err := c.ShouldBindJSON(&dR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if getOwnSubscription(c, dR.Id) == nil {
+		APIResponse(c, ErrNoSubscription, nil)
+		return
+	}
+	if err := db.DelSubscription(dR.Id); err != nil {
+		APIResponse(c, InternalServerError, nil)
+		return
+	}
+	APIResponse(c, nil, nil)
+}
+
+// RotateWebhookSecret 轮换签名密钥 旧密钥在宽限期内同时签名
+func RotateWebhookSecret(c *gin.Context) {
+	var rR WebhookIdReq
+	if err := c.ShouldBindJSON(&rR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	sub := getOwnSubscription(c, rR.Id)
+	if sub == nil {
+		APIResponse(c, ErrNoSubscription, nil)
+		return
+	}
+	sub.RotateSecret()
+	if err := db.UpDataSubscription(sub); err != nil {
+		APIResponse(c, InternalServerError, nil)
+		return
+	}
+	APIResponse(c, nil, newWebhookRes(sub, true))
+}
+
+// GetWebhookDeliveries 获取订阅最近的投递记录
+func GetWebhookDeliveries(c *gin.Context) {
+	var gR WebhookIdReq
+	if err := c.ShouldBindQuery(&gR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if getOwnSubscription(c, gR.Id) == nil {
+		APIResponse(c, ErrNoSubscription, nil)
+		return
+	}
+	APIResponse(c, nil, db.GetWebhookDeliveries(gR.Id))
+}
+
+// TestWebhook 立即投递一个测试事件 结果同样记录到投递记录中
+func TestWebhook(c *gin.Context) {
+	var tR WebhookIdReq
+	if err := c.ShouldBindJSON(&tR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	sub := getOwnSubscription(c, tR.Id)
+	if sub == nil {
+		APIResponse(c, ErrNoSubscription, nil)
+		return
+	}
+	ev := db.NewNotifyEvent(db.NotifyTest, "", 0)
+	ev.Id = db.NotifyTest + ":" + strconv.FormatInt(ev.CreatedAt, 10)
+	code, err := notifier.deliverTo(sub, ev, 1)
+	if err != nil {
+		log.Info().Msgf("TestWebhook err is %s ", err.Error())
+		APIResponse(c, ErrWebhookDeliver, &db.WebhookDelivery{EventId: ev.Id, StatusCode: code, Error: err.Error()})
+		return
+	}
+	APIResponse(c, nil, &db.WebhookDelivery{EventId: ev.Id, Type: ev.Type, Attempt: 1, StatusCode: code})
+}
+
+// GetNonce 查询地址的 nonce 分配状态和空洞
+func GetNonce(c *gin.Context) {
+	var nR NonceReq
+	if err := c.ShouldBindQuery(&nR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	gaps, state, err := engine.Nonces.Gaps(common.HexToAddress(nR.Address))
 	if err != nil {
+		log.Info().Msgf("GetNonce err is %s ", err.Error())
+		APIResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, &NonceRes{Address: nR.Address, NonceState: state, Gaps: gaps})
+}
+
+// RepairNonce 用 0 金额的自转账填补地址的 nonce 空洞
+func RepairNonce(c *gin.Context) {
+	var nR NonceReq
+	if err := c.ShouldBindJSON(&nR); err != nil {
 		HandleValidatorError(c, err)
 		return
 	}
-	APIResponse(c, nil, block)
+	usr := db.GetUserFromDB(nR.Address)
+	if usr == nil {
+		APIResponse(c, ErrWalletNotInDB, nil)
+		return
+	}
+	hashes, err := engine.Nonces.Repair(usr.PrivateKey)
+	if err != nil {
+		log.Info().Msgf("RepairNonce err is %s ", err.Error())
+		APIResponse(c, err, hashes)
+		return
+	}
+	APIResponse(c, nil, hashes)
+}
+
+// GetPendingTransactions 本服务广播还没有打包的交易 以及卡住的情况
+func GetPendingTransactions(c *gin.Context) {
+	var gR GetPendingReq
+	if err := c.ShouldBindQuery(&gR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	ac := db.GetAccountInfo(c.GetHeader("Account"))
+	if ac == nil {
+		APIResponse(c, ErrNoPremission, nil)
+		return
+	}
+	wallets := ac.WalletList
+	if gR.Address != "" {
+		if !checkOwnWallets(ac.Account, []string{gR.Address}) {
+			APIResponse(c, ErrNoPremission, nil)
+			return
+		}
+		wallets = []string{gR.Address}
+	}
+	est, err := engine.EWorker.GetGasPrice()
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	now := time.Now()
+	list := []*PendingTxRes{}
+	for _, w := range wallets {
+		for _, ts := range engine.EWorker.PendingList(w) {
+			baseFee, gasTip := est.BaseFee, engine.StuckTip(est, ts)
+			res := &PendingTxRes{
+				Hash:    ts.Hash,
+				From:    ts.From,
+				To:      ts.To,
+				Nonce:   ts.Nonce,
+				Value:   bigString(ts.Value),
+				Action:  ts.Action,
+				BaseFee: baseFee.String(),
+				FeeGap:  engine.FeeGap(ts, baseFee, gasTip).String(),
+				Bumps:   ts.Bumps,
+				Stuck:   stuckPolicy.IsStuck(ts, baseFee, gasTip, now),
+				Actions: []string{"speedUp"},
+			}
+			if ts.SentAt > 0 {
+				res.Age = int64(now.Sub(time.UnixMilli(ts.SentAt)).Seconds())
+			}
+			res.GasFeeCap = bigString(ts.GasFeeCap)
+			res.GasTipCap = bigString(ts.GasTipCap)
+			if ts.Action != db.ActionCancel {
+				res.Actions = append(res.Actions, "cancel")
+			}
+			list = append(list, res)
+		}
+	}
+	APIResponse(c, nil, list)
+}
+
+// bigString 大数转字符串 nil 为 0
+func bigString(n *big.Int) string {
+	if n == nil {
+		return "0"
+	}
+	return n.String()
+}
+
+// Withdraw 提现 使用配置的热钱包签名 相同订单号重复请求返回已有订单 不会重复发送
+func Withdraw(c *gin.Context) {
+	var wR WithdrawReq
+	if err := c.ShouldBindJSON(&wR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if withdrawKey == "" {
+		APIResponse(c, ErrNoWithdrawKey, nil)
+		return
+	}
+	if !common.IsHexAddress(wR.Address) || (wR.CoinName != "" && !common.IsHexAddress(wR.CoinName)) {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	meta, err := amountMeta(wR.CoinName)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	value, err := engine.ParseUnits(wR.Value, meta.Decimals)
+	if err != nil {
+		APIResponse(c, amountError(err), nil)
+		return
+	}
+	if value.Sign() <= 0 {
+		APIResponse(c, ErrAmount, nil)
+		return
+	}
+	req := &db.Withdraw{
+		OrderId:  wR.OrderId,
+		Protocol: wR.Protocol,
+		CoinName: wR.CoinName,
+		Address:  wR.Address,
+		Value:    value.String(),
+		Tier:     wR.Tier,
+	}
+	// 同一个订单号同时只处理一个请求 重复的请求直接返回已保存的订单 不会重复广播
+	if _, loaded := withdrawRunning.LoadOrStore(req.OrderId, true); loaded {
+		order := db.GetWithdraw(req.OrderId)
+		if order == nil {
+			APIResponse(c, ErrWithdrawBusy, nil)
+			return
+		}
+		if !order.SameRequest(req) {
+			APIResponse(c, ErrWithdrawConflict, nil)
+			return
+		}
+		APIResponse(c, nil, newWithdrawRes(order))
+		return
+	}
+	defer withdrawRunning.Delete(req.OrderId)
+	order, created, err := db.CreateWithdraw(req)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	if !created {
+		// 同一个订单号参数不一致 不能当作重复请求
+		if !order.SameRequest(req) {
+			APIResponse(c, ErrWithdrawConflict, nil)
+			return
+		}
+		// 签名后没有广播成功就中断的订单 重新广播同一笔交易
+		if order.State == db.WithdrawSigned {
+			resumeWithdraw(order)
+		}
+		APIResponse(c, nil, newWithdrawRes(order))
+		return
+	}
+
+	if err = sendWithdraw(order); err != nil {
+		APISendResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, newWithdrawRes(order))
+}
+
+// withdrawRunning 正在处理的提现订单号 同一个订单同时只有一个请求在签名广播
+var withdrawRunning sync.Map
+
+// sendWithdraw 签名并广播刚创建的订单 签名的交易先落地再广播
+func sendWithdraw(order *db.Withdraw) error {
+	value, _ := new(big.Int).SetString(order.Value, 10)
+	signTx, err := engine.EWorker.SignTransfer(withdrawKey, order.Address, value, 0, order.CoinName, order.Tier)
+	if err != nil {
+		log.Error().Msgf("Withdraw SignTransfer err is %s ", err.Error())
+		order.Error = err.Error()
+		_ = order.Transit(db.WithdrawFailed)
+		db.UpDataWithdraw(order)
+		return err
+	}
+	order.From = hotWallet
+	order.Hash = signTx.Hash().Hex()
+	order.Nonce = signTx.Nonce()
+	order.Raw, _ = signTx.MarshalBinary()
+	_ = order.Transit(db.WithdrawSigned)
+	// 先落地签名后的交易 再广播
+	if err = db.UpDataWithdraw(order); err != nil {
+		engine.Nonces.Release(common.HexToAddress(hotWallet), order.Nonce)
+		return err
+	}
+	broadcastWithdraw(order, signTx, value)
+	return nil
+}
+
+// resumeWithdraws 启动时继续处理中断的订单 没有签名的签名广播 已签名的重新广播同一笔交易
+func resumeWithdraws() {
+	if withdrawKey == "" {
+		return
+	}
+	for _, order := range db.GetUnfinishedWithdraws() {
+		if _, loaded := withdrawRunning.LoadOrStore(order.OrderId, true); loaded {
+			continue
+		}
+		log.Info().Msgf("resumeWithdraws %s state %s ", order.OrderId, order.State)
+		if order.State == db.WithdrawCreated {
+			_ = sendWithdraw(order)
+		} else {
+			resumeWithdraw(order)
+		}
+		withdrawRunning.Delete(order.OrderId)
+	}
+}
+
+// GetWithdraw 根据订单号查询提现订单
+func GetWithdraw(c *gin.Context) {
+	var gR GetWithdrawReq
+	if err := c.ShouldBindQuery(&gR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	order := db.GetWithdraw(gR.OrderId)
+	if order == nil {
+		APIResponse(c, ErrNotData, nil)
+		return
+	}
+	APIResponse(c, nil, newWithdrawRes(order))
+}
+
+// resumeWithdraw 重新广播已签名的提现交易 节点已经有这笔交易时直接认为广播成功
+func resumeWithdraw(order *db.Withdraw) {
+	signTx := new(ethTypes.Transaction)
+	if err := signTx.UnmarshalBinary(order.Raw); err != nil {
+		log.Error().Msgf("resumeWithdraw UnmarshalBinary err is %s ", err.Error())
+		return
+	}
+	if _, err := engine.EWorker.GetTransactionByHash(order.Hash); err == nil {
+		_ = order.Transit(db.WithdrawBroadcast)
+		db.UpDataWithdraw(order)
+		return
+	}
+	value, _ := new(big.Int).SetString(order.Value, 10)
+	broadcastWithdraw(order, signTx, value)
+}
+
+// broadcastWithdraw 广播提现交易 记录广播结果
+func broadcastWithdraw(order *db.Withdraw, signTx *ethTypes.Transaction, value *big.Int) {
+	if _, err := engine.EWorker.BroadcastTransfer(signTx, order.Address, value, order.CoinName, order.Tier); err != nil {
+		log.Error().Msgf("broadcastWithdraw %s err is %s ", order.OrderId, err.Error())
+		order.Error = err.Error()
+		if engine.BroadcastRejected(err) {
+			_ = order.Transit(db.WithdrawFailed)
+		} else {
+			// 超时等错误节点可能已经收到交易 按已广播处理 以区块监听的结果为准
+			_ = order.Transit(db.WithdrawBroadcast)
+		}
+	} else {
+		_ = order.Transit(db.WithdrawBroadcast)
+	}
+	db.UpDataWithdraw(order)
+}
+
+func newWithdrawRes(order *db.Withdraw) *WithdrawRes {
+	res := &WithdrawRes{
+		OrderId:     order.OrderId,
+		State:       order.State,
+		Hash:        order.Hash,
+		From:        order.From,
+		Address:     order.Address,
+		CoinName:    order.CoinName,
+		Value:       order.Value,
+		Nonce:       order.Nonce,
+		BlockNumber: order.BlockNumber,
+		Error:       order.Error,
+		CreatedAt:   order.CreatedAt,
+		UpdatedAt:   order.UpdatedAt,
+	}
+	res.setValue(coinMeta(order.CoinName))
+	return res
+}
+
+// BatchPayout 批量打款 由提现热钱包发送 相同批次号重复请求返回已有批次 不会重复发送
+// preview 为 true 时只模拟每条明细并预估总费用
+func BatchPayout(c *gin.Context) {
+	var pR PayoutReq
+	if err := c.ShouldBindJSON(&pR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if withdrawKey == "" {
+		APIResponse(c, ErrNoWithdrawKey, nil)
+		return
+	}
+	if pR.Mode == "" {
+		pR.Mode = engine.PayoutSequential
+	}
+	if pR.Tier == "" {
+		pR.Tier = engine.FeeNormal
+	}
+	if pR.Mode == engine.PayoutDisperse && disperseAddress == "" {
+		APIResponse(c, ErrNoDisperse, nil)
+		return
+	}
+	// 每条明细的数量按币种精度转成最小单位
+	values := make([]*big.Int, 0, len(pR.Items))
+	metas := map[string]*db.TokenMeta{}
+	for _, item := range pR.Items {
+		if !common.IsHexAddress(item.To) || (item.CoinName != "" && !common.IsHexAddress(item.CoinName)) {
+			APIResponse(c, ErrParam, nil)
+			return
+		}
+		meta, ok := metas[item.CoinName]
+		if !ok {
+			var err error
+			if meta, err = amountMeta(item.CoinName); err != nil {
+				APIResponse(c, err, nil)
+				return
+			}
+			metas[item.CoinName] = meta
+		}
+		value, err := engine.ParseUnits(item.Value, meta.Decimals)
+		if err != nil {
+			APIResponse(c, amountError(err), nil)
+			return
+		}
+		if value.Sign() <= 0 {
+			APIResponse(c, ErrAmount, nil)
+			return
+		}
+		values = append(values, value)
+	}
+	if pR.Preview {
+		res, err := previewPayout(&pR, values, metas)
+		APIResponse(c, err, res)
+		return
+	}
+	items := make([]*db.PayoutItem, 0, len(pR.Items))
+	for i, v := range pR.Items {
+		items = append(items, &db.PayoutItem{To: v.To, CoinName: v.CoinName, Value: values[i].String()})
+	}
+	p, _, err := db.CreatePayout(&db.Payout{BatchId: pR.BatchId, Mode: pR.Mode, Tier: pR.Tier, From: hotWallet}, items)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	// 新建的批次开始发送 中断的批次继续发送
+	startPayout(p)
+	APIResponse(c, nil, newPayoutRes(p, db.GetPayoutItems(p.BatchId)))
+}
+
+// GetPayout 查询批量打款 包含每条明细的状态和失败原因
+func GetPayout(c *gin.Context) {
+	var gR GetPayoutReq
+	if err := c.ShouldBindQuery(&gR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	p := db.GetPayout(gR.BatchId)
+	if p == nil {
+		APIResponse(c, ErrNotData, nil)
+		return
+	}
+	APIResponse(c, nil, newPayoutRes(p, db.GetPayoutItems(p.BatchId)))
+}
+
+func newPayoutRes(p *db.Payout, items []*db.PayoutItem) *PayoutRes {
+	res := &PayoutRes{
+		BatchId: p.BatchId,
+		Mode:    p.Mode,
+		From:    p.From,
+		State:   p.State,
+		Total:   p.Total,
+		Items:   make([]*PayoutItemRes, 0, len(items)),
+	}
+	metas := map[string]*db.TokenMeta{}
+	for _, item := range items {
+		switch item.State {
+		case db.WithdrawCreated, db.WithdrawSigned:
+			res.Pending++
+		case db.WithdrawBroadcast:
+			res.Broadcast++
+		case db.WithdrawConfirmed:
+			res.Confirmed++
+		case db.WithdrawFailed:
+			res.Failed++
+		}
+		itemRes := &PayoutItemRes{
+			Index:       item.Index,
+			To:          item.To,
+			CoinName:    item.CoinName,
+			Value:       item.Value,
+			State:       item.State,
+			Hash:        item.Hash,
+			Nonce:       item.Nonce,
+			Error:       item.Error,
+			BlockNumber: item.BlockNumber,
+		}
+		meta, ok := metas[item.CoinName]
+		if !ok {
+			meta = coinMeta(item.CoinName)
+			metas[item.CoinName] = meta
+		}
+		itemRes.setValue(meta)
+		res.Items = append(res.Items, itemRes)
+	}
+	return res
+}
+
+// Collection 手动归集 按配置的阈值归集所有地址 或者指定地址和币种 返回归集报告
+func Collection(c *gin.Context) {
+	var cR CollectionReq
+	if err := c.ShouldBindJSON(&cR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if sweeper == nil {
+		APIResponse(c, ErrNoCollection, nil)
+		return
+	}
+	if (cR.Address != "" && !common.IsHexAddress(cR.Address)) || (cR.CoinName != "" && !common.IsHexAddress(cR.CoinName)) {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	plan := sweeper.plan
+	if cR.Max != "" {
+		// 阈值必须是非负整数 小数、科学计数法都不接受
+		max, ok := new(big.Int).SetString(cR.Max, 10)
+		if !ok || max.Sign() < 0 {
+			APIResponse(c, ErrParam, nil)
+			return
+		}
+		plan = map[string]*big.Int{cR.CoinName: max}
+	} else if cR.CoinName != "" {
+		max, ok := sweeper.plan[cR.CoinName]
+		if !ok {
+			APIResponse(c, ErrNoCoin, nil)
+			return
+		}
+		plan = map[string]*big.Int{cR.CoinName: max}
+	}
+	s, err := sweeper.sweep(db.SweepManual, cR.Address, plan, cR.Preview)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, newCollectionRes(s))
+}
+
+// GetCollection 最近的归集报告 最新的在前
+func GetCollection(c *gin.Context) {
+	var gR GetCollectionReq
+	if err := c.ShouldBindQuery(&gR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if gR.Limit == 0 {
+		gR.Limit = 10
+	}
+	list := []*CollectionRes{}
+	for _, s := range db.GetSweeps(gR.Limit) {
+		list = append(list, newCollectionRes(s))
+	}
+	APIResponse(c, nil, list)
+}
+
+func newCollectionRes(s *db.Sweep) *CollectionRes {
+	res := &CollectionRes{
+		Id:         s.Id,
+		Trigger:    s.Trigger,
+		Preview:    s.Preview,
+		To:         s.To,
+		Tier:       s.Tier,
+		StartedAt:  s.StartedAt,
+		FinishedAt: s.FinishedAt,
+		Totals:     []*CollectionCoinRes{},
+		Items:      []*CollectionItemRes{},
+	}
+	for _, t := range s.Totals {
+		res.Totals = append(res.Totals, &CollectionCoinRes{CoinName: t.CoinName, Balance: t.Amount, Count: t.Count})
+	}
+	for _, item := range s.Items {
+		res.Items = append(res.Items, &CollectionItemRes{
+			Address:  item.Address,
+			CoinName: item.CoinName,
+			Balance:  item.Balance,
+			Amount:   item.Amount,
+			GasFee:   item.GasFee,
+			TopUp:    item.TopUp,
+			Hash:     item.Hash,
+			Error:    item.Error,
+		})
+	}
+	return res
+}
+
+// GetGasSpent 加油站一天给每个币种补的手续费 默认当天（UTC）
+func GetGasSpent(c *gin.Context) {
+	var gR GetGasSpentReq
+	if err := c.ShouldBindQuery(&gR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if station == nil {
+		APIResponse(c, ErrNoGasStation, nil)
+		return
+	}
+	if gR.Day == "" {
+		gR.Day = db.GasDay(time.Now())
+	}
+	spends := db.GetGasSpends(gR.Day)
+	spent := db.GasSpentTotal(spends)
+	res := &GasSpentRes{
+		Day:    gR.Day,
+		Wallet: station.address,
+		Spent:  spent.String(),
+		Coins:  []*GasCoinRes{},
+	}
+	if balance, err := engine.EWorker.GetBalance(station.address, ""); err == nil {
+		res.Balance = balance.String()
+	}
+	if station.budget != nil {
+		res.Budget = station.budget.String()
+		remaining := new(big.Int).Sub(station.budget, spent)
+		if remaining.Sign() < 0 {
+			remaining.SetInt64(0)
+		}
+		res.Remaining = remaining.String()
+	}
+	for _, s := range spends {
+		res.Coins = append(res.Coins, &GasCoinRes{CoinName: s.CoinName, Value: s.Value, Count: s.Count})
+	}
+	APIResponse(c, nil, res)
+}
+
+// newSignedRes 只签名的返回 签名失败时为 nil
+// 只签名的交易不占用 nonce 管理分配的 nonce 签名后立即归还 自己广播前本服务发送的交易可能使用同一个 nonce
+func newSignedRes(from string, signTx *ethTypes.Transaction) *SendTransactionRes {
+	if signTx == nil {
+		return nil
+	}
+	engine.Nonces.Release(common.HexToAddress(from), signTx.Nonce())
+	raw, _ := signTx.MarshalBinary()
+	return &SendTransactionRes{
+		FromHex:       common.HexToAddress(from).Hex(),
+		SignHax:       signTx.Hash().Hex(),
+		Nonce:         signTx.Nonce(),
+		Raw:           hexutil.Encode(raw),
+		NonceReserved: new(bool),
+	}
+}
+
+// SendRawTransaction 广播已签名的交易 记录到 pending 和交易历史
+func SendRawTransaction(c *gin.Context) {
+	var sR SendRawTransactionReq
+	if err := c.ShouldBindJSON(&sR); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	raw, err := hexutil.Decode(sR.Raw)
+	if err != nil {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	signTx := new(ethTypes.Transaction)
+	if err := signTx.UnmarshalBinary(raw); err != nil {
+		log.Info().Msgf("SendRawTransaction UnmarshalBinary err is %s ", err.Error())
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	// 只能广播自己钱包签名的交易 其他地址的交易不进入 nonce 管理和交易历史
+	from, err := ethTypes.Sender(ethTypes.LatestSignerForChainID(signTx.ChainId()), signTx)
+	if err != nil {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	if !checkOwnWallets(c.GetHeader("Account"), []string{from.Hex()}) {
+		APIResponse(c, ErrNoPremission, nil)
+		return
+	}
+	ts, err := engine.EWorker.SendRawTransaction(signTx)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, &SendTransactionRes{FromHex: ts.From, SignHax: ts.Hash, Nonce: ts.Nonce})
+}
+
+// GetLogs 查询日志 大范围自动分段 已经最终确认的分段使用缓存
+func GetLogs(c *gin.Context) {
+	var req GetLogsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	q, err := newLogQuery(&req)
+	if err != nil {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	logs, err := engine.EWorker.GetLogs(q, logPolicy)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, logs)
+}
+
+// newLogQuery 解析日志的过滤条件
+func newLogQuery(req *GetLogsReq) (*engine.LogQuery, error) {
+	q := &engine.LogQuery{}
+	var err error
+	if req.BlockHash != "" {
+		if req.FromBlock != "" || req.ToBlock != "" {
+			return nil, errors.New("blockHash cannot be used with fromBlock or toBlock")
+		}
+		hash, err := hexutil.Decode(req.BlockHash)
+		if err != nil || len(hash) != common.HashLength {
+			return nil, errors.New("invalid blockHash")
+		}
+		blockHash := common.BytesToHash(hash)
+		q.BlockHash = &blockHash
+	} else {
+		if q.FromBlock, err = engine.ParseBlockNumber(req.FromBlock); err != nil {
+			return nil, err
+		}
+		if q.ToBlock, err = engine.ParseBlockNumber(req.ToBlock); err != nil {
+			return nil, err
+		}
+	}
+	addresses, err := parseHashes(req.Address, common.AddressLength)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addresses {
+		q.Addresses = append(q.Addresses, common.BytesToAddress(a))
+	}
+	for _, t := range req.Topics {
+		topics, err := parseHashes(t, common.HashLength)
+		if err != nil {
+			return nil, err
+		}
+		hashes := []common.Hash{}
+		for _, h := range topics {
+			hashes = append(hashes, common.BytesToHash(h))
+		}
+		q.Topics = append(q.Topics, hashes)
+	}
+	return q, nil
+}
+
+// parseHashes 解析 null 一个值或者数组 每个值必须是 size 字节的 16 进制
+func parseHashes(raw json.RawMessage, size int) ([][]byte, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var one string
+		if err := json.Unmarshal(raw, &one); err != nil {
+			return nil, err
+		}
+		list = []string{one}
+	}
+	res := make([][]byte, 0, len(list))
+	for _, s := range list {
+		b, err := hexutil.Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != size {
+			return nil, errors.New("invalid length " + s)
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+// SiweNonce 发出以太坊登录使用的 nonce
+func SiweNonce(c *gin.Context) {
+	if siweDomain == "" {
+		APIResponse(c, ErrNoSiweDomain, nil)
+		return
+	}
+	nonce, err := engine.NewSiweNonce()
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	if err := db.AddSiweNonce(nonce); err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, SiweNonceRes{Nonce: nonce, Domain: siweDomain})
+}
+
+// SiweLogin 以太坊登录 EIP-4361 验证通过后地址绑定到账户 登录状态和密码登录一致
+// 请求头带上已登录的 Account 时把地址绑定到该账户 否则使用地址已绑定的账户 没有绑定时以地址为账户名新建
+func SiweLogin(c *gin.Context) {
+	var req SiweLoginReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	// 域名只能来自配置 请求的 Host 可以被伪造
+	if siweDomain == "" {
+		APIResponse(c, ErrNoSiweDomain, nil)
+		return
+	}
+	msg, err := engine.ParseSiweMessage(req.Message)
+	if err != nil {
+		APIResponse(c, &Err{Code: ErrSiweMessage.Code, Message: ErrSiweMessage.Message + ": " + err.Error(), Err: err}, nil)
+		return
+	}
+	chainID, err := engine.EWorker.ChainID()
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	if err := msg.Validate(siweDomain, chainID.Uint64(), time.Now()); err != nil {
+		APIResponse(c, &Err{Code: ErrSiweMessage.Code, Message: ErrSiweMessage.Message + ": " + err.Error(), Err: err}, nil)
+		return
+	}
+	ok, err := engine.EWorker.VerifySiwe(req.Message, msg, req.Signature)
+	if err != nil {
+		log.Info().Msgf("SiweLogin VerifySiwe err is %s ", err.Error())
+	}
+	if !ok {
+		APIResponse(c, ErrSignature, nil)
+		return
+	}
+	// 签名验证通过后再使用 nonce 防止别人用错误的签名消耗掉 nonce
+	if !db.UseSiweNonce(msg.Nonce) {
+		APIResponse(c, ErrSiweNonce, nil)
+		return
+	}
+	address := msg.Address.Hex()
+	account := db.GetSiweAccount(address)
+	if current := c.GetHeader("Account"); current != "" && db.CheckLoginInfo(current) {
+		if account != "" && account != current {
+			APIResponse(c, ErrAddressBound, nil)
+			return
+		}
+		account = current
+	}
+	if account == "" {
+		// 同名的账户已经用密码注册过 不能直接登录
+		if db.GetAccountInfo(address) != nil {
+			APIResponse(c, ErrNotRepeatData, nil)
+			return
+		}
+		account = address
+	}
+	ac, err := db.BindSiweAddress(account, address)
+	if err == db.ErrAddressBound {
+		APIResponse(c, ErrAddressBound, nil)
+		return
+	}
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	db.UpDataLoginInfo(account)
+	APIResponse(c, nil, SiweLoginRes{Account: account, Address: address, WalletList: ac.WalletList})
+}
+
+// VerifySignature 验证签名 普通地址用 ecrecover 合约钱包调用 EIP-1271 的 isValidSignature
+func VerifySignature(c *gin.Context) {
+	var req VerifySignatureReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	if !common.IsHexAddress(req.Address) {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	sig, err := hexutil.Decode(req.Signature)
+	if err != nil {
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	var hash []byte
+	switch {
+	case req.TypedData != nil:
+		if err = req.TypedData.Validate(); err == nil {
+			hash, _, err = types.TypedDataAndHash(*req.TypedData)
+		}
+	case len(req.Data) > 0:
+		hash, err = types.LegacyTypedDataHash(req.Data)
+	case req.Message != "":
+		hash = engine.PersonalHash(messageBytes(req.Message))
+	default:
+		APIResponse(c, ErrParam, nil)
+		return
+	}
+	if err != nil {
+		APIResponse(c, &Err{Code: ErrTypedData.Code, Message: ErrTypedData.Message + ": " + err.Error(), Err: err}, nil)
+		return
+	}
+	check, err := engine.EWorker.CheckSignature(common.HexToAddress(req.Address), hash, sig)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	APIResponse(c, nil, VerifySignatureRes{
+		Valid:  check.Valid,
+		Signer: check.Signer,
+		Method: check.Method,
+		Hash:   hexutil.Encode(hash),
+	})
+}
+
+// messageBytes personal_sign 的消息 16 进制按字节 否则按 utf8
+func messageBytes(message string) []byte {
+	data, err := hexutil.Decode(message)
+	if err != nil {
+		return []byte(message)
+	}
+	return data
+}
+
+// callData 合约调用的数据 传入 method 时按上传的 ABI 编码参数并返回使用的 ABI 否则直接解码 data
+func callData(cr *CallContractReq) ([]byte, *abi.ABI, error) {
+	if cr.Method == "" {
+		data, err := hexutil.Decode(cr.Data)
+		return data, nil, err
+	}
+	a := engine.Abis.Lookup(cr.Network, cr.To)
+	if a == nil {
+		return nil, nil, ErrNoAbi
+	}
+	data, err := engine.EncodeCall(a, cr.Method, cr.Args)
+	if err != nil {
+		return nil, nil, &Err{Code: ErrParam.Code, Message: ErrParam.Message + ": " + err.Error(), Err: err}
+	}
+	return data, a, nil
+}
+
+// AddContractAbi 上传合约的 ABI 之后可以按方法名调用 监听和历史记录会解析调用参数和事件
+func AddContractAbi(c *gin.Context) {
+	var req AddContractAbiReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	abiJSON := string(req.Abi)
+	// 也可以是 ABI 的 JSON 字符串 比如直接复制的 etherscan 返回
+	var s string
+	if json.Unmarshal(req.Abi, &s) == nil {
+		abiJSON = s
+	}
+	a, err := engine.Abis.Register(req.Network, req.Address, req.Name, abiJSON)
+	if err != nil {
+		log.Info().Msgf("AddContractAbi err is %s ", err.Error())
+		APIResponse(c, &Err{Code: ErrAbi.Code, Message: ErrAbi.Message + ": " + err.Error(), Err: err}, nil)
+		return
+	}
+	APIResponse(c, nil, a)
+}
+
+// DelContractAbi 删除合约的 ABI
+func DelContractAbi(c *gin.Context) {
+	var req DelContractAbiReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	ok, err := engine.Abis.Remove(req.Network, req.Address)
+	if err != nil {
+		APIResponse(c, err, nil)
+		return
+	}
+	if !ok {
+		APIResponse(c, ErrNoAbi, nil)
+		return
+	}
+	APIResponse(c, nil, nil)
+}
+
+// GetContractAbi 查询上传的 ABI 不传地址时返回网络上所有的
+func GetContractAbi(c *gin.Context) {
+	var req GetContractAbiReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		HandleValidatorError(c, err)
+		return
+	}
+	network := engine.Abis.Network(req.Network)
+	if req.Address == "" {
+		APIResponse(c, nil, db.GetContractAbis(network))
+		return
+	}
+	a := db.GetContractAbi(network, common.HexToAddress(req.Address).Hex())
+	if a == nil {
+		APIResponse(c, ErrNoAbi, nil)
+		return
+	}
+	APIResponse(c, nil, a)
+}
+
+// amountMeta 转账币种的精度 之前添加的代币没有元数据时从合约读取
+func amountMeta(coinName string) (*db.TokenMeta, error) {
+	if meta := coinMeta(coinName); meta != nil {
+		return meta, nil
+	}
+	meta, err := registerToken(coinName)
+	if err == engine.ErrNotToken {
+		return nil, ErrNotToken
+	}
+	return meta, err
+}
+
+// amountError 转换数量的错误
+func amountError(err error) error {
+	if err == engine.ErrAmountPrecision {
+		return &Err{Code: ErrAmountPrecision.Code, Message: ErrAmountPrecision.Message, Err: err}
+	}
+	return &Err{Code: ErrAmount.Code, Message: ErrAmount.Message + ": " + err.Error(), Err: err}
 }
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gin-gonic/gin"
+	"github.com/lmxdawn/wallet/engine"
+	"github.com/rs/zerolog/log"
+)
+
+// JSON-RPC 2.0 的错误码
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000 // 业务错误 data 中带上 Errno 的错误码
+	rpcReverted       = 3      // 和 geth 一致 执行回滚 data 为回滚数据
+)
+
+// rpcMaxBatch 一次批量请求最多的调用数量
+const rpcMaxBatch = 100
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcMethod 处理一个方法 params 为按位置传入的参数
+type rpcMethod func(c *gin.Context, params []json.RawMessage) (interface{}, error)
+
+// rpcMethods 本服务处理的方法 其他只读方法转发到节点
+var rpcMethods = map[string]rpcMethod{
+	"eth_chainId":              rpcChainId,
+	"net_version":              rpcNetVersion,
+	"eth_blockNumber":          rpcBlockNumber,
+	"eth_gasPrice":             rpcGasPrice,
+	"eth_maxPriorityFeePerGas": rpcMaxPriorityFeePerGas,
+	"eth_sendRawTransaction":   rpcSendRawTransaction,
+	"eth_getLogs":              rpcGetLogs,
+}
+
+// rpcPassthrough 原样转发到节点的只读方法 不在这里的方法都不转发
+var rpcPassthrough = map[string]bool{
+	"eth_getBalance":                          true,
+	"eth_getCode":                             true,
+	"eth_getStorageAt":                        true,
+	"eth_getProof":                            true,
+	"eth_getTransactionCount":                 true,
+	"eth_call":                                true,
+	"eth_estimateGas":                         true,
+	"eth_createAccessList":                    true,
+	"eth_feeHistory":                          true,
+	"eth_syncing":                             true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionReceipt":               true,
+	"eth_getUncleCountByBlockNumber":          true,
+	"eth_getUncleCountByBlockHash":            true,
+	"net_listening":                           true,
+	"net_peerCount":                           true,
+	"web3_clientVersion":                      true,
+	"web3_sha3":                               true,
+}
+
+// JSONRPC 标准的 JSON-RPC 2.0 接口 支持批量请求 可以直接作为 ethers.js web3.js viem 的 provider
+func JSONRPC(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusOK, rpcErrorResponse(nil, &rpcError{Code: rpcParseError, Message: "parse error"}))
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			c.JSON(http.StatusOK, rpcErrorResponse(nil, &rpcError{Code: rpcParseError, Message: "parse error"}))
+			return
+		}
+		if len(batch) == 0 || len(batch) > rpcMaxBatch {
+			c.JSON(http.StatusOK, rpcErrorResponse(nil, &rpcError{Code: rpcInvalidRequest, Message: "invalid batch size"}))
+			return
+		}
+		res := []*rpcResponse{}
+		for _, msg := range batch {
+			if r := handleRPC(c, msg); r != nil {
+				res = append(res, r)
+			}
+		}
+		if len(res) == 0 {
+			// 全部是通知 不需要返回
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	res := handleRPC(c, body)
+	if res == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// handleRPC 处理一个调用 没有 id 的通知返回 nil
+func handleRPC(c *gin.Context, msg json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		// 合法的 JSON 但不是请求对象 比如批量请求中的数字
+		if json.Valid(msg) {
+			return rpcErrorResponse(nil, &rpcError{Code: rpcInvalidRequest, Message: "invalid request"})
+		}
+		return rpcErrorResponse(nil, &rpcError{Code: rpcParseError, Message: "parse error"})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, &rpcError{Code: rpcInvalidRequest, Message: "invalid request"})
+	}
+	var params []json.RawMessage
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return rpcErrorResponse(req.ID, &rpcError{Code: rpcInvalidParams, Message: "params must be an array"})
+		}
+	}
+	result, err := callRPC(c, req.Method, params)
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if err != nil {
+		return rpcErrorResponse(req.ID, toRPCError(err))
+	}
+	raw, ok := result.(json.RawMessage)
+	if !ok {
+		if raw, err = json.Marshal(result); err != nil {
+			return rpcErrorResponse(req.ID, &rpcError{Code: rpcInternalError, Message: err.Error()})
+		}
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: raw}
+}
+
+// callRPC 本服务处理的方法直接调用 其他的只读方法转发到节点
+func callRPC(c *gin.Context, method string, params []json.RawMessage) (interface{}, error) {
+	if h, ok := rpcMethods[method]; ok {
+		return h(c, params)
+	}
+	if h, ok := rpcWalletMethods[method]; ok {
+		ac := rpcAccount(c)
+		if ac == nil {
+			if method == "eth_accounts" {
+				// 没有登录时和没有连接的钱包一样返回空列表
+				return []string{}, nil
+			}
+			return nil, errRPCUnauthorized
+		}
+		return h(c, ac, params)
+	}
+	if !rpcPassthrough[method] {
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "the method " + method + " does not exist/is not available"}
+	}
+	return engine.EWorker.RawCall(method, params)
+}
+
+func rpcErrorResponse(id json.RawMessage, err *rpcError) *rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// toRPCError 转换成 JSON-RPC 的错误 节点返回的错误保留错误码和数据
+func toRPCError(err error) *rpcError {
+	var rErr *rpcError
+	if errors.As(err, &rErr) {
+		return rErr
+	}
+	if rev, ok := err.(*engine.RevertError); ok {
+		return &rpcError{Code: rpcReverted, Message: rev.Error(), Data: rev.Data}
+	}
+	if errno, ok := err.(*Errno); ok {
+		return &rpcError{Code: rpcServerError, Message: errno.Message, Data: errno.Code}
+	}
+	if e, ok := err.(rpc.Error); ok {
+		res := &rpcError{Code: e.ErrorCode(), Message: err.Error()}
+		if de, ok := err.(rpc.DataError); ok {
+			res.Data = de.ErrorData()
+		}
+		return res
+	}
+	return &rpcError{Code: rpcInternalError, Message: err.Error()}
+}
+
+// rpcParams 按位置解析参数 参数少于 required 个时返回参数错误 多余的目标保持零值
+func rpcParams(params []json.RawMessage, required int, targets ...interface{}) error {
+	if len(params) < required || len(params) > len(targets) {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid params count"}
+	}
+	for i, p := range params {
+		if err := json.Unmarshal(p, targets[i]); err != nil {
+			return &rpcError{Code: rpcInvalidParams, Message: "invalid argument " + strconv.Itoa(i) + ": " + err.Error()}
+		}
+	}
+	return nil
+}
+
+func rpcChainId(c *gin.Context, params []json.RawMessage) (interface{}, error) {
+	chainID, err := engine.EWorker.ChainID()
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(chainID), nil
+}
+
+func rpcNetVersion(c *gin.Context, params []json.RawMessage) (interface{}, error) {
+	chainID, err := engine.EWorker.ChainID()
+	if err != nil {
+		return nil, err
+	}
+	return chainID.String(), nil
+}
+
+func rpcBlockNumber(c *gin.Context, params []json.RawMessage) (interface{}, error) {
+	number, err := engine.EWorker.GetBlockNumber()
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Uint64(number), nil
+}
+
+// rpcGasPrice 传统交易的 gasPrice 为下一个区块的 baseFee 加上 normal 档位的小费
+func rpcGasPrice(c *gin.Context, params []json.RawMessage) (interface{}, error) {
+	est, err := engine.EWorker.GetGasPrice()
+	if err != nil {
+		return nil, err
+	}
+	price := new(big.Int).Add(est.BaseFee, est.Tier(engine.FeeNormal).MaxPriorityFeePerGas)
+	return (*hexutil.Big)(price), nil
+}
+
+func rpcMaxPriorityFeePerGas(c *gin.Context, params []json.RawMessage) (interface{}, error) {
+	fee, err := engine.EWorker.FeeTier(engine.FeeNormal)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(fee.MaxPriorityFeePerGas), nil
+}
+
+// rpcSendRawTransaction 登录账户自己钱包的交易广播并记录到 pending 和交易历史 其他交易原样转发到节点 不写数据库
+func rpcSendRawTransaction(c *gin.Context, params []json.RawMessage) (interface{}, error) {
+	var raw hexutil.Bytes
+	if err := rpcParams(params, 1, &raw); err != nil {
+		return nil, err
+	}
+	signTx := new(ethTypes.Transaction)
+	if err := signTx.UnmarshalBinary(raw); err != nil {
+		log.Info().Msgf("rpcSendRawTransaction UnmarshalBinary err is %s ", err.Error())
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid transaction: " + err.Error()}
+	}
+	from, err := ethTypes.Sender(ethTypes.LatestSignerForChainID(signTx.ChainId()), signTx)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid transaction: " + err.Error()}
+	}
+	if ac := rpcAccount(c); ac == nil || !checkOwnWallets(ac.Account, []string{from.Hex()}) {
+		return engine.EWorker.RawCall("eth_sendRawTransaction", params)
+	}
+	ts, err := engine.EWorker.SendRawTransaction(signTx)
+	if err != nil {
+		return nil, err
+	}
+	return ts.Hash, nil
+}
+
+// rpcGetLogs 和 /eth_getLogs 一样分段查询并缓存
+func rpcGetLogs(c *gin.Context, params []json.RawMessage) (interface{}, error) {
+	var req GetLogsReq
+	if err := rpcParams(params, 1, &req); err != nil {
+		return nil, err
+	}
+	q, err := newLogQuery(&req)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	logs, err := engine.EWorker.GetLogs(q, logPolicy)
+	if err == engine.ErrLogRange || err == engine.ErrLogTooLarge {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
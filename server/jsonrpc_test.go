@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveRPC(t *testing.T, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/rpc", JSONRPC)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+	return w
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	cases := []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_sign","params":[]}`, rpcMethodNotFound},
+		{`{"jsonrpc":"2.0","id":1,"method":"admin_peers"}`, rpcMethodNotFound},
+		{`{"jsonrpc":"1.0","id":1,"method":"eth_chainId"}`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":{}}`, rpcInvalidParams},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":[]}`, rpcInvalidParams},
+		{`{"jsonrpc":"2.0","id":1,`, rpcParseError},
+		{`1`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_newFilter","params":[{}]}`, rpcMethodNotFound},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_requestAccounts"}`, rpcUnauthorized},
+		{`{"jsonrpc":"2.0","id":1,"method":"personal_sign","params":["0x00","0x0000000000000000000000000000000000000001"]}`, rpcUnauthorized},
+	}
+	for _, tc := range cases {
+		var res rpcResponse
+		if err := json.Unmarshal(serveRPC(t, tc.body).Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s unmarshal err is %s", tc.body, err)
+		}
+		if res.Error == nil || res.Error.Code != tc.code {
+			t.Fatalf("%s got %+v want code %d", tc.body, res.Error, tc.code)
+		}
+	}
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	// 通知没有返回 其他按顺序返回并保留 id
+	body := `[{"jsonrpc":"2.0","id":"a","method":"eth_sign"},{"jsonrpc":"2.0","method":"eth_sign"},{"jsonrpc":"2.0","id":2,"method":"foo"}]`
+	var res []rpcResponse
+	if err := json.Unmarshal(serveRPC(t, body).Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || string(res[0].ID) != `"a"` || string(res[1].ID) != "2" {
+		t.Fatalf("batch got %+v", res)
+	}
+	if w := serveRPC(t, `[{"jsonrpc":"2.0","method":"eth_sign"}]`); w.Code != http.StatusNoContent {
+		t.Fatalf("notification only batch got status %d", w.Code)
+	}
+	var invalid []rpcResponse
+	if err := json.Unmarshal(serveRPC(t, `[1]`).Body.Bytes(), &invalid); err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || invalid[0].Error == nil || invalid[0].Error.Code != rpcInvalidRequest {
+		t.Fatalf("non-object batch element got %+v", invalid)
+	}
+	var empty rpcResponse
+	_ = json.Unmarshal(serveRPC(t, `[]`).Body.Bytes(), &empty)
+	if empty.Error == nil || empty.Error.Code != rpcInvalidRequest {
+		t.Fatalf("empty batch got %+v", empty)
+	}
+}
+
+func TestJSONRPCAccountsWithoutLogin(t *testing.T) {
+	var res rpcResponse
+	if err := json.Unmarshal(serveRPC(t, `{"jsonrpc":"2.0","id":1,"method":"eth_accounts"}`).Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil || string(res.Result) != "[]" {
+		t.Fatalf("eth_accounts without login got %s %+v", res.Result, res.Error)
+	}
+}
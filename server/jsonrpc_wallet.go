@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/engine"
+	"github.com/lmxdawn/wallet/types"
+	"github.com/rs/zerolog/log"
+)
+
+// EIP-1193 / EIP-3085 / EIP-3326 的错误码
+const (
+	rpcUnauthorized      = 4100 // 没有登录 或者地址不属于该账户
+	rpcUnrecognizedChain = 4902 // 切换的网络没有添加
+)
+
+// rpcWalletMethod 需要登录的钱包方法 ac 为请求头 Account 对应的账户
+type rpcWalletMethod func(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error)
+
+// rpcWalletMethods EIP-1193 provider 的钱包方法 使用账户下钱包的私钥
+var rpcWalletMethods = map[string]rpcWalletMethod{
+	"eth_accounts":               rpcAccounts,
+	"eth_requestAccounts":        rpcAccounts,
+	"eth_sendTransaction":        rpcSendTransaction,
+	"personal_sign":              rpcPersonalSign,
+	"eth_signTypedData":          rpcSignTypedDataV1,
+	"eth_signTypedData_v3":       rpcSignTypedData("v3"),
+	"eth_signTypedData_v4":       rpcSignTypedData("v4"),
+	"wallet_addEthereumChain":    rpcAddEthereumChain,
+	"wallet_switchEthereumChain": rpcSwitchEthereumChain,
+}
+
+var errRPCUnauthorized = &rpcError{Code: rpcUnauthorized, Message: "the requested account and/or method has not been authorized by the user"}
+
+// rpcAccount 和 AuthRequired 一样校验登录 没有登录时返回 nil
+func rpcAccount(c *gin.Context) *db.Account {
+	account := c.GetHeader("Account")
+	if account == "" || !db.CheckLoginInfo(account) {
+		return nil
+	}
+	return db.GetAccountInfo(account)
+}
+
+// rpcWallet 获取账户下的钱包 不属于该账户时返回 4100
+func rpcWallet(ac *db.Account, address common.Address) (*db.User, error) {
+	if !checkOwnWallets(ac.Account, []string{address.Hex()}) {
+		return nil, errRPCUnauthorized
+	}
+	usr := db.GetUserFromDB(address.Hex())
+	if usr == nil {
+		return nil, ErrWalletNotInDB
+	}
+	return usr, nil
+}
+
+// rpcAccountUsers 账户下的所有钱包
+func rpcAccountUsers(ac *db.Account) []*db.User {
+	usrs := []*db.User{}
+	for _, address := range ac.WalletList {
+		if usr := db.GetUserFromDB(common.HexToAddress(address).Hex()); usr != nil {
+			usrs = append(usrs, usr)
+		}
+	}
+	return usrs
+}
+
+func rpcAccounts(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error) {
+	accounts := []string{}
+	for _, address := range ac.WalletList {
+		accounts = append(accounts, strings.ToLower(address))
+	}
+	return accounts, nil
+}
+
+// rpcTxArgs eth_sendTransaction 的交易参数 费用由本服务按 normal 档位设置
+type rpcTxArgs struct {
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+	Data  *hexutil.Bytes  `json:"data"`
+	Input *hexutil.Bytes  `json:"input"`
+}
+
+// rpcSendTransaction 和 /callContract 一样模拟执行后签名发送 to 为空表示部署合约
+func rpcSendTransaction(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error) {
+	var args rpcTxArgs
+	if err := rpcParams(params, 1, &args); err != nil {
+		return nil, err
+	}
+	usr, err := rpcWallet(ac, args.From)
+	if err != nil {
+		return nil, err
+	}
+	tx := &ethTypes.DynamicFeeTx{To: args.To, Value: big.NewInt(0)}
+	if args.Value != nil {
+		tx.Value = args.Value.ToInt()
+	}
+	if args.Input != nil {
+		tx.Data = *args.Input
+	} else if args.Data != nil {
+		tx.Data = *args.Data
+	}
+	_, hash, _, err := engine.EWorker.SendContractTrans(usr.PrivateKey, tx, engine.FeeNormal)
+	if err != nil {
+		log.Info().Msgf("rpcSendTransaction err is %s ", err.Error())
+		return nil, err
+	}
+	return hash, nil
+}
+
+// rpcPersonalSign 参数为 [消息, 地址] 消息不是 16 进制时按 utf8 签名
+func rpcPersonalSign(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error) {
+	var message string
+	var address common.Address
+	if err := rpcParams(params, 2, &message, &address, new(string)); err != nil {
+		return nil, err
+	}
+	usr, err := rpcWallet(ac, address)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := engine.EWorker.PersonalSign(messageBytes(message), usr.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Encode(sign), nil
+}
+
+// rpcSignTypedData 参数为 [地址, 类型数据] 类型数据可以是 JSON 字符串或者对象
+func rpcSignTypedData(version string) rpcWalletMethod {
+	return func(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error) {
+		var address common.Address
+		var raw json.RawMessage
+		if err := rpcParams(params, 2, &address, &raw); err != nil {
+			return nil, err
+		}
+		var typedData types.TypedData
+		if err := json.Unmarshal(rpcJSONString(raw), &typedData); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid typed data: " + err.Error()}
+		}
+		return rpcSignTypedDataWith(ac, address, version, &typedData, nil)
+	}
+}
+
+// rpcSignTypedDataV1 eth_signTypedData 的参数顺序和 v3 v4 相反 为 [字段列表, 地址]
+func rpcSignTypedDataV1(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error) {
+	var raw json.RawMessage
+	var address common.Address
+	if err := rpcParams(params, 2, &raw, &address); err != nil {
+		return nil, err
+	}
+	var fields []types.LegacyTypedDataField
+	if err := json.Unmarshal(rpcJSONString(raw), &fields); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid typed data: " + err.Error()}
+	}
+	return rpcSignTypedDataWith(ac, address, "v1", nil, fields)
+}
+
+func rpcSignTypedDataWith(ac *db.Account, address common.Address, version string, typedData *types.TypedData, fields []types.LegacyTypedDataField) (interface{}, error) {
+	usr, err := rpcWallet(ac, address)
+	if err != nil {
+		return nil, err
+	}
+	_, sign, err := signTypedData(usr, version, typedData, fields)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return sign, nil
+}
+
+// rpcJSONString 参数是 JSON 字符串时取出其中的 JSON
+func rpcJSONString(raw json.RawMessage) json.RawMessage {
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		return json.RawMessage(str)
+	}
+	return raw
+}
+
+// rpcChainArgs wallet_addEthereumChain 和 wallet_switchEthereumChain 的参数
+type rpcChainArgs struct {
+	ChainId        hexutil.Uint64 `json:"chainId"`
+	ChainName      string         `json:"chainName"`
+	RpcUrls        []string       `json:"rpcUrls"`
+	NativeCurrency struct {
+		Symbol string `json:"symbol"`
+	} `json:"nativeCurrency"`
+}
+
+// rpcAddEthereumChain 给账户下的所有钱包添加网络 已经添加过的忽略
+func rpcAddEthereumChain(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error) {
+	var args rpcChainArgs
+	if err := rpcParams(params, 1, &args); err != nil {
+		return nil, err
+	}
+	if args.ChainId == 0 || args.ChainName == "" || len(args.RpcUrls) == 0 {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "chainId, chainName and rpcUrls are required"}
+	}
+	for _, usr := range rpcAccountUsers(ac) {
+		err := usr.AddNetWork(args.ChainName, args.RpcUrls[0], args.NativeCurrency.Symbol, uint32(args.ChainId))
+		if err == db.ErrSameNetWork {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := db.UpDataUserInfo(usr); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// rpcSwitchEthereumChain 服务只连接了一个节点 签名和广播都使用节点的链 只能切换到节点所在的链
+// 其他链返回 4902 切换成功时把添加过这个网络的钱包的当前网络设置为它
+func rpcSwitchEthereumChain(c *gin.Context, ac *db.Account, params []json.RawMessage) (interface{}, error) {
+	var args rpcChainArgs
+	if err := rpcParams(params, 1, &args); err != nil {
+		return nil, err
+	}
+	chainID, err := engine.EWorker.ChainID()
+	if err != nil {
+		return nil, err
+	}
+	if !chainID.IsUint64() || chainID.Uint64() != uint64(args.ChainId) {
+		return nil, &rpcError{Code: rpcUnrecognizedChain, Message: "unrecognized chain ID " + args.ChainId.String()}
+	}
+	for _, usr := range rpcAccountUsers(ac) {
+		network := usr.GetNetWorkByChainID(uint32(args.ChainId))
+		if network == nil {
+			continue
+		}
+		if err := usr.ChangeNetWork(network.NetWorkName); err != nil {
+			return nil, err
+		}
+		if err := db.UpDataUserInfo(usr); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
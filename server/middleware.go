@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/subtle"
 	"github.com/gin-gonic/gin"
 	"github.com/lmxdawn/wallet/db"
 	"github.com/lmxdawn/wallet/engine"
@@ -32,6 +33,19 @@ func AuthRequired() gin.HandlerFunc {
 
 }
 
+// AdminRequired 管理接口认证中间件 令牌未配置时拒绝所有请求 按常量时间比较 避免通过响应时间猜测令牌
+func AdminRequired(adminToken string) gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		token := c.GetHeader("Admin-Token")
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			APIResponse(c, ErrNoPremission, nil)
+			c.Abort()
+			return
+		}
+	}
+}
+
 // SetEngine 设置db数据库
 func SetEngine(engines ...*engine.ConCurrentEngine) gin.HandlerFunc {
 
@@ -52,7 +66,7 @@ func Cors() gin.HandlerFunc {
 		if origin != "" {
 			c.Header("Access-Control-Allow-Origin", "*") // 可将将 * 替换为指定的域名
 			c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, UPDATE")
-			c.Header("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization")
+			c.Header("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization, Account, Admin-Token")
 			c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Cache-Control, Content-Language, Content-Type")
 			c.Header("Access-Control-Allow-Credentials", "true")
 		}
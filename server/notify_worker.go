@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lmxdawn/wallet/client"
+	"github.com/lmxdawn/wallet/config"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	notifyBatch     = 100              // 每次领取的事件数量
+	notifyLease     = time.Minute      // 领取后其他实例不可见的时间
+	notifyBaseDelay = 5 * time.Second  // 第一次重试的等待时间 之后每次翻倍
+	notifyMaxDelay  = 60 * time.Minute // 最长的重试等待时间
+)
+
+// notifyDispatcher 从发件箱中领取到期的事件 分发给各个订阅后签名投递
+type notifyDispatcher struct {
+	http        *client.HttpClient
+	maxAttempts int
+	legacy      bool // 是否配置了充值/提现回调地址
+}
+
+var notifier *notifyDispatcher
+
+// startNotify 启动通知投递
+func startNotify(conf config.EngineConfig) {
+	notifier = &notifyDispatcher{
+		http:        client.NewHttpClient("eth", conf.RechargeNotifyUrl, conf.WithdrawNotifyUrl, conf.NotifySecret),
+		maxAttempts: conf.NotifyMaxAttempts,
+		legacy:      conf.RechargeNotifyUrl != "" || conf.WithdrawNotifyUrl != "",
+	}
+	go notifier.run()
+	log.Info().Msgf("startNotify start")
+}
+
+func (d *notifyDispatcher) run() {
+	for {
+		events := db.ClaimNotifyEvents(notifyBatch, notifyLease)
+		for _, ev := range events {
+			if ev.SubscriptionId == "" {
+				d.fanOut(ev)
+				continue
+			}
+			d.deliver(ev)
+		}
+		if len(events) == 0 {
+			<-time.After(time.Second)
+		}
+	}
+}
+
+// fanOut 原始事件按订阅拆分成单独的投递 之后每个订阅各自重试
+func (d *notifyDispatcher) fanOut(ev *db.NotifyEvent) {
+	var deliveries []*db.NotifyEvent
+	if d.legacy && ev.Type != db.NotifyNFTTransfer && ev.Type != db.NotifyReorg {
+		deliveries = append(deliveries, ev.Delivery(db.NotifyLegacy))
+	}
+	wallets := eventWallets(ev)
+	owned := make(map[string][]string)
+	for _, sub := range db.GetAllSubscription("") {
+		if _, ok := owned[sub.Account]; !ok {
+			if ac := db.GetAccountInfo(sub.Account); ac != nil {
+				owned[sub.Account] = ac.WalletList
+			}
+		}
+		if sub.Match(ev, wallets, owned[sub.Account]) {
+			deliveries = append(deliveries, ev.Delivery(sub.Id))
+		}
+	}
+	if len(deliveries) > 0 && db.AddNotifyEvents(deliveries...) != nil {
+		// 写入失败 等租约过期后重新分发
+		return
+	}
+	_ = db.AckNotifyEvent(ev)
+}
+
+// eventWallets 事件涉及的本服务钱包
+func eventWallets(ev *db.NotifyEvent) []string {
+	if ev.Type == db.NotifyNFTTransfer {
+		return []string{ev.From, ev.To}
+	}
+	return []string{ev.Address}
+}
+
+// deliver 投递单个事件 失败按指数退避重试 超过次数进入死信队列
+func (d *notifyDispatcher) deliver(ev *db.NotifyEvent) {
+	var err error
+	if ev.SubscriptionId == db.NotifyLegacy {
+		err = d.deliverLegacy(ev)
+	} else {
+		sub := db.GetSubscription(ev.SubscriptionId)
+		if sub == nil || sub.Disabled {
+			// 订阅已经删除或暂停 不再投递
+			_ = db.AckNotifyEvent(ev)
+			return
+		}
+		_, err = d.deliverTo(sub, ev, ev.Attempts+1)
+	}
+	if err == nil {
+		_ = db.AckNotifyEvent(ev)
+		return
+	}
+
+	ev.Attempts++
+	ev.LastError = err.Error()
+	log.Info().Msgf("notify %s attempts %d err is %s ", ev.Id, ev.Attempts, ev.LastError)
+	if ev.Attempts >= d.maxAttempts {
+		_ = db.DeadNotifyEvent(ev)
+		return
+	}
+	_ = db.RetryNotifyEvent(ev, time.Now().Add(notifyBackoff(ev.Attempts)))
+}
+
+// deliverLegacy 投递给配置文件中的回调地址
+func (d *notifyDispatcher) deliverLegacy(ev *db.NotifyEvent) error {
+	switch ev.Type {
+	case db.NotifyDeposit:
+		return d.http.RechargeSuccess(ev.Id, ev.Hash, ev.LogIndex, ev.Status, ev.CoinName, ev.Address, ev.Value)
+	case db.NotifyWithdraw, db.NotifyTxFailed:
+		return d.http.WithdrawSuccess(ev.Id, ev.Hash, ev.LogIndex, ev.Status, ev.OrderId, ev.CoinName, ev.Address, ev.Value)
+	}
+	return fmt.Errorf("unknown notify type %s", ev.Type)
+}
+
+// deliverTo 投递给订阅 并记录投递结果
+func (d *notifyDispatcher) deliverTo(sub *db.Subscription, ev *db.NotifyEvent, attempt int) (int, error) {
+	data := map[string]interface{}{
+		"id":             ev.Id,
+		"type":           ev.Type,
+		"subscriptionId": sub.Id,
+		"hash":           ev.Hash,
+		"logIndex":       ev.LogIndex,
+		"blockNumber":    ev.BlockNumber,
+		"status":         ev.Status,
+		"orderId":        ev.OrderId,
+		"coinName":       ev.CoinName,
+		"address":        ev.Address,
+		"from":           ev.From,
+		"to":             ev.To,
+		"value":          ev.Value,
+		"createdAt":      ev.CreatedAt,
+	}
+	start := time.Now()
+	code, err := d.http.Deliver(sub.Url, sub.Secrets(), data)
+	delivery := &db.WebhookDelivery{
+		EventId:    ev.Id,
+		Type:       ev.Type,
+		Attempt:    attempt,
+		StatusCode: code,
+		Duration:   time.Since(start).Milliseconds(),
+		TimeStamp:  start.UnixMilli(),
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	db.AddWebhookDelivery(sub.Id, delivery)
+	return code, err
+}
+
+// notifyBackoff 第 attempts 次失败后的等待时间
+func notifyBackoff(attempts int) time.Duration {
+	delay := notifyBaseDelay
+	for i := 1; i < attempts && delay < notifyMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > notifyMaxDelay {
+		return notifyMaxDelay
+	}
+	return delay
+}
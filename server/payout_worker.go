@@ -0,0 +1,357 @@
+package server
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/engine"
+	"github.com/lmxdawn/wallet/types"
+	"github.com/rs/zerolog/log"
+)
+
+// approveTimeout disperse 模式等待代币授权上链的时间
+const approveTimeout = 5 * time.Minute
+
+// payoutRunning 正在发送的批次 同一批次只有一个协程在发送
+var payoutRunning sync.Map
+
+// startPayout 后台发送批次 已经在发送中时直接返回
+func startPayout(p *db.Payout) {
+	if p.State == db.PayoutSent {
+		return
+	}
+	if _, loaded := payoutRunning.LoadOrStore(p.BatchId, true); loaded {
+		return
+	}
+	// 协程中修改的是副本 调用方还要读取批次
+	batch := *p
+	go func() {
+		defer payoutRunning.Delete(batch.BatchId)
+		runPayout(&batch)
+	}()
+}
+
+// runPayout 发送批次中还没有发送的明细 中断后再次调用会继续发送
+func runPayout(p *db.Payout) {
+	log.Info().Msgf("runPayout %s start mode %s ", p.BatchId, p.Mode)
+	p.State = db.PayoutSending
+	db.UpDataPayout(p)
+	items := db.GetPayoutItems(p.BatchId)
+	// 签名后没有广播成功就中断的 重新广播同一笔交易
+	resumePayout(p, items)
+	if p.Mode == engine.PayoutDisperse {
+		sendPayoutDisperse(p, items)
+	} else {
+		sendPayoutSequential(p, items)
+	}
+	p.State = db.PayoutSent
+	db.UpDataPayout(p)
+	log.Info().Msgf("runPayout %s done ", p.BatchId)
+}
+
+// sendPayoutSequential 热钱包按 nonce 顺序逐笔发送 单笔失败不影响其他明细
+func sendPayoutSequential(p *db.Payout, items []*db.PayoutItem) {
+	for _, item := range items {
+		if item.State != db.WithdrawCreated {
+			continue
+		}
+		value, _ := new(big.Int).SetString(item.Value, 10)
+		signTx, err := engine.EWorker.SignTransfer(withdrawKey, item.To, value, 0, item.CoinName, p.Tier)
+		if err != nil {
+			failPayoutItems(p, err, item)
+			continue
+		}
+		if !signPayoutItems(p, signTx, item) {
+			continue
+		}
+		_, err = engine.EWorker.BroadcastTransfer(signTx, item.To, value, item.CoinName, p.Tier)
+		broadcastPayoutItems(p, err, item)
+	}
+}
+
+// sendPayoutDisperse 每个币种按 DisperseChunk 分批调用 Disperse 合约 代币先授权
+func sendPayoutDisperse(p *db.Payout, items []*db.PayoutItem) {
+	coins := []string{}
+	group := map[string][]*db.PayoutItem{}
+	for _, item := range items {
+		if item.State != db.WithdrawCreated {
+			continue
+		}
+		if _, ok := group[item.CoinName]; !ok {
+			coins = append(coins, item.CoinName)
+		}
+		group[item.CoinName] = append(group[item.CoinName], item)
+	}
+	for _, coin := range coins {
+		list := group[coin]
+		if coin != "" {
+			if err := approveDisperse(p, coin, list); err != nil {
+				failPayoutItems(p, err, list...)
+				continue
+			}
+		}
+		for start := 0; start < len(list); start += engine.DisperseChunk {
+			end := start + engine.DisperseChunk
+			if end > len(list) {
+				end = len(list)
+			}
+			sendDisperseChunk(p, coin, list[start:end])
+		}
+	}
+}
+
+// approveDisperse 授权额度不够时给 Disperse 合约增加这批的额度 并等待上链
+func approveDisperse(p *db.Payout, coin string, list []*db.PayoutItem) error {
+	total := big.NewInt(0)
+	for _, item := range list {
+		value, _ := new(big.Int).SetString(item.Value, 10)
+		total.Add(total, value)
+	}
+	allowance, err := engine.EWorker.Allowance(coin, p.From, disperseAddress)
+	if err != nil {
+		return err
+	}
+	if allowance.Cmp(total) >= 0 {
+		return nil
+	}
+	// 在现有额度上增加 其他批次还没有用完的额度不会被覆盖
+	data, err := engine.EWorker.ApproveData(disperseAddress, new(big.Int).Add(allowance, total))
+	if err != nil {
+		return err
+	}
+	signTx, err := engine.EWorker.SignCall(withdrawKey, coin, big.NewInt(0), data, p.Tier)
+	if err != nil {
+		return err
+	}
+	if _, err = engine.EWorker.BroadcastTransfer(signTx, coin, big.NewInt(0), "", p.Tier); err != nil {
+		return err
+	}
+	log.Info().Msgf("approveDisperse %s coin %s hash %s ", p.BatchId, coin, signTx.Hash().Hex())
+	status, err := engine.EWorker.WaitReceipt(signTx.Hash(), approveTimeout)
+	if err != nil {
+		return err
+	}
+	if status != 1 {
+		return engine.ErrTxApproveFailed
+	}
+	return nil
+}
+
+// sendDisperseChunk 一笔 Disperse 调用 这批明细共用一笔交易
+func sendDisperseChunk(p *db.Payout, coin string, list []*db.PayoutItem) {
+	recipients := make([]common.Address, 0, len(list))
+	values := make([]*big.Int, 0, len(list))
+	for _, item := range list {
+		value, _ := new(big.Int).SetString(item.Value, 10)
+		recipients = append(recipients, common.HexToAddress(item.To))
+		values = append(values, value)
+	}
+	value, data, err := engine.DisperseCall(coin, recipients, values)
+	if err != nil {
+		failPayoutItems(p, err, list...)
+		return
+	}
+	signTx, err := engine.EWorker.SignCall(withdrawKey, disperseAddress, value, data, p.Tier)
+	if err != nil {
+		failPayoutItems(p, err, list...)
+		return
+	}
+	if !signPayoutItems(p, signTx, list...) {
+		return
+	}
+	_, err = engine.EWorker.BroadcastTransfer(signTx, disperseAddress, value, "", p.Tier)
+	broadcastPayoutItems(p, err, list...)
+}
+
+// resumePayout 重新广播已签名的交易 节点已经有这笔交易时直接认为广播成功
+func resumePayout(p *db.Payout, items []*db.PayoutItem) {
+	signed := map[string][]*db.PayoutItem{}
+	for _, item := range items {
+		if item.State == db.WithdrawSigned {
+			signed[item.Hash] = append(signed[item.Hash], item)
+		}
+	}
+	for hash, list := range signed {
+		if _, err := engine.EWorker.GetTransactionByHash(hash); err == nil {
+			broadcastPayoutItems(p, nil, list...)
+			continue
+		}
+		signTx := new(ethTypes.Transaction)
+		if err := signTx.UnmarshalBinary(db.GetPayoutRaw(p.BatchId, hash)); err != nil {
+			failPayoutItems(p, err, list...)
+			continue
+		}
+		var err error
+		if p.Mode == engine.PayoutDisperse {
+			_, err = engine.EWorker.BroadcastTransfer(signTx, disperseAddress, signTx.Value(), "", p.Tier)
+		} else {
+			value, _ := new(big.Int).SetString(list[0].Value, 10)
+			_, err = engine.EWorker.BroadcastTransfer(signTx, list[0].To, value, list[0].CoinName, p.Tier)
+		}
+		broadcastPayoutItems(p, err, list...)
+	}
+}
+
+// signPayoutItems 广播前落地签名后的交易 落地失败时归还 nonce 不发送
+func signPayoutItems(p *db.Payout, signTx *ethTypes.Transaction, list ...*db.PayoutItem) bool {
+	raw, _ := signTx.MarshalBinary()
+	for _, item := range list {
+		item.Hash = signTx.Hash().Hex()
+		item.Nonce = signTx.Nonce()
+		_ = item.Transit(db.WithdrawSigned)
+	}
+	if err := db.SavePayoutRaw(p.BatchId, signTx.Hash().Hex(), raw); err != nil {
+		engine.Nonces.Release(common.HexToAddress(p.From), signTx.Nonce())
+		failPayoutItems(p, err, list...)
+		return false
+	}
+	if err := db.UpDataPayoutItems(p.BatchId, list...); err != nil {
+		engine.Nonces.Release(common.HexToAddress(p.From), signTx.Nonce())
+		return false
+	}
+	return true
+}
+
+// broadcastPayoutItems 记录广播结果
+func broadcastPayoutItems(p *db.Payout, err error, list ...*db.PayoutItem) {
+	if err != nil && engine.BroadcastRejected(err) {
+		failPayoutItems(p, err, list...)
+		return
+	}
+	for _, item := range list {
+		// 超时等错误节点可能已经收到交易 按已广播处理 以区块监听的结果为准
+		if err != nil {
+			item.Error = err.Error()
+		}
+		_ = item.Transit(db.WithdrawBroadcast)
+	}
+	db.UpDataPayoutItems(p.BatchId, list...)
+}
+
+// failPayoutItems 明细失败 记录原因
+func failPayoutItems(p *db.Payout, err error, list ...*db.PayoutItem) {
+	log.Error().Msgf("payout %s err is %s ", p.BatchId, err.Error())
+	for _, item := range list {
+		item.Error = err.Error()
+		_ = item.Transit(db.WithdrawFailed)
+	}
+	db.UpDataPayoutItems(p.BatchId, list...)
+}
+
+// confirmPayout 批量打款的交易上链 更新共用这笔交易的明细
+func confirmPayout(ts *types.Transaction) {
+	batchId := db.GetPayoutByHash(ts.Hash)
+	if batchId == "" {
+		return
+	}
+	state, reason := db.WithdrawConfirmed, ""
+	if ts.Status == 0 {
+		state, reason = db.WithdrawFailed, "transaction execution failed"
+	} else if isCancelTx(ts) {
+		state, reason = db.WithdrawFailed, "transaction cancelled"
+	}
+	done := []*db.PayoutItem{}
+	for _, item := range db.GetPayoutItems(batchId) {
+		if !item.HasHash(ts.Hash) || item.Transit(state) != nil {
+			continue
+		}
+		// 加速前的交易先打包时 以打包的交易为准
+		item.Hash = ts.Hash
+		item.BlockNumber = blockNumber(ts)
+		item.Error = reason
+		done = append(done, item)
+	}
+	db.UpDataPayoutItems(batchId, done...)
+}
+
+// previewPayout 模拟每条明细 预估手续费并检查热钱包余额 values 为每条明细最小单位的数量 metas 为各币种的元数据
+func previewPayout(pR *PayoutReq, values []*big.Int, metas map[string]*db.TokenMeta) (*PayoutPreviewRes, error) {
+	est, err := engine.EWorker.GetGasPrice()
+	if err != nil {
+		return nil, err
+	}
+	fee := est.Tier(pR.Tier)
+	res := &PayoutPreviewRes{From: hotWallet, Mode: pR.Mode, Tier: pR.Tier, Items: []*PayoutItemRes{}}
+	coins := []string{}
+	totals := map[string]*big.Int{}
+	for i, v := range pR.Items {
+		item := &PayoutItemRes{Index: i, To: v.To, CoinName: v.CoinName, Value: values[i].String()}
+		item.setValue(metas[v.CoinName])
+		res.Items = append(res.Items, item)
+		gas, err := engine.EWorker.EstimateTransfer(hotWallet, v.To, values[i], v.CoinName)
+		if err != nil {
+			item.Error = err.Error()
+			res.Failed++
+			continue
+		}
+		item.Gas = gas
+		res.Gas += gas
+		if _, ok := totals[v.CoinName]; !ok {
+			coins = append(coins, v.CoinName)
+			totals[v.CoinName] = big.NewInt(0)
+		}
+		totals[v.CoinName].Add(totals[v.CoinName], values[i])
+	}
+	if pR.Mode == engine.PayoutDisperse {
+		// 合约批量发送省去每笔的基础 gas 代币授权前无法模拟 按逐笔发送的 gas 作为上限
+		if gas, ok := previewDisperseGas(pR, values, coins); ok {
+			res.Gas = gas
+		}
+	}
+	maxFee := new(big.Int).Mul(new(big.Int).SetUint64(res.Gas), fee.MaxFeePerGas)
+	expected := new(big.Int).Add(est.BaseFee, fee.MaxPriorityFeePerGas)
+	expected.Mul(expected, new(big.Int).SetUint64(res.Gas))
+	res.MaxFee, res.ExpectedFee = maxFee.String(), expected.String()
+
+	// 原生币需要同时支付手续费
+	if _, ok := totals[""]; !ok {
+		coins = append(coins, "")
+		totals[""] = big.NewInt(0)
+	}
+	totals[""].Add(totals[""], maxFee)
+	res.Sufficient = true
+	for _, coin := range coins {
+		c := &PayoutCoinRes{CoinName: coin, Total: totals[coin].String(), Balance: "0"}
+		if balance, err := engine.EWorker.GetBalance(hotWallet, coin); err == nil && balance != nil {
+			c.Balance = balance.String()
+			c.Sufficient = balance.Cmp(totals[coin]) >= 0
+		}
+		res.Sufficient = res.Sufficient && c.Sufficient
+		res.Coins = append(res.Coins, c)
+	}
+	return res, nil
+}
+
+// previewDisperseGas 模拟 Disperse 合约调用的 gas 有一笔无法模拟时返回 false
+func previewDisperseGas(pR *PayoutReq, values []*big.Int, coins []string) (uint64, bool) {
+	total := uint64(0)
+	for _, coin := range coins {
+		recipients, amounts := []common.Address{}, []*big.Int{}
+		for i, v := range pR.Items {
+			if v.CoinName == coin {
+				recipients = append(recipients, common.HexToAddress(v.To))
+				amounts = append(amounts, values[i])
+			}
+		}
+		for start := 0; start < len(recipients); start += engine.DisperseChunk {
+			end := start + engine.DisperseChunk
+			if end > len(recipients) {
+				end = len(recipients)
+			}
+			value, data, err := engine.DisperseCall(coin, recipients[start:end], amounts[start:end])
+			if err != nil {
+				return 0, false
+			}
+			gas, err := engine.EWorker.EstimateCall(hotWallet, disperseAddress, value, data)
+			if err != nil {
+				return 0, false
+			}
+			total += gas
+		}
+	}
+	return total, true
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"encoding/json"
+
 	"github.com/lmxdawn/wallet/types"
 )
 
@@ -16,18 +18,54 @@ type DelWalletReq struct {
 }
 
 type WithdrawReq struct {
-	Protocol string `json:"protocol" binding:"required"` // 协议
-	CoinName string `json:"coinName" binding:"required"` // 币种名称
-	OrderId  string `json:"orderId" binding:"required"`  // 订单号
-	Address  string `json:"address" binding:"required"`  // 提现地址
-	Value    int64  `json:"value" binding:"required"`    // 金额
+	Protocol string `json:"protocol" binding:"required"`                     // 协议
+	CoinName string `json:"coinName"`                                        // 币种合约地址 为空表示原生币
+	OrderId  string `json:"orderId" binding:"required"`                      // 订单号 相同订单号只会提现一次
+	Address  string `json:"address" binding:"required"`                      // 提现地址
+	Value    string `json:"value" binding:"required"`                        // 金额 展示单位的十进制数 比如 0.5 按币种精度转成最小单位
+	Tier     string `json:"tier" binding:"omitempty,oneof=slow normal fast"` // 费用档位 为空使用 normal
+}
+
+// PayoutReq 批量打款
+type PayoutReq struct {
+	BatchId string          `json:"batchId" binding:"required"`                         // 批次号 相同批次号只会发送一次
+	Mode    string          `json:"mode" binding:"omitempty,oneof=sequential disperse"` // sequential 逐笔发送 disperse 合约批量发送 为空使用 sequential
+	Tier    string          `json:"tier" binding:"omitempty,oneof=slow normal fast"`    // 费用档位 为空使用 normal
+	Preview bool            `json:"preview"`                                            // 只预估费用和检查余额 不发送
+	Items   []PayoutItemReq `json:"items" binding:"required,min=1,max=500,dive"`        // 打款明细
+}
+
+// PayoutItemReq 一条打款明细
+type PayoutItemReq struct {
+	To       string `json:"to" binding:"required"`    // 接收方
+	CoinName string `json:"coinName"`                 // 币种合约地址 为空表示原生币
+	Value    string `json:"value" binding:"required"` // 金额 展示单位的十进制数 按币种精度转成最小单位
 }
 
+// GetPayoutReq 查询批量打款
+type GetPayoutReq struct {
+	BatchId string `form:"batchId" binding:"required"` // 批次号
+}
+
+// GetWithdrawReq 查询提现订单
+type GetWithdrawReq struct {
+	OrderId string `form:"orderId" binding:"required"` // 订单号
+}
+
+// CollectionReq 手动归集 max 为空时按配置归集所有币种 不为空时只归集 coinName（为空表示原生币）
 type CollectionReq struct {
-	Protocol string `json:"protocol" binding:"required"` // 协议
-	CoinName string `json:"coinName" binding:"required"` // 币种名称
-	Address  string `json:"address" binding:"required"`  // 地址
-	Max      string `json:"max" binding:"required"`      // 最大归集数量（满足当前值才会归集）
+	CoinName string `json:"coinName"`                        // 币种合约地址
+	Address  string `json:"address"`                         // 只归集这个地址 为空表示所有地址
+	Max      string `json:"max" binding:"omitempty,numeric"` // 归集阈值（满足当前值才会归集）
+	Preview  bool   `json:"preview"`                         // 只计算不发送
+}
+
+type GetGasSpentReq struct {
+	Day string `form:"day" binding:"omitempty,datetime=2006-01-02"` // 日期（UTC）默认当天
+}
+
+type GetCollectionReq struct {
+	Limit int64 `form:"limit" binding:"omitempty,min=1,max=100"` // 最近多少次 默认 10
 }
 
 type TransactionReceiptReq struct {
@@ -47,7 +85,7 @@ type AddNewCoinReq struct {
 	Protocol        string `json:"protocol" `                          // 指定要获取的链名称
 	ContractAddress string `json:"contractAddress" binding:"required"` // 指定新币的合约地址
 	UserAddress     string `json:"userAddress" binding:"required"`     // 用户的钱包地址
-	CoinName        string `json:"coinName" `                          // 币种名称
+	CoinName        string `json:"coinName" `                          // 已废弃 币种名称以合约的 symbol 为准
 }
 
 // GetBalanceReq 获取账户余额信息
@@ -55,31 +93,42 @@ type GetBalanceReq struct {
 	// Protocol    string `json:"protocol" `                      // 指定要获取的链名称 应该用这个给 要知道现在这个用户要查哪条链上的数据
 	UserAddress string `json:"userAddress" binding:"required"` // 用户的钱包地址
 	CoinName    string `json:"coinName" `                      // 币种名称
+	Block       string `json:"block"`                          // 区块 数字或 earliest latest pending safe finalized 为空表示 latest
 	//ChainID     uint32 `json:"chainID"`                        // 链ID
 }
 
 // GetWalletActivity 获取钱包活动信息 交易记录
 type GetWalletActivity struct {
-	Protocol    string `json:"protocol" `                      // 指定要获取的链名称 应该用这个给 要知道现在这个用户要查哪条链上的数据
-	UserAddress string `json:"userAddress" binding:"required"` // 用户的钱包地址
-	CoinName    string `json:"coinName" `                      // 币种名称
+	Protocol    string  `json:"protocol" `                                  // 指定要获取的链名称 应该用这个给 要知道现在这个用户要查哪条链上的数据
+	UserAddress string  `json:"userAddress" binding:"required"`             // 用户的钱包地址
+	CoinName    *string `json:"coinName" `                                  // 币种合约地址 空字符串表示原生币 不传表示所有币种
+	Direction   string  `json:"direction" binding:"omitempty,oneof=in out"` // in 转入 out 转出 不传表示全部
+	Status      *int32  `json:"status" binding:"omitempty,oneof=0 1 2"`     // 交易状态 0 失败 1 成功 2 等待
+	StartTime   int64   `json:"startTime" `                                 // 开始时间 毫秒级时间戳
+	EndTime     int64   `json:"endTime" `                                   // 结束时间 毫秒级时间戳 不包含
+	Cursor      string  `json:"cursor" `                                    // 上一页返回的 nextCursor 不传表示第一页
+	Limit       int64   `json:"limit" binding:"omitempty,min=1,max=100"`    // 每页数量 默认 100
 }
 
 // SendTransaction 发起一笔交易
 type SendTransaction struct {
 	//Protocol string `json:"protocol"`                // 指定要获取的链名称 应该用这个给 要知道现在这个用户要查哪条链上的数据
-	From     string `json:"from" binding:"required"` // 用户的钱包地址
-	CoinName string `json:"coinName"`                // 币种名称 为空表示原生币
-	To       string `json:"to" binding:"required"`   // 接收者
-	Num      string `json:"num" binding:"required"`  // 数量
+	From     string `json:"from" binding:"required"`                         // 用户的钱包地址
+	CoinName string `json:"coinName"`                                        // 币种名称 为空表示原生币
+	To       string `json:"to" binding:"required"`                           // 接收者
+	Num      string `json:"num" binding:"required"`                          // 数量 展示单位的十进制数 比如 0.5 按币种精度转成最小单位
+	Tier     string `json:"tier" binding:"omitempty,oneof=slow normal fast"` // 费用档位 为空使用 normal
+	SignOnly bool   `json:"signOnly"`                                        // 只签名不广播 返回签名后的交易
 }
 
 // NftTransaction NFT交易
 type NftTransaction struct {
-	From            string `json:"from" binding:"required"`            // 用户的钱包地址
-	To              string `json:"to" binding:"required"`              // 接收者
-	ContractAddress string `json:"contractAddress" binding:"required"` // NFT合约地址
-	TokenID         string `json:"tokenID" binding:"required"`         // NFT的ID
+	From            string `json:"from" binding:"required"`                         // 用户的钱包地址
+	To              string `json:"to" binding:"required"`                           // 接收者
+	ContractAddress string `json:"contractAddress" binding:"required"`              // NFT合约地址
+	TokenID         string `json:"tokenID" binding:"required"`                      // NFT的ID
+	Tier            string `json:"tier" binding:"omitempty,oneof=slow normal fast"` // 费用档位 为空使用 normal
+	SignOnly        bool   `json:"signOnly"`                                        // 只签名不广播 返回签名后的交易
 }
 
 // CheckTransReq 检查交易是否成功
@@ -119,6 +168,12 @@ type LoginReq struct {
 	PassWD  string `json:"passWD" binding:"required"`  // 传入的密码
 }
 
+// SiweLoginReq 以太坊登录请求
+type SiweLoginReq struct {
+	Message   string `json:"message" binding:"required"`   // EIP-4361 登录消息
+	Signature string `json:"signature" binding:"required"` // 钱包对登录消息的 personal_sign 签名
+}
+
 // RegisterReq 注册请求
 type RegisterReq struct {
 	Account string `json:"account" binding:"required"` // 登录账户
@@ -132,14 +187,45 @@ type SpeedUpReq struct {
 }
 
 type CallContractReq struct {
-	From                 string `json:"from" binding:"required"` // 钱包地址
-	To                   string `json:"to" binding:"required"`   // 合约地址
-	Data                 string `json:"data" `                   // 数据
-	Value                string `json:"value"`                   // 金额
-	Gas                  string `json:"gasLimit" `               // gas
-	GasPrice             string `json:"gasPrice" `               // gasPrice
-	MaxFeePerGas         string `json:"maxFeePerGas" `           // maxFeePerGas
-	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas" `   // maxProfitGas
+	From                 string            `json:"from" binding:"required"`                         // 钱包地址
+	To                   string            `json:"to"`                                              // 合约地址 为空表示部署合约
+	Data                 string            `json:"data" `                                           // 数据
+	Value                string            `json:"value"`                                           // 金额
+	Gas                  string            `json:"gasLimit" `                                       // gas
+	GasPrice             string            `json:"gasPrice" `                                       // gasPrice
+	MaxFeePerGas         string            `json:"maxFeePerGas" `                                   // maxFeePerGas
+	MaxPriorityFeePerGas string            `json:"maxPriorityFeePerGas" `                           // maxProfitGas
+	Tier                 string            `json:"tier" binding:"omitempty,oneof=slow normal fast"` // 费用档位 为空使用 normal
+	SignOnly             bool              `json:"signOnly"`                                        // 只签名不广播 返回签名后的交易
+	Block                string            `json:"block"`                                           // 只读调用和预估使用的区块 为空表示 latest
+	Method               string            `json:"method"`                                          // 方法名或者签名 传入时按上传的 ABI 和 args 生成 data
+	Args                 []json.RawMessage `json:"args"`                                            // 方法的参数 按 ABI 的顺序
+	Network              string            `json:"network"`                                         // ABI 所在的网络 为空表示本服务连接的网络
+}
+
+// AddContractAbiReq 上传合约的 ABI 已经存在时覆盖
+type AddContractAbiReq struct {
+	Network string          `json:"network"`                    // 网络名称 为空表示本服务连接的网络
+	Address string          `json:"address" binding:"required"` // 合约地址
+	Name    string          `json:"name"`                       // 合约名称
+	Abi     json.RawMessage `json:"abi" binding:"required"`     // ABI 数组 也可以是 ABI 的 JSON 字符串
+}
+
+// DelContractAbiReq 删除合约的 ABI
+type DelContractAbiReq struct {
+	Network string `json:"network"`                    // 网络名称 为空表示本服务连接的网络
+	Address string `json:"address" binding:"required"` // 合约地址
+}
+
+// GetContractAbiReq 查询上传的 ABI address 为空时返回网络上所有的
+type GetContractAbiReq struct {
+	Network string `form:"network"` // 网络名称 为空表示本服务连接的网络
+	Address string `form:"address"` // 合约地址
+}
+
+// SendRawTransactionReq 广播已签名的交易
+type SendRawTransactionReq struct {
+	Raw string `json:"raw" binding:"required"` // RLP 编码的签名交易
 }
 
 type CancelReq struct {
@@ -147,11 +233,23 @@ type CancelReq struct {
 	TxHash  string `json:"txHash" binding:"required"`  // 交易哈希
 }
 
+// SignTypeDataV4Req 类型数据签名 v3 v4 使用 types primaryType domain message v1 使用 data
 type SignTypeDataV4Req struct {
-	//From string `json:"from" binding:"required"` // 钱包地址
+	From    string                       `json:"from" binding:"required"`                    // 签名的钱包地址
+	Version string                       `json:"version" binding:"omitempty,oneof=v1 v3 v4"` // 签名版本 为空使用 v4
+	Data    []types.LegacyTypedDataField `json:"data"`                                       // v1 的字段列表
 	types.TypedData
 }
 
+// VerifySignatureReq 验证签名 传 typedData 时按 EIP-712 传 data 时按 v1 否则按 personal_sign 验证 message
+type VerifySignatureReq struct {
+	Address   string                       `json:"address" binding:"required"`   // 期望的签名地址
+	Signature string                       `json:"signature" binding:"required"` // 签名
+	Message   string                       `json:"message"`                      // personal_sign 的消息 16 进制按字节 否则按 utf8
+	TypedData *types.TypedData             `json:"typedData"`                    // EIP-712 类型数据 v3 v4
+	Data      []types.LegacyTypedDataField `json:"data"`                         // eth_signTypedData（v1）的字段列表
+}
+
 // AddNFTReq 向钱中加入 NFT
 type AddNFTReq struct {
 	UserAddress     string `json:"userAddress" binding:"required"` // 用户的钱包地址
@@ -166,6 +264,53 @@ type GetTransactionByHashReq struct {
 type EstimateGasReq struct {
 }
 type GetBlockByNumberReq struct {
-	Number string `json:"blockNumber" binding:"required"`
-	IsFull bool   `json:"flag"`
+	Number string `json:"blockNumber"` // 区块 16 进制或 10 进制的数字 或 earliest latest pending safe finalized 为空表示 latest
+	IsFull bool   `json:"flag"`        // true 返回完整的交易 false 只返回交易哈希
+}
+
+// GetLogsReq 查询日志 字段和 eth_getLogs 的过滤条件一致
+type GetLogsReq struct {
+	FromBlock string            `json:"fromBlock"` // 起始区块 为空表示 latest
+	ToBlock   string            `json:"toBlock"`   // 结束区块 为空表示 latest
+	BlockHash string            `json:"blockHash"` // 区块哈希 不为空时忽略区块范围
+	Address   json.RawMessage   `json:"address"`   // 合约地址 一个地址或者地址数组
+	Topics    []json.RawMessage `json:"topics"`    // 每个位置为 null 一个 topic 或者 topic 数组
+}
+
+// ReplayNotifyReq 重新投递通知
+type ReplayNotifyReq struct {
+	Ids []string `json:"ids" binding:"required"` // 事件ID
+}
+
+// CreateWebhookReq 新建通知订阅
+type CreateWebhookReq struct {
+	Url     string   `json:"url" binding:"required,url"`                                                                 // 回调地址
+	Events  []string `json:"events" binding:"required,min=1,dive,oneof=deposit withdrawal nft_transfer tx_failed reorg"` // 订阅的事件类型
+	Wallets []string `json:"wallets"`                                                                                    // 只通知这些钱包 为空表示账户下所有钱包
+	Coins   []string `json:"coins"`                                                                                      // 只通知这些币种的合约地址 为空表示所有币种
+}
+
+// UpdateWebhookReq 修改通知订阅 未传的字段不修改
+type UpdateWebhookReq struct {
+	Id       string   `json:"id" binding:"required"`
+	Url      string   `json:"url" binding:"omitempty,url"`
+	Events   []string `json:"events" binding:"omitempty,min=1,dive,oneof=deposit withdrawal nft_transfer tx_failed reorg"`
+	Wallets  []string `json:"wallets"`
+	Coins    []string `json:"coins"`
+	Disabled *bool    `json:"disabled"` // 暂停或恢复投递
+}
+
+// WebhookIdReq 指定通知订阅
+type WebhookIdReq struct {
+	Id string `json:"id" form:"id" binding:"required"`
+}
+
+// NonceReq 查询或修复地址的 nonce
+type NonceReq struct {
+	Address string `json:"address" form:"address" binding:"required"` // 钱包地址
+}
+
+// GetPendingReq 查询还没有打包的交易
+type GetPendingReq struct {
+	Address string `form:"address"` // 钱包地址 为空表示账户下所有钱包
 }
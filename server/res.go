@@ -1,7 +1,11 @@
 package server
 
 import (
+	"math/big"
+
 	"github.com/gin-gonic/gin"
+	"github.com/lmxdawn/wallet/db"
+	"github.com/lmxdawn/wallet/engine"
 	"github.com/lmxdawn/wallet/types"
 	"net/http"
 )
@@ -26,6 +30,15 @@ func APIResponse(Ctx *gin.Context, err error, data interface{}) {
 	})
 }
 
+// APISendResponse 发送交易的响应 模拟执行回滚时 data 返回解析后的回滚原因
+func APISendResponse(Ctx *gin.Context, err error, data interface{}) {
+	if rev, ok := err.(*engine.RevertError); ok {
+		APIResponse(Ctx, ErrTxReverted, rev)
+		return
+	}
+	APIResponse(Ctx, err, data)
+}
+
 // CreateWalletRes ...
 type CreateWalletRes struct {
 	Address string `json:"address"` // 生成的钱包地址
@@ -33,12 +46,122 @@ type CreateWalletRes struct {
 
 // WithdrawRes ...
 type WithdrawRes struct {
-	Hash string `json:"hash"` // 生成的交易hash
+	OrderId        string `json:"orderId"`                  // 订单号
+	State          string `json:"state"`                    // 订单状态 created signed broadcast confirmed failed
+	Hash           string `json:"hash"`                     // 生成的交易hash
+	From           string `json:"from"`                     // 热钱包地址
+	Address        string `json:"address"`                  // 提现地址
+	CoinName       string `json:"coinName"`                 // 币种合约地址 为空表示原生币
+	Value          string `json:"value"`                    // 最小单位的金额
+	FormattedValue string `json:"formattedValue,omitempty"` // 按精度格式化的金额
+	Symbol         string `json:"symbol,omitempty"`
+	Nonce          uint64 `json:"nonce"`       // 交易的 nonce
+	BlockNumber    uint64 `json:"blockNumber"` // 上链的区块
+	Error          string `json:"error"`       // 失败原因
+	CreatedAt      int64  `json:"createdAt"`
+	UpdatedAt      int64  `json:"updatedAt"`
 }
 
-// CollectionRes ...
+// PayoutRes 批量打款的状态
+type PayoutRes struct {
+	BatchId   string           `json:"batchId"`
+	Mode      string           `json:"mode"`
+	From      string           `json:"from"`      // 热钱包地址
+	State     string           `json:"state"`     // 批次状态 created sending sent
+	Total     int              `json:"total"`     // 明细数量
+	Pending   int              `json:"pending"`   // 还没有广播的数量
+	Broadcast int              `json:"broadcast"` // 已广播等待确认的数量
+	Confirmed int              `json:"confirmed"` // 上链成功的数量
+	Failed    int              `json:"failed"`    // 失败的数量
+	Items     []*PayoutItemRes `json:"items"`
+}
+
+// PayoutItemRes 一条打款明细的状态
+type PayoutItemRes struct {
+	Index          int    `json:"index"`
+	To             string `json:"to"`
+	CoinName       string `json:"coinName"`
+	Value          string `json:"value"`                    // 最小单位的金额
+	FormattedValue string `json:"formattedValue,omitempty"` // 按精度格式化的金额
+	Symbol         string `json:"symbol,omitempty"`
+	State          string `json:"state"` // created signed broadcast confirmed failed
+	Hash           string `json:"hash"`
+	Nonce          uint64 `json:"nonce"`
+	Gas            uint64 `json:"gas,omitempty"` // 预估的 gas 只在预览时返回
+	Error          string `json:"error"`         // 失败原因
+	BlockNumber    uint64 `json:"blockNumber"`
+}
+
+// PayoutPreviewRes 批量打款的费用预估
+type PayoutPreviewRes struct {
+	From        string           `json:"from"` // 热钱包地址
+	Mode        string           `json:"mode"`
+	Tier        string           `json:"tier"`
+	Gas         uint64           `json:"gas"`         // 预估的 gas 总量
+	MaxFee      string           `json:"maxFee"`      // 按 maxFeePerGas 计算的最高手续费
+	ExpectedFee string           `json:"expectedFee"` // 按下一个区块 baseFee 加小费计算的手续费
+	Sufficient  bool             `json:"sufficient"`  // 余额是否足够
+	Failed      int              `json:"failed"`      // 模拟执行失败的明细数量
+	Coins       []*PayoutCoinRes `json:"coins"`
+	Items       []*PayoutItemRes `json:"items"`
+}
+
+// PayoutCoinRes 每个币种的总额和热钱包余额 原生币的总额包含最高手续费
+type PayoutCoinRes struct {
+	CoinName   string `json:"coinName"`
+	Total      string `json:"total"`
+	Balance    string `json:"balance"`
+	Sufficient bool   `json:"sufficient"`
+}
+
+// CollectionRes 一次归集的报告
 type CollectionRes struct {
-	Balance string `json:"balance"` // 实际归集的数量
+	Id         string               `json:"id"`
+	Trigger    string               `json:"trigger"` // schedule 定时 manual 手动
+	Preview    bool                 `json:"preview"`
+	To         string               `json:"to"` // 归集地址
+	Tier       string               `json:"tier"`
+	StartedAt  int64                `json:"startedAt"`
+	FinishedAt int64                `json:"finishedAt"`
+	Totals     []*CollectionCoinRes `json:"totals"`
+	Items      []*CollectionItemRes `json:"items"`
+}
+
+// CollectionCoinRes 每个币种的归集总额
+type CollectionCoinRes struct {
+	CoinName string `json:"coinName"`
+	Balance  string `json:"balance"` // 实际归集的数量
+	Count    int    `json:"count"`
+}
+
+// CollectionItemRes 一个地址一个币种的归集结果
+type CollectionItemRes struct {
+	Address  string `json:"address"`
+	CoinName string `json:"coinName"`
+	Balance  string `json:"balance"`
+	Amount   string `json:"amount"` // 原生币已扣除最高手续费
+	GasFee   string `json:"gasFee"`
+	TopUp    string `json:"topUp,omitempty"` // 加油站补的原生币
+	Hash     string `json:"hash"`
+	Error    string `json:"error,omitempty"`
+}
+
+// GasSpentRes 加油站一天补的手续费
+type GasSpentRes struct {
+	Day       string        `json:"day"`
+	Wallet    string        `json:"wallet"`  // 加油钱包地址
+	Balance   string        `json:"balance"` // 加油钱包当前余额
+	Budget    string        `json:"budget"`  // 每天的预算 为空表示不限制
+	Spent     string        `json:"spent"`
+	Remaining string        `json:"remaining"`
+	Coins     []*GasCoinRes `json:"coins"`
+}
+
+// GasCoinRes 为了转出某个代币补的手续费
+type GasCoinRes struct {
+	CoinName string `json:"coinName"`
+	Value    string `json:"value"`
+	Count    int    `json:"count"`
 }
 
 // TransactionReceiptRes ...
@@ -48,24 +171,100 @@ type TransactionReceiptRes struct {
 
 // LinkStatus 链上状态 gas gasPrice
 type LinkStatus struct {
-	SuggestPrice string `json:"suggestPrice"` // gasPrice
-	BasePrice    string `json:"basePrice"`
+	SuggestPrice string      `json:"suggestPrice"` // normal 档位的 maxFeePerGas
+	BasePrice    string      `json:"basePrice"`    // 下一个区块的 baseFee
+	BlockNumber  uint64      `json:"blockNumber"`  // 预估基于的区块
+	BlockTime    float64     `json:"blockTime"`    // 平均出块时间 秒
+	Slow         *FeeTierRes `json:"slow"`
+	Normal       *FeeTierRes `json:"normal"`
+	Fast         *FeeTierRes `json:"fast"`
+}
+
+// FeeTierRes 一个档位的费用
+type FeeTierRes struct {
+	MaxFeePerGas         string  `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string  `json:"maxPriorityFeePerGas"`
+	Wait                 float64 `json:"wait"` // 预计打包时间 秒
 }
 
 type GetBalanceRes struct {
-	Balance string `json:"balance"`
+	Balance   string `json:"balance"`             // 最小单位的余额
+	Formatted string `json:"formatted,omitempty"` // 按精度格式化的余额 没有代币信息时为空
+	Symbol    string `json:"symbol,omitempty"`
+	Decimals  uint8  `json:"decimals"`
 }
 
 // SendTransactionRes 执行交易回执
 type SendTransactionRes struct {
-	FromHex string `json:"fromHex"`
-	SignHax string `json:"signHax"`
-	Nonce   uint64 `json:"nonce"`
+	FromHex        string `json:"fromHex"`
+	SignHax        string `json:"signHax"`
+	Nonce          uint64 `json:"nonce"`
+	Raw            string `json:"raw,omitempty"`            // 只签名时返回 RLP 编码的签名交易
+	NonceReserved  *bool  `json:"nonceReserved,omitempty"`  // 只签名时为 false nonce 没有被占用 广播前可能被其他交易使用
+	Value          string `json:"value,omitempty"`          // 转账的最小单位数量
+	FormattedValue string `json:"formattedValue,omitempty"` // 按精度格式化的数量
+	Symbol         string `json:"symbol,omitempty"`
+}
+
+// setValue 记录转账的数量 meta 为币种的元数据
+func (r *SendTransactionRes) setValue(value *big.Int, meta *db.TokenMeta) {
+	r.Value = value.String()
+	r.FormattedValue = engine.FormatUnits(value, meta.Decimals)
+	r.Symbol = meta.Symbol
+}
+
+// formatValue 最小单位的十进制数量按币种精度格式化 没有元数据的代币或者数量不合法返回空
+func formatValue(value string, meta *db.TokenMeta) string {
+	if meta == nil {
+		return ""
+	}
+	v, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return ""
+	}
+	return engine.FormatUnits(v, meta.Decimals)
+}
+
+// setValue 按币种精度补上格式化的金额 meta 为 nil 时只返回最小单位
+func (r *WithdrawRes) setValue(meta *db.TokenMeta) {
+	if r.FormattedValue = formatValue(r.Value, meta); r.FormattedValue != "" {
+		r.Symbol = meta.Symbol
+	}
+}
+
+// setValue 按币种精度补上格式化的金额 meta 为 nil 时只返回最小单位
+func (r *PayoutItemRes) setValue(meta *db.TokenMeta) {
+	if r.FormattedValue = formatValue(r.Value, meta); r.FormattedValue != "" {
+		r.Symbol = meta.Symbol
+	}
 }
 
 type WalletActivityRes struct {
-	UserAddress string `json:"userAddress"`
-	History     []*types.Transaction
+	UserAddress string         `json:"userAddress"`
+	History     []*ActivityRes `json:"history"`
+	NextCursor  string         `json:"nextCursor"` // 下一页的游标 为空表示没有更多
+}
+
+// ActivityRes 一条交易记录
+type ActivityRes struct {
+	Hash            string `json:"hash"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Value           string `json:"value"`                    // 最小单位的数量
+	FormattedValue  string `json:"formattedValue,omitempty"` // 按精度格式化的数量 没有代币信息时为空
+	Symbol          string `json:"symbol,omitempty"`
+	Decimals        uint8  `json:"decimals"`
+	CoinName        string `json:"coinName"`  // 币种合约地址 为空表示原生币
+	Direction       string `json:"direction"` // in 转入 out 转出
+	Status          int32  `json:"status"`    // 0 失败 1 成功 2 等待
+	TimeStamp       string `json:"timeStamp"` // 毫秒级时间戳
+	BlockNumber     uint64 `json:"blockNumber"`
+	LogIndex        uint   `json:"logIndex"`
+	Action          string `json:"action"`
+	ContractAddress string `json:"contractAddress"`
+	Replaces        string `json:"replaces"`   // 加速或取消的原交易
+	ReplacedBy      string `json:"replacedBy"` // 替换了这笔交易的交易
+	Note            string `json:"note"`       // replaced by 替换交易 或 cancelled
 }
 
 type History struct {
@@ -102,3 +301,68 @@ type HistoryRes struct {
 	GasUsed           string `json:"gasUsed"`
 	Confirmations     string `json:"confirmations"`
 }
+
+// WebhookRes 通知订阅 密钥只在新建和轮换时返回
+type WebhookRes struct {
+	Id              string   `json:"id"`
+	Url             string   `json:"url"`
+	Events          []string `json:"events"`
+	Wallets         []string `json:"wallets"`
+	Coins           []string `json:"coins"`
+	Secret          string   `json:"secret,omitempty"`
+	OldSecretExpire int64    `json:"oldSecretExpire,omitempty"` // 旧密钥停止签名的时间
+	Disabled        bool     `json:"disabled"`
+	CreatedAt       int64    `json:"createdAt"`
+}
+
+// NonceRes 地址的 nonce 分配状态
+type NonceRes struct {
+	Address string `json:"address"`
+	*db.NonceState
+	Gaps []uint64 `json:"gaps"` // 需要填补的 nonce
+}
+
+// PendingTxRes 还没有打包的交易
+type PendingTxRes struct {
+	Hash      string   `json:"hash"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Nonce     uint64   `json:"nonce"`
+	Value     string   `json:"value"`
+	Action    string   `json:"action"`
+	Age       int64    `json:"age"` // 广播后经过的秒数
+	GasFeeCap string   `json:"maxFeePerGas"`
+	GasTipCap string   `json:"maxPriorityFeePerGas"`
+	BaseFee   string   `json:"baseFee"` // 最新区块的 baseFee
+	FeeGap    string   `json:"feeGap"`  // 按当前 baseFee 和建议小费还差的 maxFeePerGas 小于等于0表示足够
+	Bumps     int      `json:"bumps"`   // 已经自动加速的次数
+	Stuck     bool     `json:"stuck"`
+	Actions   []string `json:"actions"` // 可以执行的操作 speedUp cancel
+}
+
+// SiweNonceRes 以太坊登录的 nonce
+type SiweNonceRes struct {
+	Nonce  string `json:"nonce"`  // 放入登录消息的 nonce
+	Domain string `json:"domain"` // 登录消息需要使用的域名
+}
+
+// SiweLoginRes 以太坊登录的结果 之后的请求在请求头 Account 中带上账户
+type SiweLoginRes struct {
+	Account    string   `json:"account"`    // 登录的账户
+	Address    string   `json:"address"`    // 绑定的地址
+	WalletList []string `json:"walletList"` // 账户下的钱包地址
+}
+
+// VerifySignatureRes 签名验证的结果
+type VerifySignatureRes struct {
+	Valid  bool   `json:"valid"`            // 签名是否有效
+	Signer string `json:"signer"`           // ecrecover 恢复出的地址 合约钱包时和期望的地址不同
+	Method string `json:"method,omitempty"` // 验证方式 ecrecover 或者 eip1271
+	Hash   string `json:"hash"`             // 签名的哈希
+}
+
+// EthCallRes 按方法名调用合约的结果
+type EthCallRes struct {
+	Raw     string           `json:"raw"`     // 原始的返回数据
+	Outputs []types.AbiParam `json:"outputs"` // 按 ABI 解析出的返回值
+}
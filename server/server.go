@@ -10,19 +10,34 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// stuckPolicy 卡住交易的处理策略
+var stuckPolicy *engine.StuckPolicy
+
+// logPolicy 日志查询的分段策略
+var logPolicy *engine.LogPolicy
+
+var (
+	withdrawKey     string // 提现热钱包的私钥
+	hotWallet       string // 提现热钱包的地址
+	disperseAddress string // 批量打款合约
+	siweDomain      string // 以太坊登录消息中的域名
+	networkName     string // 本服务连接的网络名称 代币元数据按网络保存
+)
+
 // Start 启动服务
 func Start(isSwag bool, configPath string) {
 	db.Init()
+	go db.RebuildTransferIndex()
 	conf, err := config.NewConfig(configPath)
 
 	// TODO 链备份
 
-	// ----------- 币种监听初始化 -------------
-	CoinInit(conf.Engines[0].Rpc)
-	Init()
 	if err != nil || len(conf.Engines) == 0 {
 		panic("Failed to load configuration")
 	}
+	// ----------- 币种监听初始化 -------------
+	networkName = conf.Engines[0].Network
+	CoinInit(conf.Engines[0].Rpc)
 
 	// ----------- 链操作初始化 -------------
 	// 若要设置预设链 则
@@ -35,6 +50,29 @@ func Start(isSwag bool, configPath string) {
 	if err != nil {
 		log.Fatal().Msgf("NewNFTWorker err is %s ", err.Error())
 	}
+	// ----------- 提现热钱包 区块监听需要识别热钱包的交易 -------------
+	withdrawKey = conf.Engines[0].WithdrawPrivateKey
+	if withdrawKey != "" {
+		if hotWallet, err = engine.EWorker.GetAddressByPrivateKey(withdrawKey); err != nil {
+			log.Fatal().Msgf("withdraw_private_key err is %s ", err.Error())
+		}
+		// 热钱包发出的交易卡住时也自动加速
+		_ = engine.EWorker.AddServiceKey(withdrawKey)
+	}
+	disperseAddress = conf.Engines[0].DisperseAddress
+	engine.Abis = engine.NewAbiRegistry(conf.Engines[0].Network)
+	// ----------- 区块监听 依赖上面的 Worker -------------
+	startTokenDiscovery()
+	Init(conf.Engines[0])
+	startNotify(conf.Engines[0])
+	startGasStation(conf.Engines[0])
+	startCollection(conf.Engines[0])
+	stuckPolicy = engine.NewStuckPolicy(conf.Engines[0].StuckAfterTime, conf.Engines[0].MonitorAfterTime, conf.Engines[0].MaxBumps, conf.Engines[0].MaxFeeGwei)
+	go engine.EWorker.StartMonitor(stuckPolicy)
+	// 接收请求之前处理中断的提现订单 避免和重复请求同时处理
+	resumeWithdraws()
+	siweDomain = conf.App.SiweDomain
+	logPolicy = engine.NewLogPolicy(conf.Engines[0].LogsChunk, conf.Engines[0].LogsMaxBlocks)
 	server := gin.Default()
 	// 中间件
 	server.Use(Cors())
@@ -70,20 +108,58 @@ func Start(isSwag bool, configPath string) {
 		auth.POST("/importWallet", ImportWallet)
 		auth.POST("/cancel", Cancel)
 		auth.POST("/speedUp", SpeedUp)
+		auth.GET("/getPendingTransactions", GetPendingTransactions)
 		auth.POST("/personal_sign", PersonalSign)
 		auth.POST("/signTypedData_v4", SignTypeDataV4)
+		auth.POST("/eth_sendRawTransaction", SendRawTransaction)
+		// 通知订阅
+		auth.POST("/createWebhook", CreateWebhook)
+		auth.GET("/getWebhookList", GetWebhookList)
+		auth.POST("/updateWebhook", UpdateWebhook)
+		auth.POST("/delWebhook", DelWebhook)
+		auth.POST("/rotateWebhookSecret", RotateWebhookSecret)
+		auth.GET("/getWebhookDeliveries", GetWebhookDeliveries)
+		auth.POST("/testWebhook", TestWebhook)
+	}
+	admin := server.Group("/admin", AdminRequired(conf.App.AdminToken))
+	{
+		// 通知死信队列
+		admin.GET("/notify/dead", GetDeadNotify)
+		admin.POST("/notify/replay", ReplayNotify)
+		// 地址的 nonce 空洞
+		admin.GET("/nonce", GetNonce)
+		admin.POST("/nonce/repair", RepairNonce)
 	}
 	// 登录检测
 	server.POST("/login", Login)
 	server.POST("/register", Register)
+	server.GET("/siweNonce", SiweNonce)
+	server.POST("/siweLogin", SiweLogin)
+	server.POST("/verifySignature", VerifySignature)
 	server.POST("/callContract", CallContract)
 	server.POST("/getBalance", GetBalance)
 	server.POST("/eth_call", ETHCall)
 	server.POST("/eth_getBlockByNumber", GetBlockByNumber)
+	server.POST("/eth_getLogs", GetLogs)
 	server.POST("/eth_blocknumber", GetBlockNumber)
 	server.POST("/eth_getTransactionByHash", GetTransactionByHash)
 	server.POST("/eth_estimateGas", EstimateGas)
 	server.POST("/eth_gasPrice", GetGasPrice)
+	// 标准 JSON-RPC 2.0 可以作为 provider 使用
+	server.POST("/rpc", JSONRPC)
+	// 提现 业务系统调用 使用管理令牌认证
+	server.POST("/withdraw", AdminRequired(conf.App.AdminToken), Withdraw)
+	server.GET("/getWithdraw", AdminRequired(conf.App.AdminToken), GetWithdraw)
+	server.POST("/payout", AdminRequired(conf.App.AdminToken), BatchPayout)
+	server.GET("/getPayout", AdminRequired(conf.App.AdminToken), GetPayout)
+	// 归集
+	server.POST("/collection", AdminRequired(conf.App.AdminToken), Collection)
+	server.GET("/getCollection", AdminRequired(conf.App.AdminToken), GetCollection)
+	server.GET("/getGasSpent", AdminRequired(conf.App.AdminToken), GetGasSpent)
+	// 合约 ABI 上传后可以按方法名调用 监听和历史记录会解析调用参数和事件
+	server.POST("/addContractAbi", AdminRequired(conf.App.AdminToken), AddContractAbi)
+	server.POST("/delContractAbi", AdminRequired(conf.App.AdminToken), DelContractAbi)
+	server.GET("/getContractAbi", GetContractAbi)
 
 	err = server.Run(fmt.Sprintf(":%v", conf.App.Port))
 	if err != nil {
@@ -0,0 +1,24 @@
+package types
+
+// AbiParam 按合约 ABI 解析出的参数 数字为 10 进制字符串 字节为 16 进制
+type AbiParam struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// DecodedCall 按合约 ABI 解析出的调用
+type DecodedCall struct {
+	Method    string     `json:"method"`
+	Signature string     `json:"signature"`
+	Params    []AbiParam `json:"params"`
+}
+
+// DecodedLog 按合约 ABI 解析出的事件日志
+type DecodedLog struct {
+	Address   string     `json:"address"`
+	LogIndex  uint       `json:"logIndex"`
+	Event     string     `json:"event"`
+	Signature string     `json:"signature"`
+	Params    []AbiParam `json:"params"`
+}
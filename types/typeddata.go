@@ -387,6 +387,32 @@ func (typedData *TypedData) validate() error {
 	return nil
 }
 
+// Validate 检查类型和域是否有效 primaryType 和 EIP712Domain 必须有定义
+func (typedData *TypedData) Validate() error {
+	if err := typedData.validate(); err != nil {
+		return err
+	}
+	if _, ok := typedData.Types["EIP712Domain"]; !ok {
+		return errors.New("EIP712Domain type is undefined")
+	}
+	if _, ok := typedData.Types[typedData.PrimaryType]; !ok {
+		return fmt.Errorf("primary type %q is undefined", typedData.PrimaryType)
+	}
+	return nil
+}
+
+// HasArray 是否使用了数组类型 v3 不支持数组
+func (typedData *TypedData) HasArray() bool {
+	for _, typeArr := range typedData.Types {
+		for _, typeObj := range typeArr {
+			if typeObj.isArray() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Map generates a map version of the typed data
 func (typedData *TypedData) Map() map[string]interface{} {
 	dataMap := map[string]interface{}{
@@ -0,0 +1,104 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// LegacyTypedDataField eth_signTypedData（v1）的一个字段
+type LegacyTypedDataField struct {
+	Type  string      `json:"type"`
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// LegacyTypedDataHash v1 的签名哈希 和 eth-sig-util 的 typedSignatureHash 一致
+// keccak256(keccak256(类型和名称) ++ keccak256(按类型紧密打包的值)) 不支持数组
+func LegacyTypedDataHash(fields []LegacyTypedDataField) ([]byte, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("typed data is empty")
+	}
+	var schema, values []byte
+	for i, f := range fields {
+		if f.Name == "" || f.Type == "" {
+			return nil, fmt.Errorf("field %d: empty name or type", i)
+		}
+		schema = append(schema, f.Type+" "+f.Name...)
+		packed, err := packLegacyValue(f.Type, f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", f.Name, err)
+		}
+		values = append(values, packed...)
+	}
+	return crypto.Keccak256(crypto.Keccak256(schema), crypto.Keccak256(values)), nil
+}
+
+// packLegacyValue 按 solidity 的 abi.encodePacked 打包一个值
+func packLegacyValue(encType string, encValue interface{}) ([]byte, error) {
+	switch encType {
+	case "address":
+		s, ok := encValue.(string)
+		if !ok || !common.IsHexAddress(s) {
+			return nil, dataMismatchError(encType, encValue)
+		}
+		return common.HexToAddress(s).Bytes(), nil
+	case "bool":
+		b, ok := encValue.(bool)
+		if !ok {
+			return nil, dataMismatchError(encType, encValue)
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case "string":
+		s, ok := encValue.(string)
+		if !ok {
+			return nil, dataMismatchError(encType, encValue)
+		}
+		return []byte(s), nil
+	case "bytes":
+		b, ok := parseBytes(encValue)
+		if !ok {
+			return nil, dataMismatchError(encType, encValue)
+		}
+		return b, nil
+	}
+	if strings.HasSuffix(encType, "]") {
+		return nil, fmt.Errorf("array type %q is not supported", encType)
+	}
+	if strings.HasPrefix(encType, "bytes") {
+		length, err := strconv.Atoi(strings.TrimPrefix(encType, "bytes"))
+		if err != nil || length < 1 || length > 32 {
+			return nil, fmt.Errorf("invalid size on bytes: %s", encType)
+		}
+		b, ok := parseBytes(encValue)
+		if !ok || len(b) != length {
+			return nil, dataMismatchError(encType, encValue)
+		}
+		return b, nil
+	}
+	if strings.HasPrefix(encType, "int") || strings.HasPrefix(encType, "uint") {
+		size := 256
+		if n := strings.TrimLeft(encType, "uint"); n != "" {
+			var err error
+			if size, err = strconv.Atoi(n); err != nil || size < 8 || size > 256 || size%8 != 0 {
+				return nil, fmt.Errorf("invalid size on integer: %s", encType)
+			}
+		}
+		b, err := parseInteger(encType, encValue)
+		if err != nil {
+			return nil, err
+		}
+		// 负数按补码
+		return math.U256Bytes(new(big.Int).Set(b))[32-size/8:], nil
+	}
+	return nil, fmt.Errorf("unrecognized type '%s'", encType)
+}
@@ -24,20 +24,29 @@ type Transfer struct {
 }
 
 type Transaction struct {
-	BlockNumber *big.Int // 区块号
-	BlockHash   string   // 区块哈希
-	Hash        string   // 交易hash
-	From        string   // 交易者
-	To          string   // 接收者
-	Nonce       uint64   // 序号
-	Gas         uint64   // gas
-	GasFeeCap   *big.Int // gasFeeCap
-	GasTipCap   *big.Int // gasTipCap
-	Value       *big.Int // 交易数量
-	Data        []byte   // 交易数据
-	Status      uint     // 状态（0：失败，1：成功）
-	HasCheck    bool     // 是否已经检查过 为 false 的话表示处于 pending 状态
-	Dirty       bool     // 是否已经写入数据库 false 未写入  true 已写入
+	BlockNumber     *big.Int      // 区块号
+	BlockHash       string        // 区块哈希
+	Hash            string        // 交易hash
+	From            string        // 交易者
+	To              string        // 接收者
+	Nonce           uint64        // 序号
+	Gas             uint64        // gas
+	GasFeeCap       *big.Int      // gasFeeCap
+	GasTipCap       *big.Int      // gasTipCap
+	Value           *big.Int      // 交易数量
+	Data            []byte        // 交易数据
+	Status          uint          // 状态（0：失败，1：成功）
+	HasCheck        bool          // 是否已经检查过 为 false 的话表示处于 pending 状态
+	Dirty           bool          // 是否已经写入数据库 false 未写入  true 已写入
+	ContractAddress string        // 部署合约的交易 To 为空 这里是凭证中的合约地址
+	Action          string        // 交易类型 为空表示普通转账
+	LogIndex        uint          // 代币转账对应的 Transfer 事件的日志序号 原生币为 0
+	SentAt          int64         // 本服务广播的时间 毫秒级时间戳
+	Bumps           int           // 自动加速的次数
+	Tier            string        // 发送时的费用档位 自动加速按这个档位当前的费用判断 为空按 normal
+	Raw             []byte        // 签名后的交易 被节点丢弃时重新广播
+	Call            *DecodedCall  // 按上传的 ABI 解析出的调用 没有上传为空
+	Events          []*DecodedLog // 按上传的 ABI 解析出的事件日志
 }
 
 // PersinalSignature